@@ -12,6 +12,7 @@ import (
 	autospotting "github.com/vkhodor/AutoSpotting/core"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
 )
 
 var conf autospotting.Config
@@ -23,17 +24,47 @@ func main() {
 	if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
 		lambda.Start(Handler)
 	} else {
-		run()
+		run(context.Background())
 	}
 }
 
-func run() {
+// run processes all the regions, until the deadline of the context if any,
+// such as the timeout of the Lambda function
+func run(ctx context.Context) {
 
 	log.Println("Starting autospotting agent, build", Version)
 	log.Printf("Configuration flags: %#v", conf)
 
-	autospotting.Run(&conf)
-	log.Println("Execution completed, nothing left to do")
+	if conf.OffboardAutoScalingGroups != "" {
+		autospotting.Offboard(&conf)
+		return
+	}
+
+	if conf.ReportMode {
+		// keep the report separated from the logs
+		conf.LogFile = os.Stderr
+		autospotting.Report(ctx, &conf, os.Stdout)
+		return
+	}
+
+	autospotting.Run(ctx, &conf)
+	log.Println("Execution completed")
+}
+
+// runTarget only processes the regions and groups of the target, which needs
+// to be meant for the account of the Lambda function
+func runTarget(ctx context.Context, target *autospotting.Target) {
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		if err := target.Validate(lc.InvokedFunctionArn); err != nil {
+			log.Println("Refusing to process the target:", err.Error())
+			return
+		}
+	}
+
+	log.Printf("Processing the target %+v", *target)
+	targetConf := target.Apply(conf)
+	autospotting.Run(ctx, &targetConf)
+	log.Println("Execution completed")
 }
 
 // this is the equivalent of a main for when running from Lambda, but on Lambda
@@ -52,6 +83,12 @@ func Handler(ctx context.Context, rawEvent json.RawMessage) {
 	var cloudwatchEvent events.CloudWatchEvent
 	parseEvent := rawEvent
 
+	// Invoked with an explicit target, only processing it
+	if target, err := autospotting.ParseTarget(rawEvent); err == nil && target != nil {
+		runTarget(ctx, target)
+		return
+	}
+
 	// Try to parse event as an Sns Message
 	if err := json.Unmarshal(parseEvent, &snsEvent); err != nil {
 		log.Println(err.Error())
@@ -78,7 +115,8 @@ func Handler(ctx context.Context, rawEvent json.RawMessage) {
 		}
 
 		spotTermination := autospotting.NewSpotTermination(cloudwatchEvent.Region)
-		if spotTermination.IsInAutoSpottingASG(instanceID, conf.TagFilteringMode, conf.FilterByTags) {
+		if spotTermination.IsInAutoSpottingASG(instanceID, conf.TagFilteringMode, conf.FilterByTags,
+			conf.GroupSelector) {
 			err := spotTermination.ExecuteAction(instanceID, conf.TerminationNotificationAction)
 			if err != nil {
 				log.Printf("Error executing spot termination action: %s\n", err.Error())
@@ -87,8 +125,11 @@ func Handler(ctx context.Context, rawEvent json.RawMessage) {
 			log.Printf("Instance %s is not in AutoSpotting ASG\n", *instanceID)
 			return
 		}
+	} else if autospotting.IsInstanceLaunchEvent(cloudwatchEvent) {
+		// Event is an instance launch, only processing its group
+		autospotting.ProcessInstanceLaunchEvent(ctx, &conf, cloudwatchEvent)
 	} else {
 		// Event is Autospotting Cron Scheduling
-		run()
+		run(ctx)
 	}
 }
@@ -0,0 +1,135 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The kinds of accelerators attached to the instance types
+const (
+	acceleratorGPU       = "gpu"
+	acceleratorFPGA      = "fpga"
+	acceleratorInference = "inference"
+)
+
+// mergeAcceleratorSpecs copies the GPU, FPGA and inference accelerator details
+// reported by the EC2 API, which are missing from the static data.
+func (info *instanceTypeInformation) mergeAcceleratorSpecs(spec *ec2.InstanceTypeInfo) {
+	switch {
+	case spec.GpuInfo != nil && len(spec.GpuInfo.Gpus) > 0:
+		gpu := spec.GpuInfo.Gpus[0]
+		info.acceleratorType = acceleratorGPU
+		info.acceleratorModel = acceleratorModel(gpu.Manufacturer, gpu.Name)
+		info.gpuMemory = float32(aws.Int64Value(spec.GpuInfo.TotalGpuMemoryInMiB)) / 1024
+		info.acceleratorCount = info.GPU
+
+	case spec.FpgaInfo != nil && len(spec.FpgaInfo.Fpgas) > 0:
+		fpga := spec.FpgaInfo.Fpgas[0]
+		info.acceleratorType = acceleratorFPGA
+		info.acceleratorModel = acceleratorModel(fpga.Manufacturer, fpga.Name)
+		info.acceleratorCount = 0
+		for _, f := range spec.FpgaInfo.Fpgas {
+			info.acceleratorCount += int(aws.Int64Value(f.Count))
+		}
+
+	case spec.InferenceAcceleratorInfo != nil && len(spec.InferenceAcceleratorInfo.Accelerators) > 0:
+		accelerator := spec.InferenceAcceleratorInfo.Accelerators[0]
+		info.acceleratorType = acceleratorInference
+		info.acceleratorModel = acceleratorModel(accelerator.Manufacturer, accelerator.Name)
+		info.acceleratorCount = 0
+		for _, a := range spec.InferenceAcceleratorInfo.Accelerators {
+			info.acceleratorCount += int(aws.Int64Value(a.Count))
+		}
+	}
+}
+
+// acceleratorModel names the accelerator models like "NVIDIA V100"
+func acceleratorModel(manufacturer, name *string) string {
+	return strings.TrimSpace(aws.StringValue(manufacturer) + " " + aws.StringValue(name))
+}
+
+// accelerator returns the kind of accelerators attached to the instance type,
+// also for the GPU instance types only known from the static data, which
+// contains just the number of GPUs.
+func (info instanceTypeInformation) accelerator() string {
+	if info.acceleratorType == "" && info.GPU > 0 {
+		return acceleratorGPU
+	}
+	return info.acceleratorType
+}
+
+// isAllowedAcceleratorModel checks if the model is in the comma separated list
+// of accepted models, which can be given with or without the manufacturer name,
+// such as "NVIDIA V100" or just "V100".
+func isAllowedAcceleratorModel(model string, allowedModels string) bool {
+	for _, allowed := range strings.Split(allowedModels, ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "" {
+			continue
+		}
+		if strings.EqualFold(model, allowed) ||
+			strings.HasSuffix(strings.ToLower(model), " "+strings.ToLower(allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAcceleratorCompatible makes sure the instances using GPUs, FPGAs or
+// inference accelerators are only replaced with instance types having the same
+// kind and model of accelerators, at least as many of them and at least as much
+// GPU memory, since the software running on them is usually built for a
+// specific accelerator. Other models can be accepted for a group using the
+// autospotting_allowed_accelerator_models tag. When the model of the current
+// instance type is unknown only the number of GPUs is compared.
+func (i *instance) isAcceleratorCompatible(spotCandidate instanceTypeInformation) bool {
+	current := i.typeInfo
+
+	if current.accelerator() == "" {
+		return true
+	}
+
+	debug.Println("Comparing accelerators spot/instance:")
+	debug.Println("\tSpot type/model/count/GPU memory: ", spotCandidate.accelerator(), " / ",
+		spotCandidate.acceleratorModel, " / ", spotCandidate.acceleratorCount, " / ", spotCandidate.gpuMemory)
+	debug.Println("\tInstance type/model/count/GPU memory: ", current.accelerator(), " / ",
+		current.acceleratorModel, " / ", current.acceleratorCount, " / ", current.gpuMemory)
+
+	if spotCandidate.accelerator() != current.accelerator() {
+		debug.Println("\tNot accelerator compatible, different kind of accelerators")
+		return false
+	}
+
+	if current.acceleratorModel == "" {
+		return true
+	}
+
+	allowedModels := ""
+	if i.asg != nil {
+		allowedModels = i.asg.config.AllowedAcceleratorModels
+	}
+
+	if spotCandidate.acceleratorModel != current.acceleratorModel &&
+		(spotCandidate.acceleratorModel == "" ||
+			!isAllowedAcceleratorModel(spotCandidate.acceleratorModel, allowedModels)) {
+		debug.Println("\tNot accelerator compatible, model", spotCandidate.acceleratorModel,
+			"is different from", current.acceleratorModel)
+		return false
+	}
+
+	if spotCandidate.acceleratorCount < current.acceleratorCount {
+		debug.Println("\tNot accelerator compatible, fewer accelerators")
+		return false
+	}
+
+	if spotCandidate.gpuMemory < current.gpuMemory {
+		debug.Println("\tNot accelerator compatible, less GPU memory")
+		return false
+	}
+	return true
+}
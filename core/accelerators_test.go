@@ -0,0 +1,171 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_instanceTypeInformation_mergeAcceleratorSpecs(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     *ec2.InstanceTypeInfo
+		expected instanceTypeInformation
+	}{
+		{
+			name: "GPU",
+			spec: &ec2.InstanceTypeInfo{
+				GpuInfo: &ec2.GpuInfo{
+					Gpus: []*ec2.GpuDeviceInfo{
+						{Count: aws.Int64(4), Manufacturer: aws.String("NVIDIA"), Name: aws.String("V100")},
+					},
+					TotalGpuMemoryInMiB: aws.Int64(65536),
+				},
+			},
+			expected: instanceTypeInformation{
+				GPU:              4,
+				acceleratorType:  acceleratorGPU,
+				acceleratorModel: "NVIDIA V100",
+				acceleratorCount: 4,
+				gpuMemory:        64,
+			},
+		},
+		{
+			name: "FPGA",
+			spec: &ec2.InstanceTypeInfo{
+				FpgaInfo: &ec2.FpgaInfo{
+					Fpgas: []*ec2.FpgaDeviceInfo{
+						{Count: aws.Int64(2), Manufacturer: aws.String("Xilinx"), Name: aws.String("Virtex UltraScale (VU9P)")},
+					},
+				},
+			},
+			expected: instanceTypeInformation{
+				acceleratorType:  acceleratorFPGA,
+				acceleratorModel: "Xilinx Virtex UltraScale (VU9P)",
+				acceleratorCount: 2,
+			},
+		},
+		{
+			name: "inference",
+			spec: &ec2.InstanceTypeInfo{
+				InferenceAcceleratorInfo: &ec2.InferenceAcceleratorInfo{
+					Accelerators: []*ec2.InferenceDeviceInfo{
+						{Count: aws.Int64(1), Manufacturer: aws.String("AWS"), Name: aws.String("Inferentia")},
+					},
+				},
+			},
+			expected: instanceTypeInformation{
+				acceleratorType:  acceleratorInference,
+				acceleratorModel: "AWS Inferentia",
+				acceleratorCount: 1,
+			},
+		},
+		{
+			name:     "no accelerators",
+			spec:     &ec2.InstanceTypeInfo{},
+			expected: instanceTypeInformation{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var info instanceTypeInformation
+			info.mergeSpecs(tt.spec)
+			if !reflect.DeepEqual(info, tt.expected) {
+				t.Errorf("mergeSpecs() = %+v, expected %+v", info, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_isAllowedAcceleratorModel(t *testing.T) {
+	tests := []struct {
+		model    string
+		allowed  string
+		expected bool
+	}{
+		{model: "NVIDIA T4", allowed: "NVIDIA T4", expected: true},
+		{model: "NVIDIA T4", allowed: "nvidia t4", expected: true},
+		{model: "NVIDIA T4", allowed: "V100, T4", expected: true},
+		{model: "NVIDIA T4", allowed: "V100", expected: false},
+		{model: "NVIDIA T4", allowed: "", expected: false},
+		{model: "NVIDIA A10G", allowed: "10G", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model+"/"+tt.allowed, func(t *testing.T) {
+			if got := isAllowedAcceleratorModel(tt.model, tt.allowed); got != tt.expected {
+				t.Errorf("isAllowedAcceleratorModel() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_isAcceleratorCompatible(t *testing.T) {
+	v100 := instanceTypeInformation{
+		instanceType:     "p3.2xlarge",
+		GPU:              1,
+		acceleratorType:  acceleratorGPU,
+		acceleratorModel: "NVIDIA V100",
+		acceleratorCount: 1,
+		gpuMemory:        16,
+	}
+	t4 := instanceTypeInformation{
+		instanceType:     "g4dn.2xlarge",
+		GPU:              1,
+		acceleratorType:  acceleratorGPU,
+		acceleratorModel: "NVIDIA T4",
+		acceleratorCount: 1,
+		gpuMemory:        16,
+	}
+	inferentia := instanceTypeInformation{
+		instanceType:     "inf1.2xlarge",
+		acceleratorType:  acceleratorInference,
+		acceleratorModel: "AWS Inferentia",
+		acceleratorCount: 1,
+	}
+	staticGPU := instanceTypeInformation{instanceType: "p2.xlarge", GPU: 1}
+
+	biggerV100 := v100
+	biggerV100.instanceType, biggerV100.GPU, biggerV100.acceleratorCount, biggerV100.gpuMemory =
+		"p3.8xlarge", 4, 4, 64
+
+	smallerT4 := t4
+	smallerT4.gpuMemory = 8
+
+	tests := []struct {
+		name          string
+		current       instanceTypeInformation
+		candidate     instanceTypeInformation
+		allowedModels string
+		expected      bool
+	}{
+		{name: "no accelerators", current: instanceTypeInformation{}, candidate: inferentia, expected: true},
+		{name: "same model", current: v100, candidate: biggerV100, expected: true},
+		{name: "fewer accelerators", current: biggerV100, candidate: v100, expected: false},
+		{name: "different model", current: v100, candidate: t4, expected: false},
+		{name: "different model allowed", current: v100, candidate: t4, allowedModels: "T4", expected: true},
+		{name: "less GPU memory", current: v100, candidate: smallerT4, allowedModels: "T4", expected: false},
+		{name: "different kind of accelerators", current: v100, candidate: inferentia, allowedModels: "Inferentia", expected: false},
+		{name: "unknown candidate model", current: v100, candidate: staticGPU, expected: false},
+		{name: "unknown current model", current: staticGPU, candidate: t4, expected: true},
+		{name: "GPU replaced without GPUs", current: staticGPU, candidate: instanceTypeInformation{}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				typeInfo: tt.current,
+				asg:      &autoScalingGroup{config: AutoScalingConfig{AllowedAcceleratorModels: tt.allowedModels}},
+			}
+			if got := i.isAcceleratorCompatible(tt.candidate); got != tt.expected {
+				t.Errorf("isAcceleratorCompatible() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
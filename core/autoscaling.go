@@ -4,8 +4,10 @@
 package autospotting
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -23,6 +25,7 @@ type autoScalingGroup struct {
 	instances           instances
 	minOnDemand         int64
 	config              AutoScalingConfig
+	offboarding         bool
 }
 
 func (a *autoScalingGroup) loadLaunchConfiguration() (*launchConfiguration, error) {
@@ -56,7 +59,7 @@ func (a *autoScalingGroup) loadLaunchConfiguration() (*launchConfiguration, erro
 	return a.launchConfiguration, nil
 }
 
-func (a *autoScalingGroup) needReplaceOnDemandInstances() bool {
+func (a *autoScalingGroup) needReplaceOnDemandInstances(ctx context.Context) bool {
 	onDemandRunning, totalRunning := a.alreadyRunningInstanceCount(false, nil)
 	if onDemandRunning > a.minOnDemand {
 		logger.Println("Currently more than enough OnDemand instances running")
@@ -69,24 +72,56 @@ func (a *autoScalingGroup) needReplaceOnDemandInstances() bool {
 	logger.Println("Currently fewer OnDemand instances than required !")
 	if a.allInstancesRunning() && a.instances.count64() >= *a.DesiredCapacity {
 		logger.Println("All instances are running and desired capacity is satisfied")
-		if randomSpot := a.getAnySpotInstance(); randomSpot != nil {
-			if totalRunning == 1 {
-				logger.Println("Warning: blocking replacement of very last instance - consider raising ASG to >= 2")
-			} else {
-				logger.Println("Terminating a random spot instance",
-					*randomSpot.Instance.InstanceId)
-				switch a.config.TerminationMethod {
-				case DetachTerminationMethod:
-					randomSpot.terminate()
-				default:
-					a.terminateInstanceInAutoScalingGroup(randomSpot.Instance.InstanceId)
-				}
-			}
+		if totalRunning == 1 {
+			logger.Println("Warning: blocking replacement of very last instance - consider raising ASG to >= 2")
+		} else {
+			a.replaceSpotInstancesWithOnDemand(ctx, a.minOnDemand-onDemandRunning)
 		}
 	}
 	return false
 }
 
+// Terminates up to the given number of spot instances without decrementing the
+// desired capacity, so the group launches on-demand instances in their place.
+// No more than the configured number of instances are unavailable at the same
+// time, the following runs terminating the next spot instances once the group
+// is healthy again.
+func (a *autoScalingGroup) replaceSpotInstancesWithOnDemand(ctx context.Context, count int64) {
+	var spotInstances []string
+	for inst := range a.instances.instances() {
+		if inst.isSpot() && aws.StringValue(inst.State.Name) == ec2.InstanceStateNameRunning {
+			spotInstances = append(spotInstances, *inst.InstanceId)
+		}
+	}
+	if len(spotInstances) == 0 {
+		return
+	}
+	sort.Strings(spotInstances)
+
+	unavailable := a.unavailableInstanceCount()
+	budget := a.config.MaxUnavailable - unavailable
+	if budget <= 0 {
+		logger.Println(a.region.name, a.name, "Waiting for", unavailable,
+			"unavailable instances to be replaced and healthy before replacing spot instances")
+		return
+	}
+	if count > budget {
+		count = budget
+	}
+
+	for i, id := range spotInstances {
+		if int64(i) >= count {
+			return
+		}
+		logger.Println(a.region.name, a.name, "Terminating spot instance", id,
+			"to be replaced with an on-demand instance")
+
+		if a.terminateInstanceKeepingCapacity(ctx, aws.String(id)) != nil {
+			return
+		}
+	}
+}
+
 func (a *autoScalingGroup) allInstancesRunning() bool {
 	_, totalRunning := a.alreadyRunningInstanceCount(false, nil)
 	return totalRunning == a.instances.count64()
@@ -118,18 +153,34 @@ func (a *autoScalingGroup) licensedToRun() (bool, error) {
 	return true, nil
 }
 
-func (a *autoScalingGroup) process() {
+func (a *autoScalingGroup) process(ctx context.Context) {
 	var spotInstanceID string
+
+	if !a.enoughTimeLeft(ctx, "processing group "+a.name) {
+		return
+	}
+
 	a.scanInstances()
 	a.loadDefaultConfig()
 	a.loadConfigFromTags()
 
+	if a.offboarding {
+		a.offboard(ctx)
+		return
+	}
+
+	now := time.Now()
+	a.loadScheduledOnDemandTarget(now)
+	a.loadReverseTarget(ctx, now)
+	a.reportOnDemandCoverage()
+
 	logger.Println("Finding spot instances created for", a.name)
 
 	spotInstance := a.findUnattachedInstanceLaunchedForThisASG()
 	debug.Println("Candidate Spot instance", spotInstance)
 
-	shouldRun := cronRunAction(time.Now(), a.config.CronSchedule, a.config.CronTimezone, a.config.CronScheduleState)
+	shouldRun := cronRunAction(now, a.config.CronSchedule, a.config.CronTimezone, a.config.CronScheduleState) &&
+		!insideBlackout(now, a.config.CronBlackoutWindows, a.config.CronTimezone)
 	debug.Println(a.region.name, a.name, "Should take replacement actions:", shouldRun)
 
 	if ok, err := a.licensedToRun(); !ok {
@@ -137,18 +188,25 @@ func (a *autoScalingGroup) process() {
 		return
 	}
 
+	if a.region.launchedInstanceID != "" {
+		a.replaceLaunchedInstance(ctx, shouldRun)
+		return
+	}
+
 	if spotInstance == nil {
 		logger.Println("No spot instances were found for ", a.name)
 
-		onDemandInstance := a.getAnyUnprotectedOnDemandInstance()
+		onDemandInstance := a.getUnprotectedOnDemandInstanceToReplace()
 
 		if onDemandInstance == nil {
 			logger.Println(a.region.name, a.name,
-				"No running unprotected on-demand instances were found, nothing to do here...")
+				"No running unprotected on-demand instances were found, nothing to replace")
+			// converts spot instances back to on-demand when below the target
+			a.needReplaceOnDemandInstances(ctx)
 			return
 		}
 
-		if !a.needReplaceOnDemandInstances() {
+		if !a.needReplaceOnDemandInstances(ctx) {
 			logger.Println("Not allowed to replace any of the running OD instances in ", a.name)
 			return
 		}
@@ -159,11 +217,16 @@ func (a *autoScalingGroup) process() {
 			return
 		}
 
+		if !a.enoughTimeLeft(ctx, "launching a spot replacement for "+
+			*onDemandInstance.InstanceId+" in group "+a.name) {
+			return
+		}
+
 		if _, err := a.loadLaunchConfiguration(); err != nil {
 			logger.Printf("Could not launch configuration: %s", err)
 		}
 
-		err := onDemandInstance.launchSpotReplacement()
+		_, err := onDemandInstance.launchSpotReplacement(ctx)
 		if err != nil {
 			logger.Printf("Could not launch cheapest spot instance: %s", err)
 		}
@@ -172,10 +235,10 @@ func (a *autoScalingGroup) process() {
 
 	spotInstanceID = *spotInstance.InstanceId
 
-	if !a.needReplaceOnDemandInstances() || !shouldRun {
+	if !a.needReplaceOnDemandInstances(ctx) || !shouldRun {
 		logger.Println("Spot instance", spotInstanceID, "is not need anymore by ASG",
 			a.name, "terminating the spot instance.")
-		spotInstance.terminate()
+		spotInstance.terminate(ctx)
 		return
 	}
 	if !spotInstance.isReadyToAttach(a) {
@@ -183,13 +246,29 @@ func (a *autoScalingGroup) process() {
 		return
 	}
 
+	if !a.enoughTimeLeft(ctx, "attaching spot instance "+spotInstanceID+
+		" to group "+a.name) {
+		return
+	}
+
 	logger.Println(a.region.name, "Found spot instance:", spotInstanceID,
 		"Attaching it to", a.name)
 
-	a.replaceOnDemandInstanceWithSpot(spotInstanceID)
+	a.replaceOnDemandInstanceWithSpot(ctx, spotInstanceID)
 
 }
 
+// enoughTimeLeft checks if the action can still be completed before the
+// deadline of the run, using the safety margin of the region's configuration.
+func (a *autoScalingGroup) enoughTimeLeft(ctx context.Context, action string) bool {
+	if enoughTimeLeft(ctx, a.region.conf.DeadlineSafetyMargin, action) {
+		return true
+	}
+	logger.Println(a.region.name, a.name, "Not enough time left for", action,
+		"leaving it for the next run")
+	return false
+}
+
 func (a *autoScalingGroup) scanInstances() instances {
 
 	logger.Println("Adding instances to", a.name)
@@ -210,7 +289,8 @@ func (a *autoScalingGroup) scanInstances() instances {
 		}
 
 		if i.isSpot() {
-			i.price = i.typeInfo.pricing.spot[*i.Placement.AvailabilityZone]
+			i.price = i.typeInfo.pricing.spot[*i.Placement.AvailabilityZone] +
+				i.typeInfo.pricing.licenseSurcharge
 		} else {
 			i.price = i.typeInfo.pricing.onDemand + i.typeInfo.pricing.premium
 		}
@@ -220,7 +300,7 @@ func (a *autoScalingGroup) scanInstances() instances {
 	return a.instances
 }
 
-func (a *autoScalingGroup) replaceOnDemandInstanceWithSpot(
+func (a *autoScalingGroup) replaceOnDemandInstanceWithSpot(ctx context.Context,
 	spotInstanceID string) error {
 
 	// get the details of our spot instance so we can see its AZ
@@ -234,41 +314,207 @@ func (a *autoScalingGroup) replaceOnDemandInstanceWithSpot(
 	logger.Println(a.name, spotInstanceID, "is in the availability zone",
 		*az, "looking for an on-demand instance there")
 
-	odInst := a.getUnprotectedOnDemandInstanceInAZ(az)
+	odInst := a.getUnprotectedOnDemandInstanceToReplaceWithSpotInAZ(az)
 
 	if odInst == nil {
 		logger.Println(a.name, "found no on-demand instances that could be",
 			"replaced with the new spot instance", *spotInst.InstanceId,
 			"terminating the spot instance.")
-		spotInst.terminate()
+		spotInst.terminate(ctx)
 		return errors.New("couldn't find ondemand instance to replace")
 	}
 	logger.Println(a.name, "found on-demand instance", *odInst.InstanceId,
 		"replacing with new spot instance", *spotInst.InstanceId)
 
+	return a.swapOnDemandInstanceWithSpot(ctx, odInst, spotInst)
+}
+
+// Replaces the given on-demand instance with the spot instance, according to
+// the termination method of the group.
+func (a *autoScalingGroup) swapOnDemandInstanceWithSpot(ctx context.Context,
+	odInst *instance, spotInst *instance) error {
+
 	desiredCapacity, maxSize := *a.DesiredCapacity, *a.MaxSize
 
 	// temporarily increase AutoScaling group in case the desired capacity reaches the max size,
 	// otherwise attachSpotInstance might fail
 	if desiredCapacity == maxSize {
 		logger.Println(a.name, "Temporarily increasing MaxSize")
-		a.setAutoScalingMaxSize(maxSize + 1)
-		defer a.setAutoScalingMaxSize(maxSize)
+		a.setAutoScalingMaxSize(ctx, maxSize+1)
+		defer a.setAutoScalingMaxSize(ctx, maxSize)
 	}
 
-	attachErr := a.attachSpotInstance(spotInstanceID)
+	if a.config.InstanceTerminationMethod == StandbyTerminationMethod {
+		return a.swapOnDemandInstanceUsingStandby(ctx, odInst, spotInst)
+	}
+
+	attachErr := a.attachSpotInstance(ctx, *spotInst.InstanceId)
 	if attachErr != nil {
 		logger.Println(a.name, "skipping detaching on-demand due to failure to",
 			"attach the new spot instance ", *spotInst.InstanceId)
 		return nil
 	}
 
-	switch a.config.TerminationMethod {
+	if hooks := a.getLaunchLifecycleHooks(ctx); len(hooks) > 0 {
+		if err := a.runLaunchLifecycleHooks(ctx, spotInst.InstanceId, hooks); err != nil {
+			logger.Println(a.name, "launch lifecycle hooks didn't complete for",
+				*spotInst.InstanceId, "keeping the on-demand instance", *odInst.InstanceId,
+				"running:", err.Error())
+			a.rollBackSpotInstance(ctx, spotInst)
+			return err
+		}
+	}
+
+	switch a.config.InstanceTerminationMethod {
 	case DetachTerminationMethod:
-		return a.detachAndTerminateOnDemandInstance(odInst.InstanceId)
+		return a.detachAndTerminateOnDemandInstance(ctx, odInst.InstanceId)
 	default:
-		return a.terminateInstanceInAutoScalingGroup(odInst.InstanceId)
+		return a.terminateInstanceInAutoScalingGroup(ctx, odInst.InstanceId)
+	}
+}
+
+// Replaces an on-demand instance with a spot instance without a capacity dip:
+// the on-demand instance is moved to Standby while the spot instance is
+// attached, and is only terminated once the spot instance is in service and
+// healthy. Otherwise the spot instance is terminated and the on-demand
+// instance is put back in service.
+func (a *autoScalingGroup) swapOnDemandInstanceUsingStandby(ctx context.Context,
+	odInst *instance, spotInst *instance) error {
+
+	logger.Println(a.region.name, a.name, "Swapping on-demand instance",
+		*odInst.InstanceId, "with spot instance", *spotInst.InstanceId,
+		"using Standby")
+
+	if err := a.enterStandby(ctx, odInst.InstanceId); err != nil {
+		return err
+	}
+
+	if err := a.waitForLifecycleState(ctx, odInst.InstanceId,
+		autoscaling.LifecycleStateStandby, maxLifecycleHookWait); err != nil {
+		return a.putBackInService(ctx, odInst, err)
+	}
+
+	if err := a.attachSpotInstance(ctx, *spotInst.InstanceId); err != nil {
+		logger.Println(a.name, "failed to attach the new spot instance",
+			*spotInst.InstanceId, "putting", *odInst.InstanceId, "back in service")
+		spotInst.terminate(ctx)
+		return a.putBackInService(ctx, odInst, err)
+	}
+
+	if err := a.verifySpotInstance(ctx, spotInst.InstanceId); err != nil {
+		logger.Println(a.name, "spot instance", *spotInst.InstanceId,
+			"failed verification, rolling back and putting", *odInst.InstanceId,
+			"back in service:", err.Error())
+		a.rollBackSpotInstance(ctx, spotInst)
+		return a.putBackInService(ctx, odInst, err)
 	}
+
+	return a.terminateInstanceKeepingCapacity(ctx, odInst.InstanceId)
+}
+
+// Moves the on-demand instance back in service after a failed swap, returning
+// the error that caused the rollback, or a combined error when the instance
+// couldn't be moved out of Standby, in which case it needs manual attention.
+func (a *autoScalingGroup) putBackInService(ctx context.Context,
+	odInst *instance, cause error) error {
+
+	if err := a.exitStandby(ctx, odInst.InstanceId); err != nil {
+		logger.Println(a.region.name, a.name, "On-demand instance", *odInst.InstanceId,
+			"was left in Standby after the failed swap, it needs to be put back in service manually")
+		return fmt.Errorf("%s, and failed to put instance %s back in service: %s",
+			cause.Error(), *odInst.InstanceId, err.Error())
+	}
+	return cause
+}
+
+// Waits for a newly attached spot instance to be in service, running the
+// launch lifecycle hooks of the group if there are any, and then checks that
+// the group considers it healthy.
+func (a *autoScalingGroup) verifySpotInstance(ctx context.Context, spotInstanceID *string) error {
+	var err error
+
+	if hooks := a.getLaunchLifecycleHooks(ctx); len(hooks) > 0 {
+		err = a.runLaunchLifecycleHooks(ctx, spotInstanceID, hooks)
+	} else {
+		err = a.waitForLifecycleState(ctx, spotInstanceID,
+			autoscaling.LifecycleStateInService, maxLifecycleHookWait)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	inst, err := a.getAutoScalingInstance(ctx, spotInstanceID)
+	if err != nil {
+		return err
+	}
+
+	if inst == nil || inst.HealthStatus == nil ||
+		!strings.EqualFold(*inst.HealthStatus, "healthy") {
+		return fmt.Errorf("instance %s is not healthy", *spotInstanceID)
+	}
+	return nil
+}
+
+// Terminates a spot instance we attached to the group which failed to get in
+// service, without leaving the group with more capacity than before attaching
+// it. Depending on where the launch lifecycle hooks left it, the instance may
+// be in service, in Standby or already out of the group. When a launch hook
+// abandoned it, AutoScaling terminates it without decrementing the desired
+// capacity raised by the attachment, so it's decremented here.
+func (a *autoScalingGroup) rollBackSpotInstance(ctx context.Context, spotInst *instance) error {
+	logger.Println(a.region.name, a.name, "Rolling back the attachment of spot instance",
+		*spotInst.InstanceId)
+
+	state, err := a.getInstanceLifecycleState(ctx, spotInst.InstanceId)
+	if err != nil {
+		logger.Println(a.name, "Failed to determine lifecycle state of",
+			*spotInst.InstanceId, err.Error())
+		return err
+	}
+
+	switch {
+	case state == "":
+		return spotInst.terminate(ctx)
+	case state == autoscaling.LifecycleStateStandby:
+		return a.terminateInstanceKeepingCapacity(ctx, spotInst.InstanceId)
+	case strings.HasPrefix(state, autoscaling.LifecycleStateTerminating) ||
+		state == autoscaling.LifecycleStateTerminated:
+		return a.decrementDesiredCapacity(ctx)
+	default:
+		return a.terminateInstanceInAutoScalingGroup(ctx, spotInst.InstanceId)
+	}
+}
+
+// Lowers the current desired capacity of the group by one.
+func (a *autoScalingGroup) decrementDesiredCapacity(ctx context.Context) error {
+	svc := a.region.services.autoScaling
+
+	resp, err := svc.DescribeAutoScalingGroupsWithContext(ctx,
+		&autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: []*string{aws.String(a.name)},
+		})
+	if err != nil {
+		logger.Println(a.name, "Failed to describe the group:", err.Error())
+		return err
+	}
+	if resp == nil || len(resp.AutoScalingGroups) == 0 {
+		return fmt.Errorf("group %s not found", a.name)
+	}
+
+	desiredCapacity := aws.Int64Value(resp.AutoScalingGroups[0].DesiredCapacity) - 1
+	logger.Println(a.region.name, a.name, "Decrementing the desired capacity to", desiredCapacity)
+
+	_, err = svc.UpdateAutoScalingGroupWithContext(ctx,
+		&autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(a.name),
+			DesiredCapacity:      aws.Int64(desiredCapacity),
+		})
+	if err != nil {
+		logger.Println(err.Error())
+		return err
+	}
+	return nil
 }
 
 // Returns the information about the first running instance found in
@@ -308,6 +554,12 @@ func (a *autoScalingGroup) getInstance(
 					debug.Println(a.name, "skipping protected instance", *i.InstanceId)
 					continue
 				}
+
+				if onDemand && i.coveredBy != "" {
+					debug.Println(a.name, "skipping instance", *i.InstanceId,
+						"covered by a", i.coveredBy)
+					continue
+				}
 			}
 
 			if (availabilityZone != nil) && (*availabilityZone != *i.Placement.AvailabilityZone) {
@@ -408,10 +660,10 @@ func (a *autoScalingGroup) getDisallowedInstanceTypes(baseInstance *instance) []
 	})
 }
 
-func (a *autoScalingGroup) setAutoScalingMaxSize(maxSize int64) error {
+func (a *autoScalingGroup) setAutoScalingMaxSize(ctx context.Context, maxSize int64) error {
 	svc := a.region.services.autoScaling
 
-	_, err := svc.UpdateAutoScalingGroup(
+	_, err := svc.UpdateAutoScalingGroupWithContext(ctx,
 		&autoscaling.UpdateAutoScalingGroupInput{
 			AutoScalingGroupName: aws.String(a.name),
 			MaxSize:              aws.Int64(maxSize),
@@ -426,7 +678,7 @@ func (a *autoScalingGroup) setAutoScalingMaxSize(maxSize int64) error {
 	return nil
 }
 
-func (a *autoScalingGroup) attachSpotInstance(spotInstanceID string) error {
+func (a *autoScalingGroup) attachSpotInstance(ctx context.Context, spotInstanceID string) error {
 
 	svc := a.region.services.autoScaling
 
@@ -437,7 +689,7 @@ func (a *autoScalingGroup) attachSpotInstance(spotInstanceID string) error {
 		},
 	}
 
-	resp, err := svc.AttachInstances(&params)
+	resp, err := svc.AttachInstancesWithContext(ctx, &params)
 
 	if err != nil {
 		logger.Println(err.Error())
@@ -450,7 +702,7 @@ func (a *autoScalingGroup) attachSpotInstance(spotInstanceID string) error {
 
 // Terminates an on-demand instance from the group,
 // but only after it was detached from the autoscaling group
-func (a *autoScalingGroup) detachAndTerminateOnDemandInstance(
+func (a *autoScalingGroup) detachAndTerminateOnDemandInstance(ctx context.Context,
 	instanceID *string) error {
 	logger.Println(a.region.name,
 		a.name,
@@ -467,20 +719,24 @@ func (a *autoScalingGroup) detachAndTerminateOnDemandInstance(
 
 	asSvc := a.region.services.autoScaling
 
-	if _, err := asSvc.DetachInstances(&detachParams); err != nil {
+	if _, err := asSvc.DetachInstancesWithContext(ctx, &detachParams); err != nil {
 		logger.Println(err.Error())
 		return err
 	}
 
 	// Wait till detachment initialize is complete before terminate instance
-	time.Sleep(20 * time.Second * a.region.conf.SleepMultiplier)
+	if err := sleepContext(ctx, 20*time.Second*a.region.conf.SleepMultiplier); err != nil {
+		logger.Println(a.region.name, a.name, "Not terminating the detached instance",
+			*instanceID, err.Error())
+		return err
+	}
 
-	return a.instances.get(*instanceID).terminate()
+	return a.instances.get(*instanceID).terminate(ctx)
 }
 
 // Terminates an on-demand instance from the group using the
 // TerminateInstanceInAutoScalingGroup api call.
-func (a *autoScalingGroup) terminateInstanceInAutoScalingGroup(
+func (a *autoScalingGroup) terminateInstanceInAutoScalingGroup(ctx context.Context,
 	instanceID *string) error {
 	logger.Println(a.region.name,
 		a.name,
@@ -493,7 +749,31 @@ func (a *autoScalingGroup) terminateInstanceInAutoScalingGroup(
 	}
 
 	asSvc := a.region.services.autoScaling
-	if _, err := asSvc.TerminateInstanceInAutoScalingGroup(&terminateParams); err != nil {
+	if _, err := asSvc.TerminateInstanceInAutoScalingGroupWithContext(ctx, &terminateParams); err != nil {
+		logger.Println(err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Terminates an instance of the group without decrementing its desired
+// capacity. The group launches a replacement for an instance in service, while
+// Standby instances are no longer counted in the desired capacity, so nothing
+// is launched for them.
+func (a *autoScalingGroup) terminateInstanceKeepingCapacity(ctx context.Context, instanceID *string) error {
+	logger.Println(a.region.name,
+		a.name,
+		"Terminating instance without changing the desired capacity:",
+		*instanceID)
+
+	terminateParams := autoscaling.TerminateInstanceInAutoScalingGroupInput{
+		InstanceId:                     instanceID,
+		ShouldDecrementDesiredCapacity: aws.Bool(false),
+	}
+
+	asSvc := a.region.services.autoScaling
+	if _, err := asSvc.TerminateInstanceInAutoScalingGroupWithContext(ctx, &terminateParams); err != nil {
 		logger.Println(err.Error())
 		return err
 	}
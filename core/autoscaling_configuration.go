@@ -6,6 +6,7 @@ package autospotting
 import (
 	"math"
 	"strconv"
+	"time"
 )
 
 const (
@@ -33,6 +34,15 @@ const (
 	// current spot price to place the bid
 	SpotPriceBufferPercentageTag = "autospotting_spot_price_buffer_percentage"
 
+	// MinSavingsPercentageTag is the name of a tag that can be defined on a
+	// per-group level for overriding the minimum savings percentage compared
+	// to the on-demand price required for replacing instances
+	MinSavingsPercentageTag = "autospotting_min_savings_percentage"
+
+	// SpotPriceCeilingTag is the name of a tag that can be defined on a
+	// per-group level for overriding the maximum hourly spot price
+	SpotPriceCeilingTag = "autospotting_spot_price_ceiling"
+
 	// AllowedInstanceTypesTag is the name of a tag that can indicate which
 	// instance types are allowed in the current group
 	AllowedInstanceTypesTag = "autospotting_allowed_instance_types"
@@ -84,9 +94,87 @@ const (
 	// can override the global value of the CronScheduleState parameter
 	CronScheduleStateTag = "autospotting_cron_schedule_state"
 
+	// CronBlackoutWindowsTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the CronBlackoutWindows
+	// parameter
+	CronBlackoutWindowsTag = "autospotting_cron_blackout_windows"
+
 	// PatchBeanstalkUserdataTag is the name of the tag set on the AutoScaling Group that
 	// can override the global value of the PatchBeanstalkUserdata parameter
 	PatchBeanstalkUserdataTag = "patch_beanstalk_userdata"
+
+	// SubnetFailoverTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the SubnetFailover parameter
+	SubnetFailoverTag = "autospotting_subnet_failover"
+
+	// NetworkCompatibilityTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the NetworkCompatibility
+	// parameter
+	NetworkCompatibilityTag = "autospotting_network_compatibility"
+
+	// AllowCPUVendorSubstitutionTag is the name of the tag set on the
+	// AutoScaling Group that can override the global value of the
+	// AllowCPUVendorSubstitution parameter
+	AllowCPUVendorSubstitutionTag = "autospotting_allow_cpu_vendor_substitution"
+
+	// AllowedAcceleratorModelsTag is the name of the tag set on the
+	// AutoScaling Group listing the GPU, FPGA or inference accelerator models
+	// that can replace the ones of its on-demand instances
+	AllowedAcceleratorModelsTag = "autospotting_allowed_accelerator_models"
+
+	// BurstableCompatibilityTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the BurstableCompatibility
+	// parameter
+	BurstableCompatibilityTag = "autospotting_burstable_compatibility"
+
+	// AllowHypervisorChangeTag is the name of the tag set on the AutoScaling
+	// Group to acknowledge that its instances can be replaced with instance
+	// types running on a different hypervisor, such as Xen and Nitro, which
+	// also changes the naming of the EBS volume devices
+	AllowHypervisorChangeTag = "autospotting_allow_hypervisor_change"
+
+	// OnDemandPercentageScheduleTag is the name of the tag set on the
+	// AutoScaling Group that can override the global value of the
+	// OnDemandPercentageSchedule parameter
+	OnDemandPercentageScheduleTag = "autospotting_on_demand_percentage_schedule"
+
+	// ReverseSpotPriceRatioTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the ReverseSpotPriceRatio
+	// parameter
+	ReverseSpotPriceRatioTag = "autospotting_reverse_spot_price_ratio"
+
+	// ReverseInterruptionThresholdTag is the name of the tag set on the
+	// AutoScaling Group that can override the global value of the
+	// ReverseInterruptionThreshold parameter
+	ReverseInterruptionThresholdTag = "autospotting_reverse_interruption_threshold"
+
+	// ReverseMinDurationTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the ReverseMinDuration
+	// parameter
+	ReverseMinDurationTag = "autospotting_reverse_min_duration"
+
+	// ReverseTriggeredAtTag is the name of the tag we set on the AutoScaling
+	// Group to the last time its spot instances were found unattractive
+	ReverseTriggeredAtTag = "autospotting_reverse_triggered_at"
+
+	// DefaultReverseMinDuration is the default time for which a group keeps
+	// its on-demand instances after its spot instances were last found
+	// unattractive
+	DefaultReverseMinDuration = 3 * time.Hour
+
+	// OffboardingTag is the name of the tag which, when set to "true" on an
+	// AutoScaling Group, gradually replaces its spot instances with on-demand
+	// ones, regardless of the tag filters
+	OffboardingTag = "autospotting_offboarding"
+
+	// MaxUnavailableTag is the name of the tag set on the AutoScaling Group
+	// that can override the global value of the MaxUnavailable parameter
+	MaxUnavailableTag = "autospotting_max_unavailable"
+
+	// DefaultMaxUnavailable is the default number of instances of a group
+	// which can be unavailable at the same time while its spot instances are
+	// replaced with on-demand ones
+	DefaultMaxUnavailable = 1
 )
 
 // AutoScalingConfig stores some group-specific configurations that can override
@@ -100,13 +188,36 @@ type AutoScalingConfig struct {
 	OnDemandPriceMultiplier   float64
 	SpotPriceBufferPercentage float64
 
+	// Minimum savings compared to the on-demand price, given as a percentage,
+	// for a spot instance type to be considered as replacement
+	MinSavingsPercentage float64
+
+	// Maximum hourly price we are willing to pay for spot instances, zero
+	// means no limit other than the on-demand price
+	SpotPriceCeiling float64
+
+	// Maximum ratio between the spot and on-demand prices, above which the
+	// spot instances are replaced back with on-demand ones, zero disables it
+	ReverseSpotPriceRatio float64
+
+	// Number of spot interruptions in the last hour above which the spot
+	// instances are replaced back with on-demand ones, zero disables it
+	ReverseInterruptionThreshold int64
+
+	// Time for which the group keeps its on-demand instances after its spot
+	// instances were last found unattractive
+	ReverseMinDuration time.Duration
+
+	// Number of instances which can be unavailable at the same time while
+	// replacing the spot instances of the group with on-demand ones, when
+	// off-boarding it or when it runs fewer on-demand instances than required
+	MaxUnavailable int64
+
 	SpotProductDescription string
 	SpotProductPremium     float64
 
 	BiddingPolicy string
 
-	TerminationMethod string
-
 	// Instance termination method
 	InstanceTerminationMethod string
 
@@ -117,7 +228,39 @@ type AutoScalingConfig struct {
 	CronTimezone      string
 	CronScheduleState string // "on" or "off", dictate whether to run inside the CronSchedule or not
 
+	// Dates or time intervals in which no actions are taken, regardless of
+	// the CronSchedule, such as holidays or release freezes
+	CronBlackoutWindows string
+
+	// Schedule windows mapped to the on-demand percentage to be kept while
+	// inside them, overriding the MinOnDemandNumber and MinOnDemandPercentage
+	OnDemandPercentageSchedule string
+
 	PatchBeanstalkUserdata string
+
+	// Controls whether spot instances may be launched in other subnets of the
+	// group when the subnet of the replaced on-demand instance has no capacity
+	SubnetFailover string
+
+	// Controls when the spot instances need to match the network capacity of
+	// the replaced instances: "auto", "strict" or "off"
+	NetworkCompatibility string
+
+	// Controls whether Intel instances may be replaced with AMD spot instances
+	// and the other way round
+	AllowCPUVendorSubstitution string
+
+	// Comma separated list of accelerator models accepted for the spot
+	// instances besides the ones of the replaced instances
+	AllowedAcceleratorModels string
+
+	// Controls the replacements between burstable and fixed performance
+	// instance types: "strict", "allow-fixed" or "any"
+	BurstableCompatibility string
+
+	// Controls whether the instances can be replaced with instance types
+	// running on a different hypervisor, only set using a tag
+	AllowHypervisorChange string
 }
 
 func (a *autoScalingGroup) loadPercentageOnDemand(tagValue *string) (int64, bool) {
@@ -210,6 +353,210 @@ func (a *autoScalingGroup) loadPatchBeanstalkUserdata() {
 	a.config.PatchBeanstalkUserdata = a.region.conf.PatchBeanstalkUserdata
 }
 
+func (a *autoScalingGroup) loadSubnetFailover() {
+	tagValue := a.getTagValue(SubnetFailoverTag)
+
+	if tagValue != nil {
+		logger.Printf("Loaded SubnetFailover value %v from tag %v\n", *tagValue, SubnetFailoverTag)
+		a.config.SubnetFailover = *tagValue
+		return
+	}
+
+	debug.Println("Couldn't find tag", SubnetFailoverTag, "on the group", a.name, "using the default configuration")
+	a.config.SubnetFailover = a.region.conf.SubnetFailover
+}
+
+func (a *autoScalingGroup) loadMinSavingsPercentage() {
+	a.config.MinSavingsPercentage = a.region.conf.MinSavingsPercentage
+
+	tagValue := a.getTagValue(MinSavingsPercentageTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", MinSavingsPercentageTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	percentage, err := strconv.ParseFloat(*tagValue, 64)
+	if err != nil || percentage < 0 || percentage >= 100 {
+		logger.Printf("Ignoring invalid value %s of tag %s\n", *tagValue, MinSavingsPercentageTag)
+		return
+	}
+
+	logger.Printf("Loaded MinSavingsPercentage value %v from tag %v\n", percentage, MinSavingsPercentageTag)
+	a.config.MinSavingsPercentage = percentage
+}
+
+func (a *autoScalingGroup) loadSpotPriceCeiling() {
+	a.config.SpotPriceCeiling = a.region.conf.SpotPriceCeiling
+
+	tagValue := a.getTagValue(SpotPriceCeilingTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", SpotPriceCeilingTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	ceiling, err := strconv.ParseFloat(*tagValue, 64)
+	if err != nil || ceiling < 0 {
+		logger.Printf("Ignoring invalid value %s of tag %s\n", *tagValue, SpotPriceCeilingTag)
+		return
+	}
+
+	logger.Printf("Loaded SpotPriceCeiling value %v from tag %v\n", ceiling, SpotPriceCeilingTag)
+	a.config.SpotPriceCeiling = ceiling
+}
+
+func (a *autoScalingGroup) loadReverseSpotPriceRatio() {
+	a.config.ReverseSpotPriceRatio = a.region.conf.ReverseSpotPriceRatio
+
+	tagValue := a.getTagValue(ReverseSpotPriceRatioTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", ReverseSpotPriceRatioTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	ratio, err := strconv.ParseFloat(*tagValue, 64)
+	if err != nil || ratio < 0 || ratio > 1 {
+		logger.Printf("Ignoring invalid value %s of tag %s\n", *tagValue, ReverseSpotPriceRatioTag)
+		return
+	}
+
+	logger.Printf("Loaded ReverseSpotPriceRatio value %v from tag %v\n", ratio, ReverseSpotPriceRatioTag)
+	a.config.ReverseSpotPriceRatio = ratio
+}
+
+func (a *autoScalingGroup) loadReverseInterruptionThreshold() {
+	a.config.ReverseInterruptionThreshold = a.region.conf.ReverseInterruptionThreshold
+
+	tagValue := a.getTagValue(ReverseInterruptionThresholdTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", ReverseInterruptionThresholdTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	threshold, err := strconv.ParseInt(*tagValue, 10, 64)
+	if err != nil || threshold < 0 {
+		logger.Printf("Ignoring invalid value %s of tag %s\n", *tagValue, ReverseInterruptionThresholdTag)
+		return
+	}
+
+	logger.Printf("Loaded ReverseInterruptionThreshold value %v from tag %v\n", threshold, ReverseInterruptionThresholdTag)
+	a.config.ReverseInterruptionThreshold = threshold
+}
+
+func (a *autoScalingGroup) loadReverseMinDuration() {
+	a.config.ReverseMinDuration = a.region.conf.ReverseMinDuration
+
+	tagValue := a.getTagValue(ReverseMinDurationTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", ReverseMinDurationTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	duration, err := time.ParseDuration(*tagValue)
+	if err != nil || duration < 0 {
+		logger.Printf("Ignoring invalid value %s of tag %s\n", *tagValue, ReverseMinDurationTag)
+		return
+	}
+
+	logger.Printf("Loaded ReverseMinDuration value %v from tag %v\n", duration, ReverseMinDurationTag)
+	a.config.ReverseMinDuration = duration
+}
+
+func (a *autoScalingGroup) loadMaxUnavailable() {
+	a.config.MaxUnavailable = a.region.conf.MaxUnavailable
+
+	tagValue := a.getTagValue(MaxUnavailableTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", MaxUnavailableTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	maxUnavailable, err := strconv.ParseInt(*tagValue, 10, 64)
+	if err != nil || maxUnavailable < 1 {
+		logger.Printf("Ignoring invalid value %s of tag %s\n", *tagValue, MaxUnavailableTag)
+		return
+	}
+
+	logger.Printf("Loaded MaxUnavailable value %v from tag %v\n", maxUnavailable, MaxUnavailableTag)
+	a.config.MaxUnavailable = maxUnavailable
+}
+
+func (a *autoScalingGroup) loadAllowCPUVendorSubstitution() {
+	tagValue := a.getTagValue(AllowCPUVendorSubstitutionTag)
+
+	if tagValue != nil {
+		logger.Printf("Loaded AllowCPUVendorSubstitution value %v from tag %v\n", *tagValue, AllowCPUVendorSubstitutionTag)
+		a.config.AllowCPUVendorSubstitution = *tagValue
+		return
+	}
+
+	debug.Println("Couldn't find tag", AllowCPUVendorSubstitutionTag, "on the group", a.name, "using the default configuration")
+	a.config.AllowCPUVendorSubstitution = a.region.conf.AllowCPUVendorSubstitution
+}
+
+func (a *autoScalingGroup) loadAllowedAcceleratorModels() {
+	a.config.AllowedAcceleratorModels = ""
+
+	tagValue := a.getTagValue(AllowedAcceleratorModelsTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", AllowedAcceleratorModelsTag, "on the group", a.name,
+			"only accepting the accelerator models of the current instances")
+		return
+	}
+
+	logger.Printf("Loaded AllowedAcceleratorModels value %v from tag %v\n", *tagValue, AllowedAcceleratorModelsTag)
+	a.config.AllowedAcceleratorModels = *tagValue
+}
+
+func (a *autoScalingGroup) loadBurstableCompatibility() {
+	a.config.BurstableCompatibility = a.region.conf.BurstableCompatibility
+
+	tagValue := a.getTagValue(BurstableCompatibilityTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", BurstableCompatibilityTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	switch *tagValue {
+	case BurstableCompatibilityStrict, BurstableCompatibilityAllowFixed, BurstableCompatibilityAny:
+		logger.Printf("Loaded BurstableCompatibility value %v from tag %v\n", *tagValue, BurstableCompatibilityTag)
+		a.config.BurstableCompatibility = *tagValue
+	default:
+		logger.Printf("Ignoring invalid value %s of tag %s\n", *tagValue, BurstableCompatibilityTag)
+	}
+}
+
+func (a *autoScalingGroup) loadAllowHypervisorChange() {
+	a.config.AllowHypervisorChange = "false"
+
+	tagValue := a.getTagValue(AllowHypervisorChangeTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", AllowHypervisorChangeTag, "on the group", a.name,
+			"only using instance types running on the same hypervisor")
+		return
+	}
+
+	logger.Printf("Loaded AllowHypervisorChange value %v from tag %v\n", *tagValue, AllowHypervisorChangeTag)
+	a.config.AllowHypervisorChange = *tagValue
+}
+
+func (a *autoScalingGroup) loadNetworkCompatibility() {
+	a.config.NetworkCompatibility = a.region.conf.NetworkCompatibility
+
+	tagValue := a.getTagValue(NetworkCompatibilityTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", NetworkCompatibilityTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	switch *tagValue {
+	case NetworkCompatibilityAuto, NetworkCompatibilityStrict, NetworkCompatibilityOff:
+		logger.Printf("Loaded NetworkCompatibility value %v from tag %v\n", *tagValue, NetworkCompatibilityTag)
+		a.config.NetworkCompatibility = *tagValue
+	default:
+		logger.Printf("Ignoring invalid value %s of tag %s\n", *tagValue, NetworkCompatibilityTag)
+	}
+}
+
 func (a *autoScalingGroup) loadBiddingPolicy(tagValue *string) (string, bool) {
 	biddingPolicy := *tagValue
 	if biddingPolicy != "aggressive" {
@@ -258,6 +605,30 @@ func (a *autoScalingGroup) LoadCronScheduleState() {
 	a.config.CronScheduleState = a.region.conf.CronScheduleState
 }
 
+func (a *autoScalingGroup) LoadCronBlackoutWindows() {
+	tagValue := a.getTagValue(CronBlackoutWindowsTag)
+	if tagValue != nil {
+		logger.Printf("Loaded CronBlackoutWindows value %v from tag %v\n", *tagValue, CronBlackoutWindowsTag)
+		a.config.CronBlackoutWindows = *tagValue
+		return
+	}
+
+	debug.Println("Couldn't find tag", CronBlackoutWindowsTag, "on the group", a.name, "using the default configuration")
+	a.config.CronBlackoutWindows = a.region.conf.CronBlackoutWindows
+}
+
+func (a *autoScalingGroup) loadOnDemandPercentageSchedule() {
+	tagValue := a.getTagValue(OnDemandPercentageScheduleTag)
+	if tagValue != nil {
+		logger.Printf("Loaded OnDemandPercentageSchedule value %v from tag %v\n", *tagValue, OnDemandPercentageScheduleTag)
+		a.config.OnDemandPercentageSchedule = *tagValue
+		return
+	}
+
+	debug.Println("Couldn't find tag", OnDemandPercentageScheduleTag, "on the group", a.name, "using the default configuration")
+	a.config.OnDemandPercentageSchedule = a.region.conf.OnDemandPercentageSchedule
+}
+
 func (a *autoScalingGroup) loadConfSpot() bool {
 	tagValue := a.getTagValue(BiddingPolicyTag)
 	if tagValue == nil {
@@ -301,7 +672,21 @@ func (a *autoScalingGroup) loadConfigFromTags() bool {
 	a.LoadCronSchedule()
 	a.LoadCronTimezone()
 	a.LoadCronScheduleState()
+	a.LoadCronBlackoutWindows()
+	a.loadOnDemandPercentageSchedule()
 	a.loadPatchBeanstalkUserdata()
+	a.loadSubnetFailover()
+	a.loadMinSavingsPercentage()
+	a.loadSpotPriceCeiling()
+	a.loadReverseSpotPriceRatio()
+	a.loadReverseInterruptionThreshold()
+	a.loadReverseMinDuration()
+	a.loadMaxUnavailable()
+	a.loadNetworkCompatibility()
+	a.loadAllowCPUVendorSubstitution()
+	a.loadAllowedAcceleratorModels()
+	a.loadBurstableCompatibility()
+	a.loadAllowHypervisorChange()
 
 	if resOnDemandConf {
 		logger.Println("Found and applied configuration for OnDemand value")
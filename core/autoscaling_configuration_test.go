@@ -5,6 +5,7 @@ package autospotting
 
 import (
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
@@ -1105,6 +1106,116 @@ func Test_autoScalingGroup_LoadCronScheduleState(t *testing.T) {
 	}
 }
 
+func Test_autoScalingGroup_LoadCronBlackoutWindows(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		Group  *autoscaling.Group
+		region *region
+		want   string
+	}{
+		{
+			name:  "No tag set on the group",
+			Group: &autoscaling.Group{},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						CronBlackoutWindows: "2020-12-25",
+					},
+				},
+			},
+			want: "2020-12-25",
+		},
+		{
+			name: "Tag set on the group",
+			Group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(CronBlackoutWindowsTag),
+						Value: aws.String("2020-12-24/2020-12-26"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						CronBlackoutWindows: "2020-12-25",
+					},
+				},
+			},
+			want: "2020-12-24/2020-12-26",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  tt.Group,
+				region: tt.region,
+			}
+			a.LoadCronBlackoutWindows()
+			got := a.config.CronBlackoutWindows
+			if got != tt.want {
+				t.Errorf("LoadCronBlackoutWindows got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadOnDemandPercentageSchedule(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		Group  *autoscaling.Group
+		region *region
+		want   string
+	}{
+		{
+			name:  "No tag set on the group",
+			Group: &autoscaling.Group{},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						OnDemandPercentageSchedule: "* 9-17 * * 1-5=40",
+					},
+				},
+			},
+			want: "* 9-17 * * 1-5=40",
+		},
+		{
+			name: "Tag set on the group",
+			Group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(OnDemandPercentageScheduleTag),
+						Value: aws.String("* 8-20 * * *=60 | * * * * *=0"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						OnDemandPercentageSchedule: "* 9-17 * * 1-5=40",
+					},
+				},
+			},
+			want: "* 8-20 * * *=60 | * * * * *=0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  tt.Group,
+				region: tt.region,
+			}
+			a.loadOnDemandPercentageSchedule()
+			got := a.config.OnDemandPercentageSchedule
+			if got != tt.want {
+				t.Errorf("loadOnDemandPercentageSchedule got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_autoScalingGroup_LoadPatchBeanstalkUserdata(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -1168,3 +1279,416 @@ func Test_autoScalingGroup_LoadPatchBeanstalkUserdata(t *testing.T) {
 		})
 	}
 }
+
+func Test_autoScalingGroup_loadSubnetFailover(t *testing.T) {
+	tests := []struct {
+		name   string
+		Group  *autoscaling.Group
+		region *region
+		want   string
+	}{
+		{
+			name:  "No tag set on the group, use region config",
+			Group: &autoscaling.Group{},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{SubnetFailover: "true"},
+				},
+			},
+			want: "true",
+		},
+		{
+			name: "Tag set on the group",
+			Group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(SubnetFailoverTag),
+						Value: aws.String("false"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{SubnetFailover: "true"},
+				},
+			},
+			want: "false",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  tt.Group,
+				region: tt.region,
+			}
+			a.loadSubnetFailover()
+			if got := a.config.SubnetFailover; got != tt.want {
+				t.Errorf("loadSubnetFailover got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadMinSavingsPercentage(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     float64
+	}{
+		{name: "No tag set on the group, use region config", want: 10},
+		{name: "Tag set on the group", tagValue: aws.String("25.5"), want: 25.5},
+		{name: "Invalid tag value", tagValue: aws.String("foo"), want: 10},
+		{name: "Out of range tag value", tagValue: aws.String("100"), want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(MinSavingsPercentageTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{MinSavingsPercentage: 10},
+					},
+				},
+			}
+			a.loadMinSavingsPercentage()
+			if got := a.config.MinSavingsPercentage; got != tt.want {
+				t.Errorf("loadMinSavingsPercentage got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadSpotPriceCeiling(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     float64
+	}{
+		{name: "No tag set on the group, use region config", want: 0.5},
+		{name: "Tag set on the group", tagValue: aws.String("0.25"), want: 0.25},
+		{name: "Invalid tag value", tagValue: aws.String("foo"), want: 0.5},
+		{name: "Negative tag value", tagValue: aws.String("-1"), want: 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(SpotPriceCeilingTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{SpotPriceCeiling: 0.5},
+					},
+				},
+			}
+			a.loadSpotPriceCeiling()
+			if got := a.config.SpotPriceCeiling; got != tt.want {
+				t.Errorf("loadSpotPriceCeiling got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadReverseSpotPriceRatio(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     float64
+	}{
+		{name: "No tag set on the group, use region config", want: 0.8},
+		{name: "Tag set on the group", tagValue: aws.String("0.6"), want: 0.6},
+		{name: "Invalid tag value", tagValue: aws.String("foo"), want: 0.8},
+		{name: "Tag value above one", tagValue: aws.String("1.5"), want: 0.8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(ReverseSpotPriceRatioTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{ReverseSpotPriceRatio: 0.8},
+					},
+				},
+			}
+			a.loadReverseSpotPriceRatio()
+			if got := a.config.ReverseSpotPriceRatio; got != tt.want {
+				t.Errorf("loadReverseSpotPriceRatio got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadReverseInterruptionThreshold(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     int64
+	}{
+		{name: "No tag set on the group, use region config", want: 3},
+		{name: "Tag set on the group", tagValue: aws.String("5"), want: 5},
+		{name: "Invalid tag value", tagValue: aws.String("foo"), want: 3},
+		{name: "Negative tag value", tagValue: aws.String("-1"), want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(ReverseInterruptionThresholdTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{ReverseInterruptionThreshold: 3},
+					},
+				},
+			}
+			a.loadReverseInterruptionThreshold()
+			if got := a.config.ReverseInterruptionThreshold; got != tt.want {
+				t.Errorf("loadReverseInterruptionThreshold got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadReverseMinDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     time.Duration
+	}{
+		{name: "No tag set on the group, use region config", want: 3 * time.Hour},
+		{name: "Tag set on the group", tagValue: aws.String("90m"), want: 90 * time.Minute},
+		{name: "Invalid tag value", tagValue: aws.String("foo"), want: 3 * time.Hour},
+		{name: "Negative tag value", tagValue: aws.String("-1h"), want: 3 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(ReverseMinDurationTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{ReverseMinDuration: 3 * time.Hour},
+					},
+				},
+			}
+			a.loadReverseMinDuration()
+			if got := a.config.ReverseMinDuration; got != tt.want {
+				t.Errorf("loadReverseMinDuration got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadMaxUnavailable(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     int64
+	}{
+		{name: "No tag set on the group, use region config", want: 1},
+		{name: "Tag set on the group", tagValue: aws.String("3"), want: 3},
+		{name: "Invalid tag value", tagValue: aws.String("foo"), want: 1},
+		{name: "Zero tag value", tagValue: aws.String("0"), want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(MaxUnavailableTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{MaxUnavailable: 1},
+					},
+				},
+			}
+			a.loadMaxUnavailable()
+			if got := a.config.MaxUnavailable; got != tt.want {
+				t.Errorf("loadMaxUnavailable got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadNetworkCompatibility(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     string
+	}{
+		{name: "No tag set on the group, use region config", want: NetworkCompatibilityAuto},
+		{name: "Tag set on the group", tagValue: aws.String("strict"), want: NetworkCompatibilityStrict},
+		{name: "Disabled on the group", tagValue: aws.String("off"), want: NetworkCompatibilityOff},
+		{name: "Invalid tag value", tagValue: aws.String("foo"), want: NetworkCompatibilityAuto},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(NetworkCompatibilityTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{NetworkCompatibility: NetworkCompatibilityAuto},
+					},
+				},
+			}
+			a.loadNetworkCompatibility()
+			if got := a.config.NetworkCompatibility; got != tt.want {
+				t.Errorf("loadNetworkCompatibility got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadAllowCPUVendorSubstitution(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     string
+	}{
+		{name: "No tag set on the group, use region config", want: "false"},
+		{name: "Tag set on the group", tagValue: aws.String("true"), want: "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(AllowCPUVendorSubstitutionTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{AllowCPUVendorSubstitution: "false"},
+					},
+				},
+			}
+			a.loadAllowCPUVendorSubstitution()
+			if got := a.config.AllowCPUVendorSubstitution; got != tt.want {
+				t.Errorf("loadAllowCPUVendorSubstitution got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadAllowedAcceleratorModels(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     string
+	}{
+		{name: "No tag set on the group", want: ""},
+		{name: "Tag set on the group", tagValue: aws.String("NVIDIA T4,A10G"), want: "NVIDIA T4,A10G"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(AllowedAcceleratorModelsTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{Group: group, region: &region{conf: &Config{}}}
+			a.loadAllowedAcceleratorModels()
+			if got := a.config.AllowedAcceleratorModels; got != tt.want {
+				t.Errorf("loadAllowedAcceleratorModels got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadBurstableCompatibility(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     string
+	}{
+		{name: "No tag set on the group, use region config", want: BurstableCompatibilityStrict},
+		{name: "Tag set on the group", tagValue: aws.String("allow-fixed"), want: BurstableCompatibilityAllowFixed},
+		{name: "Invalid tag value", tagValue: aws.String("foo"), want: BurstableCompatibilityStrict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(BurstableCompatibilityTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{BurstableCompatibility: BurstableCompatibilityStrict},
+					},
+				},
+			}
+			a.loadBurstableCompatibility()
+			if got := a.config.BurstableCompatibility; got != tt.want {
+				t.Errorf("loadBurstableCompatibility got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadAllowHypervisorChange(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     string
+	}{
+		{name: "No tag set on the group", want: "false"},
+		{name: "Tag set on the group", tagValue: aws.String("true"), want: "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(AllowHypervisorChangeTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{Group: group, region: &region{conf: &Config{}}}
+			a.loadAllowHypervisorChange()
+			if got := a.config.AllowHypervisorChange; got != tt.want {
+				t.Errorf("loadAllowHypervisorChange got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@
 package autospotting
 
 import (
+	"context"
 	"errors"
 	"reflect"
 	"testing"
@@ -517,7 +518,7 @@ func TestNeedReplaceOnDemandInstances(t *testing.T) {
 			a.instances = tt.asgInstances
 			a.minOnDemand = tt.minOnDemand
 			a.region = tt.regionASG
-			shouldRun := a.needReplaceOnDemandInstances()
+			shouldRun := a.needReplaceOnDemandInstances(context.Background())
 			if tt.expectedRun != shouldRun {
 				t.Errorf("needReplaceOnDemandInstances returned: %t expected %t",
 					shouldRun, tt.expectedRun)
@@ -526,6 +527,90 @@ func TestNeedReplaceOnDemandInstances(t *testing.T) {
 	}
 }
 
+func Test_autoScalingGroup_replaceSpotInstancesWithOnDemand(t *testing.T) {
+
+	running := func(id, lifecycle string) *instance {
+		return &instance{Instance: &ec2.Instance{
+			InstanceId:        aws.String(id),
+			State:             &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			Placement:         &ec2.Placement{AvailabilityZone: aws.String("eu-west-1a")},
+			InstanceLifecycle: aws.String(lifecycle),
+		}}
+	}
+
+	tests := []struct {
+		name           string
+		maxUnavailable int64
+		memberState    string
+		wantTerminated []string
+	}{
+		{
+			name:           "One at a time",
+			maxUnavailable: 1,
+			memberState:    autoscaling.LifecycleStateInService,
+			wantTerminated: []string{"spot-1"},
+		},
+		{
+			name:           "Larger budget",
+			maxUnavailable: 5,
+			memberState:    autoscaling.LifecycleStateInService,
+			wantTerminated: []string{"spot-1", "spot-2"},
+		},
+		{
+			name:           "Previous replacement still pending",
+			maxUnavailable: 1,
+			memberState:    autoscaling.LifecycleStatePending,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inputs []*autoscaling.TerminateInstanceInAutoScalingGroupInput
+
+			a := autoScalingGroup{
+				Group: &autoscaling.Group{
+					DesiredCapacity: aws.Int64(3),
+					Instances: []*autoscaling.Instance{
+						{InstanceId: aws.String("ondemand-1"), LifecycleState: aws.String(autoscaling.LifecycleStateInService), HealthStatus: aws.String("Healthy")},
+						{InstanceId: aws.String("spot-1"), LifecycleState: aws.String(autoscaling.LifecycleStateInService), HealthStatus: aws.String("Healthy")},
+						{InstanceId: aws.String("spot-2"), LifecycleState: aws.String(tt.memberState), HealthStatus: aws.String("Healthy")},
+					},
+				},
+				name: "asg-test",
+				instances: makeInstancesWithCatalog(instanceMap{
+					"ondemand-1": running("ondemand-1", ""),
+					"spot-1":     running("spot-1", "spot"),
+					"spot-2":     running("spot-2", "spot"),
+				}),
+				minOnDemand: 3,
+				config:      AutoScalingConfig{MaxUnavailable: tt.maxUnavailable},
+				region: &region{
+					name:     "eu-west-1",
+					conf:     &Config{},
+					services: connections{autoScaling: mockASG{tiiasgInputs: &inputs}},
+				},
+			}
+
+			if a.needReplaceOnDemandInstances(context.Background()) {
+				t.Errorf("needReplaceOnDemandInstances() = true, want false")
+			}
+
+			var terminated []string
+			for _, in := range inputs {
+				if aws.BoolValue(in.ShouldDecrementDesiredCapacity) {
+					t.Errorf("terminated %s decrementing the desired capacity", *in.InstanceId)
+				}
+				terminated = append(terminated, *in.InstanceId)
+			}
+			if !reflect.DeepEqual(terminated, tt.wantTerminated) {
+				t.Errorf("terminated %v, want %v", terminated, tt.wantTerminated)
+			}
+			if *a.DesiredCapacity != 3 {
+				t.Errorf("desired capacity = %d, want 3", *a.DesiredCapacity)
+			}
+		})
+	}
+}
+
 func TestDetachAndTerminateOnDemandInstance(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -655,7 +740,7 @@ func TestDetachAndTerminateOnDemandInstance(t *testing.T) {
 				region:    tt.regionASG,
 				instances: tt.instancesASG,
 			}
-			err := a.detachAndTerminateOnDemandInstance(tt.instanceID)
+			err := a.detachAndTerminateOnDemandInstance(context.Background(), tt.instanceID)
 			CheckErrors(t, err, tt.expected)
 		})
 	}
@@ -734,7 +819,7 @@ func TestTerminateInstanceInAutoScalingGroup(t *testing.T) {
 				region:    tt.regionASG,
 				instances: tt.instancesASG,
 			}
-			err := a.terminateInstanceInAutoScalingGroup(tt.instanceID)
+			err := a.terminateInstanceInAutoScalingGroup(context.Background(), tt.instanceID)
 			CheckErrors(t, err, tt.expected)
 		})
 	}
@@ -774,7 +859,7 @@ func TestAttachSpotInstance(t *testing.T) {
 				name:   "testASG",
 				region: tt.regionASG,
 			}
-			err := a.attachSpotInstance(tt.instanceID)
+			err := a.attachSpotInstance(context.Background(), tt.instanceID)
 			CheckErrors(t, err, tt.expected)
 		})
 	}
@@ -901,7 +986,7 @@ func TestSetAutoScalingMaxSize(t *testing.T) {
 				name:   "testASG",
 				region: tt.regionASG,
 			}
-			err := a.setAutoScalingMaxSize(tt.maxSize)
+			err := a.setAutoScalingMaxSize(context.Background(), tt.maxSize)
 			CheckErrors(t, err, tt.expected)
 		})
 	}
@@ -2083,17 +2168,206 @@ func TestReplaceOnDemandInstanceWithSpot(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			returned := tt.asg.replaceOnDemandInstanceWithSpot(tt.spotID)
+			returned := tt.asg.replaceOnDemandInstanceWithSpot(context.Background(), tt.spotID)
 			CheckErrors(t, returned, tt.expected)
 		})
 		t.Run(tt.name+"-detach-method", func(t *testing.T) {
-			tt.asg.config.TerminationMethod = "detach"
-			returned := tt.asg.replaceOnDemandInstanceWithSpot(tt.spotID)
+			tt.asg.config.InstanceTerminationMethod = "detach"
+			returned := tt.asg.replaceOnDemandInstanceWithSpot(context.Background(), tt.spotID)
 			CheckErrors(t, returned, tt.expected)
 		})
 	}
 }
 
+func Test_autoScalingGroup_swapOnDemandInstanceUsingStandby(t *testing.T) {
+	asgInstances := func(odState, spotState, spotHealth string) *autoscaling.DescribeAutoScalingInstancesOutput {
+		return &autoscaling.DescribeAutoScalingInstancesOutput{
+			AutoScalingInstances: []*autoscaling.InstanceDetails{
+				{
+					InstanceId:     aws.String("ondemand"),
+					LifecycleState: aws.String(odState),
+					HealthStatus:   aws.String("HEALTHY"),
+				},
+				{
+					InstanceId:     aws.String("spot"),
+					LifecycleState: aws.String(spotState),
+					HealthStatus:   aws.String(spotHealth),
+				},
+			},
+		}
+	}
+
+	newInstance := func(id string) *instance {
+		return &instance{
+			Instance: &ec2.Instance{
+				InstanceId: aws.String(id),
+				State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			},
+			region: &region{
+				services: connections{ec2: mockEC2{}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		asSvc      mockASG
+		wantErr    bool
+		wantErrMsg string
+	}{
+		{
+			name: "spot instance healthy",
+			asSvc: mockASG{
+				dasio: asgInstances("Standby", "InService", "HEALTHY"),
+			},
+			wantErr: false,
+		},
+		{
+			name: "enter standby failed",
+			asSvc: mockASG{
+				esberr: errors.New("enter-standby"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "attach failed",
+			asSvc: mockASG{
+				dasio: asgInstances("Standby", "InService", "HEALTHY"),
+				aierr: errors.New("attach"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "spot instance unhealthy",
+			asSvc: mockASG{
+				dasio: asgInstances("Standby", "InService", "UNHEALTHY"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "spot instance abandoned by a launch hook",
+			asSvc: mockASG{
+				dasio: asgInstances("Standby", "Terminating", "UNHEALTHY"),
+				dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+					AutoScalingGroups: []*autoscaling.Group{{DesiredCapacity: aws.Int64(3)}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "standby instance termination failed",
+			asSvc: mockASG{
+				dasio:     asgInstances("Standby", "InService", "HEALTHY"),
+				tiiasgerr: errors.New("terminate-asg"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "spot instance unhealthy and exit standby failed",
+			asSvc: mockASG{
+				dasio:   asgInstances("Standby", "InService", "UNHEALTHY"),
+				exsberr: errors.New("exit-standby"),
+			},
+			wantErr:    true,
+			wantErrMsg: "instance spot is not healthy, and failed to put instance ondemand back in service: exit-standby",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name: "test-asg",
+				region: &region{
+					name:     "test-region",
+					conf:     &Config{},
+					services: connections{autoScaling: tt.asSvc},
+				},
+			}
+			err := a.swapOnDemandInstanceUsingStandby(context.Background(), newInstance("ondemand"), newInstance("spot"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("swapOnDemandInstanceUsingStandby() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErrMsg != "" && (err == nil || err.Error() != tt.wantErrMsg) {
+				t.Errorf("swapOnDemandInstanceUsingStandby() error = %v, want %q", err, tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_rollBackSpotInstance(t *testing.T) {
+	tests := []struct {
+		name                string
+		state               string
+		wantDecrement       []bool
+		wantDesiredCapacity []int64
+	}{
+		{name: "in service", state: "InService", wantDecrement: []bool{true}},
+		{name: "pending", state: "Pending:Wait", wantDecrement: []bool{true}},
+		{name: "in standby", state: "Standby", wantDecrement: []bool{false}},
+		{name: "abandoned by a launch hook", state: "Terminating:Wait", wantDesiredCapacity: []int64{2}},
+		{name: "terminated", state: "Terminated", wantDesiredCapacity: []int64{2}},
+		{name: "no longer attached"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inputs []*autoscaling.TerminateInstanceInAutoScalingGroupInput
+			var updates []*autoscaling.UpdateAutoScalingGroupInput
+
+			asSvc := mockASG{
+				dasio:        &autoscaling.DescribeAutoScalingInstancesOutput{},
+				tiiasgInputs: &inputs,
+				dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+					AutoScalingGroups: []*autoscaling.Group{{
+						AutoScalingGroupName: aws.String("test-asg"),
+						DesiredCapacity:      aws.Int64(3),
+					}},
+				},
+				uasgInputs: &updates,
+			}
+			if tt.state != "" {
+				asSvc.dasio = asgInstancesInState("spot", tt.state)
+			}
+
+			a := &autoScalingGroup{
+				name: "test-asg",
+				region: &region{
+					name:     "test-region",
+					conf:     &Config{},
+					services: connections{autoScaling: asSvc},
+				},
+			}
+			spotInst := &instance{
+				Instance: &ec2.Instance{
+					InstanceId: aws.String("spot"),
+					State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+				},
+				region: &region{services: connections{ec2: mockEC2{}}},
+			}
+
+			if err := a.rollBackSpotInstance(context.Background(), spotInst); err != nil {
+				t.Errorf("rollBackSpotInstance() error = %v", err)
+			}
+
+			var decrement []bool
+			for _, in := range inputs {
+				decrement = append(decrement, aws.BoolValue(in.ShouldDecrementDesiredCapacity))
+			}
+			if !reflect.DeepEqual(decrement, tt.wantDecrement) {
+				t.Errorf("terminated with decrement %v, want %v", decrement, tt.wantDecrement)
+			}
+
+			var desiredCapacity []int64
+			for _, in := range updates {
+				desiredCapacity = append(desiredCapacity, aws.Int64Value(in.DesiredCapacity))
+			}
+			if !reflect.DeepEqual(desiredCapacity, tt.wantDesiredCapacity) {
+				t.Errorf("set the desired capacity to %v, want %v", desiredCapacity, tt.wantDesiredCapacity)
+			}
+		})
+	}
+}
+
 func TestGetAllowedInstanceTypes(t *testing.T) {
 	tests := []struct {
 		name         string
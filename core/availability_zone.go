@@ -0,0 +1,187 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// azInstanceCount stores the number of running instances of a group from a
+// given availability zone, broken down by their lifecycle.
+type azInstanceCount struct {
+	onDemand int64
+	spot     int64
+}
+
+func (c *azInstanceCount) total() int64 {
+	return c.onDemand + c.spot
+}
+
+// The key in this map is the availability zone
+type azComposition map[string]*azInstanceCount
+
+// getAZComposition counts the running instances of the group in each of its
+// availability zones.
+func (a *autoScalingGroup) getAZComposition() azComposition {
+	composition := make(azComposition)
+
+	for _, az := range a.AvailabilityZones {
+		if az != nil {
+			composition[*az] = &azInstanceCount{}
+		}
+	}
+
+	for inst := range a.instances.instances() {
+		if inst.State == nil || *inst.State.Name != ec2.InstanceStateNameRunning ||
+			inst.Placement == nil || inst.Placement.AvailabilityZone == nil {
+			continue
+		}
+
+		az := *inst.Placement.AvailabilityZone
+		if composition[az] == nil {
+			composition[az] = &azInstanceCount{}
+		}
+
+		if inst.isSpot() {
+			composition[az].spot++
+		} else {
+			composition[az].onDemand++
+		}
+	}
+	return composition
+}
+
+// zones returns the availability zones sorted descending by the number of
+// on-demand instances, so the zones where we should replace instances first
+// come first. Ties are broken by the total number of instances and then
+// alphabetically, to keep the order stable.
+func (c azComposition) zones() []string {
+	var zones []string
+	for az := range c {
+		zones = append(zones, az)
+	}
+
+	sort.Slice(zones, func(i, j int) bool {
+		ci, cj := c[zones[i]], c[zones[j]]
+		if ci.onDemand != cj.onDemand {
+			return ci.onDemand > cj.onDemand
+		}
+		if ci.total() != cj.total() {
+			return ci.total() > cj.total()
+		}
+		return zones[i] < zones[j]
+	})
+	return zones
+}
+
+// spread returns the difference between the number of instances running in
+// the most and the least populated availability zones, after optionally
+// moving an instance between two of the zones.
+func (c azComposition) spread(from string, to string) int64 {
+	var lowest, highest int64 = -1, -1
+
+	totals := make(map[string]int64)
+	for az, count := range c {
+		totals[az] = count.total()
+	}
+	if from != "" {
+		totals[from]--
+	}
+	if to != "" {
+		totals[to]++
+	}
+
+	for _, total := range totals {
+		if lowest == -1 || total < lowest {
+			lowest = total
+		}
+		if highest == -1 || total > highest {
+			highest = total
+		}
+	}
+	return highest - lowest
+}
+
+// isBalancedAfterMove returns true if the AutoScaling AZRebalance process
+// wouldn't need to act after we replace an instance from one availability zone
+// with an instance launched in another one. The group is balanced when the
+// zones differ by at most one instance, but we also accept moves improving
+// the balance of an already unbalanced group.
+func (c azComposition) isBalancedAfterMove(from string, to string) bool {
+	after := c.spread(from, to)
+	return after <= 1 || after < c.spread("", "")
+}
+
+func (c azComposition) String() string {
+	var zones []string
+	for az := range c {
+		zones = append(zones, az)
+	}
+	sort.Strings(zones)
+
+	var lines []string
+	for _, az := range zones {
+		lines = append(lines, fmt.Sprintf("%s: %d on-demand, %d spot, %d total",
+			az, c[az].onDemand, c[az].spot, c[az].total()))
+	}
+	return strings.Join(lines, "; ")
+}
+
+// getUnprotectedOnDemandInstanceToReplace picks the on-demand instance that
+// should be replaced next, taken from the availability zone having the most
+// on-demand instances, so that the spot instances are spread evenly.
+func (a *autoScalingGroup) getUnprotectedOnDemandInstanceToReplace() *instance {
+	composition := a.getAZComposition()
+	debug.Println(a.name, "Availability zone composition:", composition)
+
+	for _, az := range composition.zones() {
+		if composition[az].onDemand == 0 {
+			continue
+		}
+		zone := az
+		if odInst := a.getUnprotectedOnDemandInstanceInAZ(&zone); odInst != nil {
+			return odInst
+		}
+	}
+	return nil
+}
+
+// getUnprotectedOnDemandInstanceToReplaceWithSpotInAZ finds an on-demand
+// instance that can be replaced with a spot instance running in the given
+// availability zone. Instances from the same zone are preferred, otherwise we
+// only pick an instance from another zone if the group stays balanced, so
+// that AZRebalance won't terminate any instances afterwards.
+func (a *autoScalingGroup) getUnprotectedOnDemandInstanceToReplaceWithSpotInAZ(az *string) *instance {
+	if odInst := a.getUnprotectedOnDemandInstanceInAZ(az); odInst != nil {
+		return odInst
+	}
+
+	composition := a.getAZComposition()
+	debug.Println(a.name, "Availability zone composition:", composition)
+
+	for _, other := range composition.zones() {
+		if other == *az || composition[other].onDemand == 0 {
+			continue
+		}
+
+		if !composition.isBalancedAfterMove(other, *az) {
+			debug.Println(a.name, "Replacing an on-demand instance from", other,
+				"with a spot instance from", *az, "would unbalance the group")
+			continue
+		}
+
+		zone := other
+		if odInst := a.getUnprotectedOnDemandInstanceInAZ(&zone); odInst != nil {
+			logger.Println(a.name, "found on-demand instance", *odInst.InstanceId,
+				"in", other, "which can be replaced from", *az,
+				"without unbalancing the group")
+			return odInst
+		}
+	}
+	return nil
+}
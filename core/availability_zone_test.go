@@ -0,0 +1,232 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func testAZInstance(id string, az string, lifecycle string, state string) *instance {
+	return &instance{
+		Instance: &ec2.Instance{
+			InstanceId:        aws.String(id),
+			InstanceLifecycle: aws.String(lifecycle),
+			Placement:         &ec2.Placement{AvailabilityZone: aws.String(az)},
+			State:             &ec2.InstanceState{Name: aws.String(state)},
+		},
+		region: &region{
+			services: connections{ec2: mockEC2{}},
+		},
+	}
+}
+
+func Test_autoScalingGroup_getAZComposition(t *testing.T) {
+	a := &autoScalingGroup{
+		Group: &autoscaling.Group{
+			AvailabilityZones: []*string{aws.String("1a"), aws.String("1b"), aws.String("1c")},
+		},
+		instances: makeInstancesWithCatalog(instanceMap{
+			"od1":   testAZInstance("od1", "1a", "", ec2.InstanceStateNameRunning),
+			"od2":   testAZInstance("od2", "1a", "", ec2.InstanceStateNameRunning),
+			"spot1": testAZInstance("spot1", "1b", "spot", ec2.InstanceStateNameRunning),
+			"od3":   testAZInstance("od3", "1b", "", ec2.InstanceStateNameStopped),
+		}),
+	}
+
+	expected := azComposition{
+		"1a": {onDemand: 2},
+		"1b": {spot: 1},
+		"1c": {},
+	}
+
+	if got := a.getAZComposition(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("getAZComposition() = %v, expected %v", got, expected)
+	}
+}
+
+func Test_azComposition_zones(t *testing.T) {
+	c := azComposition{
+		"1a": {onDemand: 1, spot: 1},
+		"1b": {onDemand: 2},
+		"1c": {onDemand: 1, spot: 2},
+		"1d": {},
+	}
+	expected := []string{"1b", "1c", "1a", "1d"}
+
+	if got := c.zones(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("zones() = %v, expected %v", got, expected)
+	}
+}
+
+func Test_azComposition_isBalancedAfterMove(t *testing.T) {
+	tests := []struct {
+		name        string
+		composition azComposition
+		from        string
+		to          string
+		expected    bool
+	}{
+		{
+			name:        "same zone",
+			composition: azComposition{"1a": {onDemand: 2}, "1b": {onDemand: 1}},
+			from:        "1a",
+			to:          "1a",
+			expected:    true,
+		},
+		{
+			name:        "move to the smaller zone",
+			composition: azComposition{"1a": {onDemand: 2}, "1b": {onDemand: 1}},
+			from:        "1a",
+			to:          "1b",
+			expected:    true,
+		},
+		{
+			name:        "move to the bigger zone",
+			composition: azComposition{"1a": {onDemand: 2}, "1b": {onDemand: 1}},
+			from:        "1b",
+			to:          "1a",
+			expected:    false,
+		},
+		{
+			name:        "move to a zone without instances",
+			composition: azComposition{"1a": {onDemand: 3}, "1b": {onDemand: 1}},
+			from:        "1b",
+			to:          "1c",
+			expected:    false,
+		},
+		{
+			name:        "move improving an unbalanced group",
+			composition: azComposition{"1a": {onDemand: 5}, "1b": {spot: 1}},
+			from:        "1a",
+			to:          "1b",
+			expected:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.composition.isBalancedAfterMove(tt.from, tt.to); got != tt.expected {
+				t.Errorf("isBalancedAfterMove() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_azComposition_String(t *testing.T) {
+	c := azComposition{
+		"1b": {spot: 1},
+		"1a": {onDemand: 2, spot: 1},
+	}
+	expected := "1a: 2 on-demand, 1 spot, 3 total; 1b: 0 on-demand, 1 spot, 1 total"
+
+	if got := c.String(); got != expected {
+		t.Errorf("String() = %v, expected %v", got, expected)
+	}
+}
+
+func Test_autoScalingGroup_getUnprotectedOnDemandInstanceToReplace(t *testing.T) {
+	tests := []struct {
+		name      string
+		instances instanceMap
+		expected  string
+	}{
+		{
+			name:      "no on-demand instances",
+			instances: instanceMap{"spot1": testAZInstance("spot1", "1a", "spot", ec2.InstanceStateNameRunning)},
+			expected:  "",
+		},
+		{
+			name: "zone with most on-demand instances first",
+			instances: instanceMap{
+				"od1":   testAZInstance("od1", "1a", "", ec2.InstanceStateNameRunning),
+				"spot1": testAZInstance("spot1", "1a", "spot", ec2.InstanceStateNameRunning),
+				"od2":   testAZInstance("od2", "1b", "", ec2.InstanceStateNameRunning),
+				"od3":   testAZInstance("od3", "1b", "", ec2.InstanceStateNameRunning),
+			},
+			expected: "1b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name:      "test-asg",
+				Group:     &autoscaling.Group{},
+				instances: makeInstancesWithCatalog(tt.instances),
+			}
+			got := a.getUnprotectedOnDemandInstanceToReplace()
+			if tt.expected == "" && got != nil {
+				t.Errorf("expected no instance, got %v", *got.InstanceId)
+			}
+			if tt.expected != "" && (got == nil || *got.Placement.AvailabilityZone != tt.expected) {
+				t.Errorf("expected an instance from %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_getUnprotectedOnDemandInstanceToReplaceWithSpotInAZ(t *testing.T) {
+	tests := []struct {
+		name      string
+		instances instanceMap
+		spotAZ    string
+		expected  string
+	}{
+		{
+			name: "on-demand instance in the same zone",
+			instances: instanceMap{
+				"od1": testAZInstance("od1", "1a", "", ec2.InstanceStateNameRunning),
+				"od2": testAZInstance("od2", "1b", "", ec2.InstanceStateNameRunning),
+				"od3": testAZInstance("od3", "1b", "", ec2.InstanceStateNameRunning),
+			},
+			spotAZ:   "1a",
+			expected: "od1",
+		},
+		{
+			name: "on-demand instance in another zone keeping the group balanced",
+			instances: instanceMap{
+				"od1":   testAZInstance("od1", "1a", "", ec2.InstanceStateNameRunning),
+				"od2":   testAZInstance("od2", "1a", "", ec2.InstanceStateNameRunning),
+				"spot1": testAZInstance("spot1", "1b", "spot", ec2.InstanceStateNameRunning),
+			},
+			spotAZ:   "1b",
+			expected: "1a",
+		},
+		{
+			name: "on-demand instance in another zone unbalancing the group",
+			instances: instanceMap{
+				"od1":   testAZInstance("od1", "1a", "", ec2.InstanceStateNameRunning),
+				"spot1": testAZInstance("spot1", "1b", "spot", ec2.InstanceStateNameRunning),
+			},
+			spotAZ:   "1b",
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name:      "test-asg",
+				Group:     &autoscaling.Group{},
+				instances: makeInstancesWithCatalog(tt.instances),
+			}
+			got := a.getUnprotectedOnDemandInstanceToReplaceWithSpotInAZ(aws.String(tt.spotAZ))
+			switch {
+			case tt.expected == "":
+				if got != nil {
+					t.Errorf("expected no instance, got %v", *got.InstanceId)
+				}
+			case got == nil:
+				t.Errorf("expected %v, got no instance", tt.expected)
+			case *got.InstanceId != tt.expected && *got.Placement.AvailabilityZone != tt.expected:
+				t.Errorf("expected %v, got %v", tt.expected, *got.InstanceId)
+			}
+		})
+	}
+}
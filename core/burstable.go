@@ -0,0 +1,133 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// BurstableCompatibilityStrict only replaces burstable instances with
+	// burstable spot instances, and fixed performance instances with fixed
+	// performance spot instances.
+	BurstableCompatibilityStrict = "strict"
+
+	// BurstableCompatibilityAllowFixed also allows replacing burstable
+	// instances with fixed performance spot instances, which never run out of
+	// CPU credits, but not the other way round.
+	BurstableCompatibilityAllowFixed = "allow-fixed"
+
+	// BurstableCompatibilityAny allows any replacement between burstable and
+	// fixed performance instance types.
+	BurstableCompatibilityAny = "any"
+
+	// DefaultBurstableCompatibility is the default burstable compatibility mode
+	DefaultBurstableCompatibility = BurstableCompatibilityAny
+
+	cpuCreditsStandard  = "standard"
+	cpuCreditsUnlimited = "unlimited"
+)
+
+// The burstable performance instance families, such as t2, t3a or t4g
+var burstableTypeRegexp = regexp.MustCompile(`^t[0-9][a-z]*\.`)
+
+// isBurstableType tells if the instance type is part of the T family of
+// burstable performance instances, for the types not reported by the
+// DescribeInstanceTypes API.
+func isBurstableType(instanceType string) bool {
+	return burstableTypeRegexp.MatchString(instanceType)
+}
+
+// isBurstableCompatible checks the burstable performance instances are only
+// replaced according to the burstable compatibility mode of the group, since
+// their cost and performance depend on the CPU credits.
+func (i *instance) isBurstableCompatible(spotCandidate instanceTypeInformation) bool {
+	current := i.typeInfo
+
+	if current.burstable == spotCandidate.burstable {
+		return true
+	}
+
+	mode := DefaultBurstableCompatibility
+	if i.asg != nil && i.asg.config.BurstableCompatibility != "" {
+		mode = i.asg.config.BurstableCompatibility
+	}
+
+	switch {
+	case mode == BurstableCompatibilityAny,
+		mode == BurstableCompatibilityAllowFixed && current.burstable:
+		return true
+	}
+
+	debug.Println("\tNot burstable compatible, replacing", current.instanceType, "with",
+		spotCandidate.instanceType, "isn't allowed in the", mode, "mode")
+	return false
+}
+
+// getCPUCredits returns the CPU credits option of a burstable instance, either
+// "standard" or "unlimited", or an empty string if it can't be determined. It's
+// only described once, since it's needed for every launched spot instance.
+func (i *instance) getCPUCredits(ctx context.Context) string {
+	if i.cpuCredits != nil {
+		return *i.cpuCredits
+	}
+
+	resp, err := i.region.services.ec2.DescribeInstanceCreditSpecificationsWithContext(ctx,
+		&ec2.DescribeInstanceCreditSpecificationsInput{
+			InstanceIds: []*string{i.InstanceId},
+		})
+
+	if err != nil {
+		logger.Println("Failed to describe the credit specification of instance",
+			aws.StringValue(i.InstanceId), "encountered error:", err.Error())
+		return ""
+	}
+
+	credits := ""
+	if resp != nil {
+		for _, spec := range resp.InstanceCreditSpecifications {
+			if aws.StringValue(spec.InstanceId) == aws.StringValue(i.InstanceId) {
+				credits = aws.StringValue(spec.CpuCredits)
+			}
+		}
+	}
+	i.cpuCredits = aws.String(credits)
+	return credits
+}
+
+// creditSpecification determines the CPU credits option of the spot instance,
+// otherwise inherited from the launch template or the account defaults. It
+// copies the option of the replaced burstable instances, and uses the standard
+// mode for fixed performance instances, so they don't incur the unlimited mode
+// charges on top of the spot price. It's nil for the fixed performance spot
+// instance types.
+func (i *instance) creditSpecification(ctx context.Context, instanceType string) *ec2.CreditSpecificationRequest {
+	if i.region == nil || !i.region.isBurstable(instanceType) {
+		return nil
+	}
+
+	credits := cpuCreditsStandard
+	if i.region.isBurstable(aws.StringValue(i.InstanceType)) {
+		credits = i.getCPUCredits(ctx)
+	}
+
+	if credits == "" {
+		return nil
+	}
+
+	return &ec2.CreditSpecificationRequest{CpuCredits: aws.String(credits)}
+}
+
+// isBurstable checks if the instance type offers burstable performance, using
+// the instance type data if available.
+func (r *region) isBurstable(instanceType string) bool {
+	if info, ok := r.instanceTypeInformation[instanceType]; ok {
+		return info.burstable
+	}
+	return isBurstableType(instanceType)
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_isBurstableType(t *testing.T) {
+	tests := []struct {
+		instanceType string
+		expected     bool
+	}{
+		{instanceType: "t1.micro", expected: true},
+		{instanceType: "t3a.large", expected: true},
+		{instanceType: "t4g.nano", expected: true},
+		{instanceType: "m5.large", expected: false},
+		{instanceType: "trn1.2xlarge", expected: false},
+		{instanceType: "x1e.xlarge", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.instanceType, func(t *testing.T) {
+			if got := isBurstableType(tt.instanceType); got != tt.expected {
+				t.Errorf("isBurstableType() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_isBurstableCompatible(t *testing.T) {
+	burstable := instanceTypeInformation{instanceType: "t3.large", burstable: true}
+	fixed := instanceTypeInformation{instanceType: "m5.large"}
+
+	tests := []struct {
+		name      string
+		mode      string
+		current   instanceTypeInformation
+		candidate instanceTypeInformation
+		expected  bool
+	}{
+		{name: "burstable to burstable", mode: BurstableCompatibilityStrict, current: burstable, candidate: burstable, expected: true},
+		{name: "fixed to fixed", mode: BurstableCompatibilityStrict, current: fixed, candidate: fixed, expected: true},
+		{name: "burstable to fixed in strict mode", mode: BurstableCompatibilityStrict, current: burstable, candidate: fixed, expected: false},
+		{name: "fixed to burstable by default", current: fixed, candidate: burstable, expected: true},
+		{name: "burstable to fixed allowed", mode: BurstableCompatibilityAllowFixed, current: burstable, candidate: fixed, expected: true},
+		{name: "fixed to burstable not allowed", mode: BurstableCompatibilityAllowFixed, current: fixed, candidate: burstable, expected: false},
+		{name: "fixed to burstable in any mode", mode: BurstableCompatibilityAny, current: fixed, candidate: burstable, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				typeInfo: tt.current,
+				asg:      &autoScalingGroup{config: AutoScalingConfig{BurstableCompatibility: tt.mode}},
+			}
+			if got := i.isBurstableCompatible(tt.candidate); got != tt.expected {
+				t.Errorf("isBurstableCompatible() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_creditSpecification(t *testing.T) {
+	credits := &ec2.DescribeInstanceCreditSpecificationsOutput{
+		InstanceCreditSpecifications: []*ec2.InstanceCreditSpecification{
+			{InstanceId: aws.String("i-123"), CpuCredits: aws.String("unlimited")},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		currentType  string
+		instanceType string
+		dicso        *ec2.DescribeInstanceCreditSpecificationsOutput
+		dicserr      error
+		expected     *ec2.CreditSpecificationRequest
+	}{
+		{
+			name:         "fixed performance spot instance",
+			currentType:  "t3.large",
+			instanceType: "m5.large",
+			dicso:        credits,
+		},
+		{
+			name:         "copied from the burstable instance",
+			currentType:  "t3.large",
+			instanceType: "t3a.large",
+			dicso:        credits,
+			expected:     &ec2.CreditSpecificationRequest{CpuCredits: aws.String("unlimited")},
+		},
+		{
+			name:         "standard when replacing fixed performance instances",
+			currentType:  "m5.large",
+			instanceType: "t3.large",
+			expected:     &ec2.CreditSpecificationRequest{CpuCredits: aws.String("standard")},
+		},
+		{
+			name:         "inherited when the credits are unknown",
+			currentType:  "t3.large",
+			instanceType: "t3a.large",
+			dicserr:      errors.New("denied"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					InstanceId:   aws.String("i-123"),
+					InstanceType: aws.String(tt.currentType),
+				},
+				region: &region{
+					instanceTypeInformation: map[string]instanceTypeInformation{
+						"t3.large":  {instanceType: "t3.large", burstable: true},
+						"t3a.large": {instanceType: "t3a.large", burstable: true},
+						"m5.large":  {instanceType: "m5.large"},
+					},
+					services: connections{
+						ec2: mockEC2{dicso: tt.dicso, dicserr: tt.dicserr},
+					},
+				},
+			}
+			if got := i.creditSpecification(context.Background(), tt.instanceType); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("creditSpecification() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_getCPUCredits_describedOnce(t *testing.T) {
+	i := &instance{
+		Instance: &ec2.Instance{InstanceId: aws.String("i-123")},
+		region: &region{
+			services: connections{
+				ec2: mockEC2{dicso: &ec2.DescribeInstanceCreditSpecificationsOutput{
+					InstanceCreditSpecifications: []*ec2.InstanceCreditSpecification{
+						{InstanceId: aws.String("i-123"), CpuCredits: aws.String("unlimited")},
+					},
+				}},
+			},
+		},
+	}
+
+	if got := i.getCPUCredits(context.Background()); got != "unlimited" {
+		t.Errorf("getCPUCredits() = %v, expected unlimited", got)
+	}
+
+	i.region.services.ec2 = mockEC2{dicserr: errors.New("throttled")}
+	if got := i.getCPUCredits(context.Background()); got != "unlimited" {
+		t.Errorf("getCPUCredits() = %v, expected the cached unlimited", got)
+	}
+}
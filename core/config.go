@@ -28,6 +28,13 @@ const (
 	// no longer recommended.
 	DetachTerminationMethod = "detach"
 
+	// StandbyTerminationMethod moves the instance to Standby before attaching
+	// its spot replacement, and only terminates it once the spot instance is
+	// in service and healthy. If the spot instance fails to become healthy, the
+	// original instance is put back in service, so the group never loses
+	// capacity during the swap.
+	StandbyTerminationMethod = "standby"
+
 	// TerminateTerminationNotificationAction terminate the spot instance, which will be terminated
 	// by AWS in 2 minutes, without reducing the ASG capacity, so that a new instance will
 	// be launched. LifeCycle Hooks are triggered.
@@ -60,6 +67,14 @@ type Config struct {
 	// Static data fetched from ec2instances.info
 	InstanceData *ec2instancesinfo.InstanceData
 
+	// Where the instance data is loaded from: "bundled", a local file path or
+	// an HTTP(S) URL, and for how long the downloaded data is cached
+	InstanceDataSource   string
+	InstanceDataCacheTTL time.Duration
+
+	// Loads the InstanceData before each run, tests can set it to use fixtures
+	InstanceDataProvider InstanceDataProvider
+
 	// Logging
 	LogFile io.Writer
 	LogFlag int
@@ -67,9 +82,38 @@ type Config struct {
 	// The regions where it should be running
 	Regions string
 
+	// How many regions, and how many groups in each region, are processed
+	// at the same time, zero means no limit
+	MaxConcurrentRegions int
+	MaxConcurrentGroups  int
+
+	// The rate of the requests sent to each AWS API in each region, zero
+	// means no limit, and how many times the failed requests are retried
+	APIRequestsPerSecond float64
+	APIMaxRetries        int
+
+	// Comma separated names of the regions and AutoScaling groups processed
+	// instead of scanning all of them, such as when fixing a single group
+	TargetRegions           string
+	TargetAutoScalingGroups string
+
 	// The region where the Lambda function is deployed
 	MainRegion string
 
+	// Whether the spot instances launched by AutoSpotting that are no longer
+	// going to be attached to their group are terminated, only listed or
+	// kept, and after how long the unattached ones are considered orphaned
+	OrphanedInstancesCleanup string
+	OrphanedInstanceMaxAge   time.Duration
+
+	// Comma separated names of the AutoScaling groups to start off-boarding,
+	// tagging them instead of running
+	OffboardAutoScalingGroups string
+
+	// How much time needs to be left before the deadline of a run, such as
+	// the Lambda function timeout, for starting new replacements
+	DeadlineSafetyMargin time.Duration
+
 	// This is only here for tests, where we want to be able to somehow mock
 	// time.Sleep without actually sleeping. While testing it defaults to 0 (which won't sleep at all), in
 	// real-world usage it's expected to be set to 1
@@ -82,6 +126,14 @@ type Config struct {
 	// Available options: 'opt-in' and 'opt-out', default: 'opt-in'
 	TagFilteringMode string
 
+	// Selector expression of the enabled groups, such as
+	// "env in (dev,staging) and not team=payments", which when set replaces
+	// the tag filters and the filtering mode
+	AutoScalingGroupSelector string
+
+	// The parsed selector expression, nil when not set
+	GroupSelector groupSelector
+
 	// The AutoSpotting version
 	Version string
 
@@ -92,6 +144,20 @@ type Config struct {
 	// the instance role when calling CloudFormation helpers instead of the standard CloudFormation
 	// authentication method
 	PatchBeanstalkUserdata string
+
+	// Controls whether the on-demand instances covered by Reserved Instances
+	// or Savings Plans are kept out of the replacement
+	ConsiderReservedCapacity string
+
+	// Path to a JSON file mapping regions to their Savings Plans commitment, and
+	// the hourly on-demand spend covered by it in each region
+	SavingsPlansCoverageFile string
+	SavingsPlansCoverage     map[string]float64
+
+	// Only prints the cheapest compatible spot instance types of each group,
+	// without taking any action, listing that many of them per AZ
+	ReportMode          bool
+	ReportTopCandidates int
 }
 
 // ParseConfig loads configuration from command line flags, environments variables, and config files.
@@ -117,23 +183,57 @@ func ParseConfig(conf *Config) {
 		"\n\tIf specified, the spot instances will be searched only among these types.\n\tIf missing, any instance type is allowed.\n"+
 			"\tAccepts a list of comma or whitespace separated instance types (supports globs).\n"+
 			"\tExample: ./AutoSpotting -allowed_instance_types 'c5.*,c4.xlarge'\n")
+	flagSet.IntVar(&conf.APIMaxRetries, "api_max_retries", DefaultAPIMaxRetries,
+		"\n\tNumber of retries of the failed or throttled AWS API requests, using a jittered exponential backoff.\n")
+	flagSet.Float64Var(&conf.APIRequestsPerSecond, "api_requests_per_second", DefaultAPIRequestsPerSecond,
+		"\n\tMaximum rate of the requests sent to each AWS API in each region, shared by all the groups.\n"+
+			"\tSet it to 0 for no limit.\n"+
+			"\tExample: ./AutoSpotting -api_requests_per_second 5\n")
 	flagSet.StringVar(&conf.BiddingPolicy, "bidding_policy", DefaultBiddingPolicy,
 		"\n\tPolicy choice for spot bid. If set to 'normal', we bid at the on-demand price(times the multiplier).\n"+
 			"\tIf set to 'aggressive', we bid at a percentage value above the spot price \n"+
 			"\tconfigurable using the spot_price_buffer_percentage.\n")
+	flagSet.DurationVar(&conf.DeadlineSafetyMargin, "deadline_safety_margin", DefaultDeadlineSafetyMargin,
+		"\n\tTime left before the Lambda function timeout below which no new spot instances are launched\n"+
+			"\tor attached, the remaining actions being reported and left for the next run.\n"+
+			"\tExample: ./AutoSpotting -deadline_safety_margin 2m\n")
 	flagSet.StringVar(&conf.DisallowedInstanceTypes, "disallowed_instance_types", "",
 		"\n\tIf specified, the spot instances will _never_ be of these types.\n"+
 			"\tAccepts a list of comma or whitespace separated instance types (supports globs).\n"+
 			"\tExample: ./AutoSpotting -disallowed_instance_types 't2.*,c4.xlarge'\n")
 	flagSet.StringVar(&conf.InstanceTerminationMethod, "instance_termination_method", DefaultInstanceTerminationMethod,
 		"\n\tInstance termination method.  Must be one of '"+DefaultInstanceTerminationMethod+"' (default),\n"+
-			"\t or 'detach' (compatibility mode, not recommended)\n")
+			"\t'standby' (reversible swap without capacity dip) or 'detach' (compatibility mode, not recommended)\n")
+	flagSet.StringVar(&conf.OffboardAutoScalingGroups, "offboard_autoscaling_groups", "",
+		"\n\tComma separated names of the groups to start off-boarding in the targeted regions, tagging them with\n"+
+			"\t"+OffboardingTag+"=true and exiting. The next runs then gradually replace their spot instances with\n"+
+			"\ton-demand ones, regardless of the tag filters.\n"+
+			"\tExample: ./AutoSpotting -offboard_autoscaling_groups my-group -target_regions us-east-1\n")
+	flagSet.Int64Var(&conf.MaxUnavailable, "max_unavailable", DefaultMaxUnavailable,
+		"\n\tNumber of instances which can be unavailable at the same time while replacing the spot instances of\n"+
+			"\ta group with on-demand ones, when off-boarding it or when it runs fewer on-demand instances than\n"+
+			"\trequired. The spot instances are only terminated while the group is otherwise in service and healthy.\n"+
+			"\tMust be at least 1. Can be overridden on a per-group basis using the tag "+MaxUnavailableTag+".\n")
+	flagSet.StringVar(&conf.OrphanedInstancesCleanup, "orphaned_instances_cleanup", DefaultOrphanedInstancesCleanup,
+		"\n\tWhat to do with the spot instances launched by AutoSpotting which were never attached, because\n"+
+			"\ttheir group was deleted or disabled, or they are unattached for longer than orphaned_instance_max_age.\n"+
+			"\tValid choices: '"+OrphanedInstancesCleanupTerminate+"' | '"+OrphanedInstancesCleanupDryRun+
+			"' (default, only list them) | '"+OrphanedInstancesCleanupOff+"'\n")
+	flagSet.DurationVar(&conf.OrphanedInstanceMaxAge, "orphaned_instance_max_age", DefaultOrphanedInstanceMaxAge,
+		"\n\tHow long a spot instance launched for an enabled group can stay unattached before being considered orphaned.\n"+
+			"\tSet it to 0 to only clean up the instances of the deleted or disabled groups.\n")
 	flagSet.StringVar(&conf.TerminationNotificationAction, "termination_notification_action", DefaultTerminationNotificationAction,
 		"\n\tTermination Notification Action.\n"+
 			"\tValid choices:\n"+
 			"\t'"+DefaultTerminationNotificationAction+
 			"' (terminate if lifecyclehook else detach) | 'terminate' (lifecyclehook triggered)"+
 			" | 'detach' (lifecyclehook not triggered)\n")
+	flagSet.IntVar(&conf.MaxConcurrentGroups, "max_concurrent_groups", DefaultMaxConcurrentGroups,
+		"\n\tMaximum number of AutoScaling groups processed at the same time in each region.\n"+
+			"\tSet it to 0 for no limit.\n")
+	flagSet.IntVar(&conf.MaxConcurrentRegions, "max_concurrent_regions", DefaultMaxConcurrentRegions,
+		"\n\tMaximum number of regions processed at the same time.\n"+
+			"\tSet it to 0 for no limit.\n")
 	flagSet.Int64Var(&conf.MinOnDemandNumber, "min_on_demand_number", DefaultMinOnDemandValue,
 		"\n\tNumber of on-demand nodes to be kept running in each of the groups.\n\t"+
 			"Can be overridden on a per-group basis using the tag "+OnDemandNumberLong+".\n")
@@ -141,40 +241,113 @@ func ParseConfig(conf *Config) {
 		"\n\tPercentage of the total number of instances in each group to be kept on-demand\n\t"+
 			"Can be overridden on a per-group basis using the tag "+OnDemandPercentageTag+
 			"\n\tIt is ignored if min_on_demand_number is also set.\n")
+	flagSet.StringVar(&conf.OnDemandPercentageSchedule, "on_demand_percentage_schedule", "",
+		"\n\tOn-demand percentage targets kept during schedule windows, given as <schedule>=<percentage>\n"+
+			"\ttargets separated by '|', in the cron_schedule format and evaluated in the cron_timezone.\n"+
+			"\tThe first matching target overrides min_on_demand_number and min_on_demand_percentage, and\n"+
+			"\tthe spot instances are gradually replaced with on-demand ones when below the target.\n"+
+			"\tCan be overridden on a per-group basis using the tag "+OnDemandPercentageScheduleTag+".\n"+
+			"\tExample: ./AutoSpotting --on_demand_percentage_schedule '* 9-17 * * 1-5=40 | * * * * *=0'\n")
+	flagSet.Float64Var(&conf.MinSavingsPercentage, "min_savings_percentage", 0.0,
+		"\n\tMinimum savings compared to the on-demand price, given as a percentage, for a spot instance\n"+
+			"\ttype to be considered as replacement. Avoids replacing instances for negligible savings.\n"+
+			"\tValid values are at least 0 and below 100.\n"+
+			"\tCan be overridden on a per-group basis using the tag "+MinSavingsPercentageTag+".\n"+
+			"\tExample: ./AutoSpotting -min_savings_percentage 30\n")
+	flagSet.StringVar(&conf.BurstableCompatibility, "burstable_compatibility", DefaultBurstableCompatibility,
+		"\n\tControls the replacements between burstable performance (T family) and fixed performance instance types.\n"+
+			"\tValid choices: 'strict' (burstable only with burstable, fixed only with fixed)\n"+
+			"\t| 'allow-fixed' (burstable also with fixed) | 'any'\n"+
+			"\tCan be overridden on a per-group basis using the tag "+BurstableCompatibilityTag+".\n"+
+			"\tExample: ./AutoSpotting -burstable_compatibility allow-fixed\n")
+	flagSet.StringVar(&conf.NetworkCompatibility, "network_compatibility", DefaultNetworkCompatibility,
+		"\n\tControls when the spot instances need to have at least the network bandwidth, number of network\n"+
+			"\tinterfaces and IP addresses per interface of the replaced on-demand instances.\n"+
+			"\tValid choices: 'auto' (only for Kubernetes nodes and instances with multiple network interfaces)\n"+
+			"\t| 'strict' (always) | 'off' (never)\n"+
+			"\tCan be overridden on a per-group basis using the tag "+NetworkCompatibilityTag+".\n"+
+			"\tExample: ./AutoSpotting -network_compatibility strict\n")
 	flagSet.Float64Var(&conf.OnDemandPriceMultiplier, "on_demand_price_multiplier", 1.0,
 		"\n\tMultiplier for the on-demand price. Numbers less than 1.0 are useful for volume discounts.\n"+
 			"\tExample: ./AutoSpotting -on_demand_price_multiplier 0.6 will have the on-demand price "+
 			"considered at 60% of the actual value.\n")
+	flagSet.Float64Var(&conf.ReverseSpotPriceRatio, "reverse_spot_price_ratio", 0.0,
+		"\n\tMaximum ratio between the spot and on-demand prices, above which the spot instances of a group are\n"+
+			"\tgradually replaced back with on-demand ones and no new spot instances are launched.\n"+
+			"\tBy default the spot instances are kept for as long as they are cheaper than on-demand.\n"+
+			"\tCan be overridden on a per-group basis using the tag "+ReverseSpotPriceRatioTag+".\n"+
+			"\tExample: ./AutoSpotting -reverse_spot_price_ratio 0.8\n")
+	flagSet.Int64Var(&conf.ReverseInterruptionThreshold, "reverse_interruption_threshold", 0,
+		"\n\tNumber of spot instances of a group interrupted in the last hour, at which its spot instances are\n"+
+			"\tgradually replaced back with on-demand ones and no new spot instances are launched.\n"+
+			"\tBy default the spot interruptions are not taken into account.\n"+
+			"\tCan be overridden on a per-group basis using the tag "+ReverseInterruptionThresholdTag+".\n"+
+			"\tExample: ./AutoSpotting -reverse_interruption_threshold 3\n")
+	flagSet.DurationVar(&conf.ReverseMinDuration, "reverse_min_duration", DefaultReverseMinDuration,
+		"\n\tMinimum time for which a group keeps its on-demand instances after its spot instances were last\n"+
+			"\tfound unattractive by the reverse_spot_price_ratio or reverse_interruption_threshold options,\n"+
+			"\tso it doesn't flip back and forth between spot and on-demand instances.\n"+
+			"\tCan be overridden on a per-group basis using the tag "+ReverseMinDurationTag+".\n"+
+			"\tExample: ./AutoSpotting -reverse_min_duration 6h\n")
 	flagSet.StringVar(&conf.Regions, "regions", "",
 		"\n\tRegions where it should be activated (separated by comma or whitespace, also supports globs).\n"+
 			"\tBy default it runs on all regions.\n"+
 			"\tExample: ./AutoSpotting -regions 'eu-*,us-east-1'\n")
+	flagSet.StringVar(&conf.TargetRegions, "target_regions", "",
+		"\n\tOnly process these regions instead of scanning all of them (separated by comma or whitespace).\n"+
+			"\tThey still need to be enabled by the regions option.\n"+
+			"\tExample: ./AutoSpotting -target_regions us-east-1\n")
+	flagSet.StringVar(&conf.TargetAutoScalingGroups, "target_autoscaling_groups", "",
+		"\n\tOnly process these AutoScaling groups instead of all the enabled ones (separated by comma or whitespace).\n"+
+			"\tThey still need to match the tag filters. Usually combined with target_regions.\n"+
+			"\tExample: ./AutoSpotting -target_regions us-east-1 -target_autoscaling_groups my-group\n")
 	flagSet.Float64Var(&conf.SpotPriceBufferPercentage, "spot_price_buffer_percentage", DefaultSpotPriceBufferPercentage,
 		"\n\tBid a given percentage above the current spot price.\n\tProtects the group from running spot"+
 			"instances that got significantly more expensive than when they were initially launched\n"+
 			"\tThe tag "+SpotPriceBufferPercentageTag+" can be used to override this on a group level.\n"+
 			"\tIf the bid exceeds the on-demand price, we place a bid at on-demand price itself.\n")
+	flagSet.Float64Var(&conf.SpotPriceCeiling, "spot_price_ceiling", 0.0,
+		"\n\tMaximum hourly price to pay for spot instances, also used as upper limit for the bid price.\n"+
+			"\tBy default there is no limit other than the on-demand price. Can't be negative.\n"+
+			"\tCan be overridden on a per-group basis using the tag "+SpotPriceCeilingTag+".\n"+
+			"\tExample: ./AutoSpotting -spot_price_ceiling 0.5\n")
 	flagSet.StringVar(&conf.SpotProductDescription, "spot_product_description", DefaultSpotProductDescription,
 		"\n\tThe Spot Product to use when looking up spot price history in the market.\n"+
+			"\tOnly used for instances whose operating system can't be detected from their AMI.\n"+
 			"\tValid choices: Linux/UNIX | SUSE Linux | Windows | Linux/UNIX (Amazon VPC) | \n"+
 			"\tSUSE Linux (Amazon VPC) | Windows (Amazon VPC) | Red Hat Enterprise Linux\n\tDefault value: "+DefaultSpotProductDescription+"\n")
 	flagSet.Float64Var(&conf.SpotProductPremium, "spot_product_premium", DefaultSpotProductPremium,
 		"\n\tThe Product Premium to apply to the on demand price to improve spot selection and savings calculations\n"+
-			"\twhen using a premium instance type such as RHEL.")
+			"\twhen using a premium instance type such as RHEL.\n"+
+			"\tOnly used for instances whose operating system can't be detected from their AMI.")
 	flagSet.StringVar(&conf.TagFilteringMode, "tag_filtering_mode", "opt-in", "\n\tControls the behavior of the tag_filters option.\n"+
 		"\tValid choices: opt-in | opt-out\n\tDefault value: 'opt-in'\n\tExample: ./AutoSpotting --tag_filtering_mode opt-out\n")
 	flagSet.StringVar(&conf.FilterByTags, "tag_filters", "", "\n\tSet of tags to filter the ASGs on.\n"+
 		"\tDefault if no value is set will be the equivalent of -tag_filters 'spot-enabled=true'\n"+
 		"\tIn case the tag_filtering_mode is set to opt-out, it defaults to 'spot-enabled=false'\n"+
 		"\tExample: ./AutoSpotting --tag_filters 'spot-enabled=true,Environment=dev,Team=vision'\n")
+	flagSet.StringVar(&conf.AutoScalingGroupSelector, "asg_selector", "", "\n\tSelector expression of the enabled groups,\n"+
+		"\treplacing the tag_filters and tag_filtering_mode options when set.\n"+
+		"\tCombines with and, or, not and parentheses the terms: key (tag exists), key=glob, key!=glob,\n"+
+		"\tkey~regex and key in (glob1,glob2), the asg:name key matching the group names instead of a tag.\n"+
+		"\tExample: ./AutoSpotting --asg_selector 'env in (dev,staging) and not team=payments'\n")
 
 	flagSet.StringVar(&conf.CronSchedule, "cron_schedule", "* *", "\n\tCron-like schedule in which to"+
 		"\tperform(or not) spot replacement actions. Format: hour day-of-week\n"+
-		"\tExample: ./AutoSpotting --cron_schedule '9-18 1-5' # workdays during the office hours \n")
+		"\tor minute hour day-of-month month day-of-week, with the nth day of week of the month given as 6#1.\n"+
+		"\tMultiple windows can be separated by ';', and those prefixed with '!' are excluded.\n"+
+		"\tExample: ./AutoSpotting --cron_schedule '9-18 1-5' # workdays during the office hours \n"+
+		"\tExample: ./AutoSpotting --cron_schedule '30-59 8 * * 1-5; * 9-17 * * 1-5; * 9-17 * * 6#1'\n")
 	flagSet.StringVar(&conf.CronTimezone, "cron_timezone", "UTC", "\n\tTimezone to"+
 		"\tperform(or not) spot replacement actions. Format: timezone\n"+
 		"\tExample: ./AutoSpotting --cron_timezone 'Europe/London' \n")
 
+	flagSet.StringVar(&conf.CronBlackoutWindows, "cron_blackout_windows", "", "\n\tComma separated dates or intervals "+
+		"in which no actions are taken, such as holidays or release freezes, in the cron_timezone.\n"+
+		"\tFormat: YYYY-MM-DD, YYYY-MM-DD/YYYY-MM-DD or YYYY-MM-DDTHH:MM/YYYY-MM-DDTHH:MM\n"+
+		"\tCan be overridden on a per-group basis using the tag "+CronBlackoutWindowsTag+".\n"+
+		"\tExample: ./AutoSpotting --cron_blackout_windows '2020-12-24/2020-12-26,2021-01-01'\n")
+
 	flagSet.StringVar(&conf.CronScheduleState, "cron_schedule_state", "on", "\n\tControls whether to take actions "+
 		"inside or outside the schedule defined by cron_schedule. Allowed values: on|off\n"+
 		"\tExample: ./AutoSpotting --cron_schedule_state='off' --cron_schedule '9-18 1-5'  # would only take action outside the defined schedule\n")
@@ -184,6 +357,39 @@ func ParseConfig(conf *Config) {
 	flagSet.StringVar(&conf.PatchBeanstalkUserdata, "patch_beanstalk_userdata", "", "\n\tControls whether AutoSpotting patches Elastic Beanstalk UserData scripts to use the instance role when calling CloudFormation helpers instead of the standard CloudFormation authentication method\n"+
 		"\tExample: ./AutoSpotting --patch_beanstalk_userdata true\n")
 
+	flagSet.StringVar(&conf.SubnetFailover, "subnet_failover", "false", "\n\tControls whether spot instances may be launched in the other subnets of the group "+
+		"when the subnet of the replaced on-demand instance has no spot capacity for any compatible instance type.\n"+
+		"\tCan be overridden on a per-group basis using the tag "+SubnetFailoverTag+".\n"+
+		"\tExample: ./AutoSpotting --subnet_failover true\n")
+
+	flagSet.StringVar(&conf.AllowCPUVendorSubstitution, "allow_cpu_vendor_substitution", "false", "\n\tControls whether Intel instances "+
+		"may be replaced with AMD spot instances and the other way round. The CPU architecture of the AMI is always enforced.\n"+
+		"\tCan be overridden on a per-group basis using the tag "+AllowCPUVendorSubstitutionTag+".\n"+
+		"\tExample: ./AutoSpotting --allow_cpu_vendor_substitution true\n")
+
+	flagSet.BoolVar(&conf.ReportMode, "report", false, "\n\tOnly prints the cheapest compatible spot instance types "+
+		"of each enabled group in each of its Availability Zones, and the reasons for which the other instance types "+
+		"were rejected, without replacing any instances.\n"+
+		"\tExample: ./AutoSpotting --report\n")
+	flagSet.IntVar(&conf.ReportTopCandidates, "report_top_candidates", DefaultReportTopCandidates,
+		"\n\tNumber of compatible spot instance types listed for each Availability Zone in the report.\n")
+
+	flagSet.StringVar(&conf.ConsiderReservedCapacity, "consider_reserved_capacity", "false", "\n\tControls whether the on-demand instances "+
+		"covered by Reserved Instances or Savings Plans are kept running instead of being replaced with spot instances.\n"+
+		"\tExample: ./AutoSpotting --consider_reserved_capacity true\n")
+	flagSet.StringVar(&conf.SavingsPlansCoverageFile, "savings_plans_coverage_file", "", "\n\tPath to a JSON file mapping regions to the "+
+		"hourly Savings Plans commitment and the average discount of its rates in each of them.\n"+
+		"\tExample: ./AutoSpotting --savings_plans_coverage_file coverage.json "+
+		"# {\"us-east-1\": {\"commitment\": 2.5, \"discount_percentage\": 28}}\n")
+
+	flagSet.StringVar(&conf.InstanceDataSource, "instance_data_source", BundledInstanceDataSource, "\n\tWhere to load the "+
+		"instance types pricing and specs data from, in the ec2instances.info JSON format.\n"+
+		"\tValid choices: 'bundled' (the snapshot built into AutoSpotting) | a local file path | an HTTP(S) URL\n"+
+		"\tExample: ./AutoSpotting --instance_data_source https://example.com/instances.json\n")
+	flagSet.DurationVar(&conf.InstanceDataCacheTTL, "instance_data_cache_ttl", DefaultInstanceDataCacheTTL, "\n\tHow long "+
+		"the instance data downloaded from an URL is cached before being downloaded again.\n"+
+		"\tExample: ./AutoSpotting --instance_data_cache_ttl 6h\n")
+
 	printVersion := flagSet.Bool("version", false, "Print version number and exit.\n")
 
 	if err := flagSet.Parse(os.Args[1:]); err != nil {
@@ -195,9 +401,58 @@ func ParseConfig(conf *Config) {
 		os.Exit(0)
 	}
 
-	data, err := ec2instancesinfo.Data()
+	conf.InstanceDataProvider = newInstanceDataProvider(conf.InstanceDataSource, conf.InstanceDataCacheTTL)
+	data, err := conf.InstanceDataProvider.Load()
 	if err != nil {
-		log.Fatal(err.Error())
+		log.Println("Couldn't load the instance data from", conf.InstanceDataSource,
+			"using the bundled data:", err.Error())
+		if data, err = ec2instancesinfo.Data(); err != nil {
+			log.Fatal(err.Error())
+		}
 	}
 	conf.InstanceData = data
+
+	if conf.SavingsPlansCoverageFile != "" {
+		coverage, err := loadSavingsPlansCoverage(conf.SavingsPlansCoverageFile)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		conf.SavingsPlansCoverage = coverage
+	}
+
+	// an invalid selector fails fast instead of not enabling any group, which
+	// would make all the spot instances launched by AutoSpotting orphaned
+	if conf.GroupSelector, err = loadGroupSelector(conf.AutoScalingGroupSelector); err != nil {
+		log.Fatalf("Invalid AutoScaling group selector %q: %s", conf.AutoScalingGroupSelector, err.Error())
+	}
+
+	if err := validateSpotPriceLimits(conf); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if err := validateMaxUnavailable(conf); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+// validateSpotPriceLimits checks the minimum savings percentage and the spot
+// price ceiling, which would otherwise silently prevent any replacement.
+func validateSpotPriceLimits(conf *Config) error {
+	if conf.MinSavingsPercentage < 0 || conf.MinSavingsPercentage >= 100 {
+		return fmt.Errorf("invalid min_savings_percentage %v, it should be at least 0 and below 100",
+			conf.MinSavingsPercentage)
+	}
+	if conf.SpotPriceCeiling < 0 {
+		return fmt.Errorf("invalid spot_price_ceiling %v, it can't be negative", conf.SpotPriceCeiling)
+	}
+	return nil
+}
+
+// validateMaxUnavailable checks the max_unavailable value, which would
+// otherwise prevent the spot instances from ever being replaced.
+func validateMaxUnavailable(conf *Config) error {
+	if conf.MaxUnavailable < 1 {
+		return fmt.Errorf("invalid max_unavailable %v, it should be at least 1", conf.MaxUnavailable)
+	}
+	return nil
 }
@@ -77,3 +77,57 @@ func TestParseConfig(t *testing.T) {
 		}
 	}
 }
+
+func Test_validateSpotPriceLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		minSavings float64
+		ceiling    float64
+		wantErr    bool
+	}{
+		{name: "default settings"},
+		{name: "valid limits", minSavings: 30, ceiling: 0.5},
+		{name: "negative min savings", minSavings: -1, wantErr: true},
+		{name: "min savings of 100%", minSavings: 100, wantErr: true},
+		{name: "min savings above 100%", minSavings: 150, wantErr: true},
+		{name: "negative ceiling", ceiling: -0.1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSpotPriceLimits(&Config{
+				AutoScalingConfig: AutoScalingConfig{
+					MinSavingsPercentage: tt.minSavings,
+					SpotPriceCeiling:     tt.ceiling,
+				},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSpotPriceLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateMaxUnavailable(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxUnavailable int64
+		wantErr        bool
+	}{
+		{name: "default value", maxUnavailable: DefaultMaxUnavailable},
+		{name: "several instances", maxUnavailable: 3},
+		{name: "zero", maxUnavailable: 0, wantErr: true},
+		{name: "negative", maxUnavailable: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateMaxUnavailable(&Config{
+				AutoScalingConfig: AutoScalingConfig{
+					MaxUnavailable: tt.maxUnavailable,
+				},
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateMaxUnavailable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -7,7 +7,6 @@
 package autospotting
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
@@ -25,17 +24,16 @@ type connections struct {
 	region         string
 }
 
-func (c *connections) setSession(region string) {
-	c.session = session.Must(
-		session.NewSession(&aws.Config{Region: aws.String(region)}))
+func (c *connections) setSession(region string, cfg *Config) {
+	c.session = newSession(region, cfg)
 }
 
-func (c *connections) connect(region string) {
+func (c *connections) connect(region string, cfg *Config) {
 
 	debug.Println("Creating service connections in", region)
 
 	if c.session == nil {
-		c.setSession(region)
+		c.setSession(region, cfg)
 	}
 
 	asConn := make(chan *autoscaling.AutoScaling)
@@ -0,0 +1,83 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultDeadlineSafetyMargin is the default time left before the deadline of
+// a run below which no new actions are started
+const DefaultDeadlineSafetyMargin = 60 * time.Second
+
+// The actions skipped during the current run because there was no time left
+// to complete them before the deadline, such as the Lambda timeout
+var unfinished = struct {
+	sync.Mutex
+	actions []string
+}{}
+
+// enoughTimeLeft checks if there's enough time left before the deadline of
+// the context to start the given action, which might otherwise be interrupted
+// halfway, for example after launching a spot instance but before attaching
+// it. The skipped actions are recorded so they can be reported at the end.
+func enoughTimeLeft(ctx context.Context, margin time.Duration, action string) bool {
+
+	if err := ctx.Err(); err != nil {
+		recordUnfinished(action)
+		return false
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) >= margin {
+		return true
+	}
+
+	recordUnfinished(action)
+	return false
+}
+
+// sleepContext waits for the given duration, returning the error of the
+// context as soon as it's cancelled or reaches its deadline.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func recordUnfinished(action string) {
+	unfinished.Lock()
+	defer unfinished.Unlock()
+	unfinished.actions = append(unfinished.actions, action)
+}
+
+func resetUnfinished() {
+	unfinished.Lock()
+	defer unfinished.Unlock()
+	unfinished.actions = nil
+}
+
+// logUnfinished reports the actions left for the next run.
+func logUnfinished() {
+	unfinished.Lock()
+	defer unfinished.Unlock()
+
+	if len(unfinished.actions) == 0 {
+		return
+	}
+
+	logger.Println("Ran out of time, left", len(unfinished.actions),
+		"actions for the next run:")
+	for _, action := range unfinished.actions {
+		logger.Println("  -", action)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_enoughTimeLeft(t *testing.T) {
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	soon, cancelSoon := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelSoon()
+
+	later, cancelLater := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancelLater()
+
+	tests := []struct {
+		name           string
+		ctx            context.Context
+		want           bool
+		wantUnfinished []string
+	}{
+		{name: "No deadline", ctx: context.Background(), want: true},
+		{name: "Deadline after the safety margin", ctx: later, want: true},
+		{name: "Deadline within the safety margin", ctx: soon, want: false,
+			wantUnfinished: []string{"attaching"}},
+		{name: "Cancelled", ctx: cancelled, want: false,
+			wantUnfinished: []string{"attaching"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetUnfinished()
+			if got := enoughTimeLeft(tt.ctx, time.Minute, "attaching"); got != tt.want {
+				t.Errorf("enoughTimeLeft() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(unfinished.actions, tt.wantUnfinished) {
+				t.Errorf("unfinished actions = %v, want %v", unfinished.actions, tt.wantUnfinished)
+			}
+		})
+	}
+	resetUnfinished()
+}
+
+func Test_autoScalingGroup_process_deadline(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resetUnfinished()
+	defer resetUnfinished()
+
+	a := &autoScalingGroup{
+		name:   "asg-test",
+		region: &region{name: "us-east-1", conf: &Config{DeadlineSafetyMargin: time.Minute}},
+	}
+
+	// would panic on the missing region data if it went ahead
+	a.process(ctx)
+
+	if want := []string{"processing group asg-test"}; !reflect.DeepEqual(unfinished.actions, want) {
+		t.Errorf("unfinished actions = %v, want %v", unfinished.actions, want)
+	}
+}
+
+func Test_sleepContext(t *testing.T) {
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := sleepContext(context.Background(), time.Millisecond); err != nil {
+		t.Errorf("sleepContext() error = %v, want nil", err)
+	}
+
+	start := time.Now()
+	if err := sleepContext(cancelled, time.Hour); err != context.Canceled {
+		t.Errorf("sleepContext() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("sleepContext() returned after %v, want it to return immediately", elapsed)
+	}
+}
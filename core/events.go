@@ -0,0 +1,282 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// InstanceStateChangeEvent is the detail type of the events sent by EC2
+	// when the state of an instance changes
+	InstanceStateChangeEvent = "EC2 Instance State-change Notification"
+
+	// InstanceLaunchSuccessfulEvent is the detail type of the events sent by
+	// AutoScaling when a group successfully launched an instance
+	InstanceLaunchSuccessfulEvent = "EC2 Instance Launch Successful"
+
+	// launchedForInstanceTag is set on the spot instances with the ID of the
+	// on-demand instance they were launched to replace
+	launchedForInstanceTag = "launched-for-instance"
+)
+
+// instanceEventData represents the JSON structure of the Detail property of
+// the EC2 instance state change and AutoScaling instance launch events
+type instanceEventData struct {
+	// set on the EC2 instance state change events
+	InstanceID string `json:"instance-id"`
+	State      string `json:"state"`
+
+	// set on the AutoScaling instance launch events
+	AutoScalingGroupName string `json:"AutoScalingGroupName"`
+	EC2InstanceID        string `json:"EC2InstanceId"`
+}
+
+// IsInstanceLaunchEvent returns true for the events sent when an instance was
+// launched, which may need to be replaced with a spot instance.
+func IsInstanceLaunchEvent(event events.CloudWatchEvent) bool {
+	return event.DetailType == InstanceStateChangeEvent ||
+		event.DetailType == InstanceLaunchSuccessfulEvent
+}
+
+// ProcessInstanceLaunchEvent replaces only the instance launched according to
+// the event, so the new on-demand instances can be replaced without waiting
+// for the next scheduled run, which processes all the groups of all the
+// regions.
+func ProcessInstanceLaunchEvent(ctx context.Context, cfg *Config, event events.CloudWatchEvent) {
+
+	setupLogging(cfg)
+
+	instanceID, asgName, err := parseInstanceLaunchEvent(event)
+	if err != nil {
+		logger.Println("Couldn't parse the", event.DetailType, "event:", err.Error())
+		return
+	}
+
+	if instanceID == "" {
+		debug.Println("Ignoring the", event.DetailType, "event, no instance was launched")
+		return
+	}
+
+	if err := refreshInstanceData(cfg); err != nil {
+		logger.Println("Couldn't load the instance data:", err.Error())
+		return
+	}
+
+	addDefaultFilteringMode(cfg)
+	addDefaultFilter(cfg)
+
+	r := region{name: event.Region, conf: cfg, launchedInstanceID: instanceID}
+	if !r.enabled() {
+		debug.Println("Not enabled to run in", r.name)
+		return
+	}
+
+	if asgName == "" {
+		r.services.connect(r.name, r.conf)
+		if asgName = r.autoScalingGroupOf(ctx, instanceID); asgName == "" {
+			debug.Println("Instance", instanceID, "does not belong to an AutoScaling group")
+			return
+		}
+	}
+
+	logger.Println("Processing group", asgName, "in", r.name, "after the launch of", instanceID)
+	resetAPIStats()
+	resetUnfinished()
+	r.processAutoScalingGroups(ctx, asgName)
+	logAPIStats()
+	logUnfinished()
+}
+
+// parseInstanceLaunchEvent returns the ID of the instance launched according
+// to the event, and the name of its AutoScaling group when the event includes
+// it. The instance ID is empty for the state changes other than running.
+func parseInstanceLaunchEvent(event events.CloudWatchEvent) (string, string, error) {
+	var detailData instanceEventData
+	if err := json.Unmarshal(event.Detail, &detailData); err != nil {
+		return "", "", err
+	}
+
+	switch event.DetailType {
+	case InstanceStateChangeEvent:
+		if detailData.State != ec2.InstanceStateNameRunning {
+			return "", "", nil
+		}
+		return detailData.InstanceID, "", nil
+	case InstanceLaunchSuccessfulEvent:
+		return detailData.EC2InstanceID, detailData.AutoScalingGroupName, nil
+	}
+	return "", "", nil
+}
+
+// autoScalingGroupOf returns the name of the AutoScaling group of an
+// instance, or an empty string if it doesn't belong to any.
+func (r *region) autoScalingGroupOf(ctx context.Context, instanceID string) string {
+	resp, err := r.services.autoScaling.DescribeAutoScalingInstancesWithContext(ctx,
+		&autoscaling.DescribeAutoScalingInstancesInput{
+			InstanceIds: []*string{aws.String(instanceID)},
+		})
+	if err != nil {
+		logger.Println("Failed to describe the AutoScaling instance", instanceID, err.Error())
+		return ""
+	}
+
+	if len(resp.AutoScalingInstances) == 0 {
+		return ""
+	}
+	return aws.StringValue(resp.AutoScalingInstances[0].AutoScalingGroupName)
+}
+
+// replaceLaunchedInstance replaces the on-demand instance launched according
+// to the event with a spot instance within the same invocation, so all the
+// instances launched when a group scales out are replaced at once by their
+// own events. Both the EC2 and the AutoScaling events are received for each
+// instance, so it only launches a spot instance if none was already launched
+// to replace it.
+func (a *autoScalingGroup) replaceLaunchedInstance(ctx context.Context, shouldRun bool) {
+	id := a.region.launchedInstanceID
+
+	if spotInst := a.findSpotInstanceLaunchedFor(id); spotInst != nil {
+		logger.Println(a.region.name, a.name, "Spot instance", *spotInst.InstanceId,
+			"was already launched to replace", id)
+		return
+	}
+
+	odInst := a.instances.get(id)
+	if odInst == nil || odInst.isSpot() || odInst.coveredBy != "" ||
+		aws.StringValue(odInst.State.Name) != ec2.InstanceStateNameRunning ||
+		odInst.isProtectedFromScaleIn() {
+		logger.Println(a.region.name, a.name, "Instance", id,
+			"isn't a running unprotected on-demand instance of the group, nothing to replace")
+		return
+	}
+	if protected, _ := odInst.isProtectedFromTermination(); protected {
+		logger.Println(a.region.name, a.name, "Instance", id,
+			"is protected from termination, nothing to replace")
+		return
+	}
+
+	if !a.needReplaceOnDemandInstances(ctx) {
+		logger.Println("Not allowed to replace any of the running OD instances in ", a.name)
+		return
+	}
+
+	if !shouldRun {
+		logger.Println(a.region.name, a.name,
+			"Skipping run, outside the enabled cron run schedule")
+		return
+	}
+
+	if !a.enoughTimeLeft(ctx, "launching a spot replacement for "+id+" in group "+a.name) {
+		return
+	}
+
+	if _, err := a.loadLaunchConfiguration(); err != nil {
+		logger.Printf("Could not launch configuration: %s", err)
+	}
+
+	spotInst, err := odInst.launchSpotReplacement(ctx)
+	if err != nil {
+		logger.Printf("Could not launch cheapest spot instance: %s", err)
+		return
+	}
+
+	if err := a.swapLaunchedInstance(ctx, odInst, spotInst); err != nil {
+		logger.Println(a.region.name, a.name, "Couldn't replace", id,
+			"with spot instance", *spotInst.InstanceId, err.Error())
+	}
+}
+
+// findSpotInstanceLaunchedFor returns the spot instance launched to replace
+// the given on-demand instance, if any.
+func (a *autoScalingGroup) findSpotInstanceLaunchedFor(instanceID string) *instance {
+	var found *instance
+
+	// going through all of them, so the channel is drained
+	for inst := range a.region.instances.instances() {
+		for _, tag := range inst.Tags {
+			if aws.StringValue(tag.Key) == launchedForInstanceTag &&
+				aws.StringValue(tag.Value) == instanceID {
+				found = inst
+			}
+		}
+	}
+	return found
+}
+
+// swapLaunchedInstance waits for the spot instance to be running and past the
+// health check grace period of the group, like the scheduled runs do before
+// attaching the spot instances, and then swaps it with the launched on-demand
+// instance. The spot instance is left to the scheduled runs when it was
+// launched in another availability zone, when it can't be ready before the
+// deadline or when the on-demand instance is no longer in service.
+func (a *autoScalingGroup) swapLaunchedInstance(ctx context.Context,
+	odInst *instance, launched *ec2.Instance) error {
+
+	spotInstanceID := *launched.InstanceId
+
+	if aws.StringValue(launched.Placement.AvailabilityZone) !=
+		aws.StringValue(odInst.Placement.AvailabilityZone) {
+		logger.Println(a.region.name, a.name, "Spot instance", spotInstanceID,
+			"was launched in another availability zone, leaving it to the next runs")
+		return nil
+	}
+
+	launchTime := time.Now()
+	if launched.LaunchTime != nil {
+		launchTime = *launched.LaunchTime
+	}
+	readyTime := launchTime.Add(time.Duration(aws.Int64Value(a.HealthCheckGracePeriod)) * time.Second)
+
+	action := "attaching spot instance " + spotInstanceID + " to group " + a.name
+	if !enoughTimeLeft(ctx, a.region.conf.DeadlineSafetyMargin+time.Until(readyTime), action) {
+		logger.Println(a.region.name, a.name, "Not enough time left to wait for spot instance",
+			spotInstanceID, "to be ready, leaving it to the next runs")
+		return nil
+	}
+
+	logger.Println(a.region.name, a.name, "Waiting for spot instance", spotInstanceID,
+		"to be ready before replacing", *odInst.InstanceId)
+
+	if err := a.region.services.ec2.WaitUntilInstanceRunningWithContext(ctx,
+		&ec2.DescribeInstancesInput{InstanceIds: []*string{launched.InstanceId}}); err != nil {
+		return err
+	}
+
+	if err := sleepContext(ctx, time.Until(readyTime)); err != nil {
+		return err
+	}
+
+	if !a.enoughTimeLeft(ctx, action) {
+		return nil
+	}
+
+	state, err := a.getInstanceLifecycleState(ctx, odInst.InstanceId)
+	if err != nil {
+		return err
+	}
+	if state != autoscaling.LifecycleStateInService {
+		logger.Println(a.region.name, a.name, "On-demand instance", *odInst.InstanceId,
+			"is no longer in service, leaving spot instance", spotInstanceID, "to the next runs")
+		return nil
+	}
+
+	spotInst := &instance{
+		Instance: launched,
+		typeInfo: a.region.instanceTypeInformation[aws.StringValue(launched.InstanceType)],
+		region:   a.region,
+		asg:      a,
+	}
+
+	logger.Println(a.region.name, a.name, "Replacing on-demand instance", *odInst.InstanceId,
+		"with spot instance", spotInstanceID)
+	return a.swapOnDemandInstanceWithSpot(ctx, odInst, spotInst)
+}
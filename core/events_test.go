@@ -0,0 +1,349 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestIsInstanceLaunchEvent(t *testing.T) {
+
+	tests := []struct {
+		detailType string
+		want       bool
+	}{
+		{detailType: InstanceStateChangeEvent, want: true},
+		{detailType: InstanceLaunchSuccessfulEvent, want: true},
+		{detailType: "EC2 Spot Instance Interruption Warning", want: false},
+		{detailType: "Scheduled Event", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.detailType, func(t *testing.T) {
+			event := events.CloudWatchEvent{DetailType: tt.detailType}
+			if got := IsInstanceLaunchEvent(event); got != tt.want {
+				t.Errorf("IsInstanceLaunchEvent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseInstanceLaunchEvent(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		event          events.CloudWatchEvent
+		wantInstanceID string
+		wantASGName    string
+		wantErr        bool
+	}{
+		{
+			name: "Instance running",
+			event: events.CloudWatchEvent{
+				DetailType: InstanceStateChangeEvent,
+				Detail:     []byte(`{"instance-id": "i-123456", "state": "running"}`),
+			},
+			wantInstanceID: "i-123456",
+		},
+		{
+			name: "Instance stopping",
+			event: events.CloudWatchEvent{
+				DetailType: InstanceStateChangeEvent,
+				Detail:     []byte(`{"instance-id": "i-123456", "state": "stopping"}`),
+			},
+		},
+		{
+			name: "Instance launched by a group",
+			event: events.CloudWatchEvent{
+				DetailType: InstanceLaunchSuccessfulEvent,
+				Detail: []byte(`{"AutoScalingGroupName": "asg-test", "EC2InstanceId": "i-123456",
+					"StatusCode": "InProgress"}`),
+			},
+			wantInstanceID: "i-123456",
+			wantASGName:    "asg-test",
+		},
+		{
+			name: "Invalid detail",
+			event: events.CloudWatchEvent{
+				DetailType: InstanceLaunchSuccessfulEvent,
+				Detail:     []byte(""),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instanceID, asgName, err := parseInstanceLaunchEvent(tt.event)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseInstanceLaunchEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if instanceID != tt.wantInstanceID || asgName != tt.wantASGName {
+				t.Errorf("parseInstanceLaunchEvent() = %q, %q, want %q, %q",
+					instanceID, asgName, tt.wantInstanceID, tt.wantASGName)
+			}
+		})
+	}
+}
+
+func Test_region_autoScalingGroupOf(t *testing.T) {
+
+	tests := []struct {
+		name string
+		asg  mockASG
+		want string
+	}{
+		{
+			name: "Instance of a group",
+			asg: mockASG{
+				dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+					AutoScalingInstances: []*autoscaling.InstanceDetails{
+						{AutoScalingGroupName: aws.String("asg-test")},
+					},
+				},
+			},
+			want: "asg-test",
+		},
+		{
+			name: "Instance not belonging to any group",
+			asg: mockASG{
+				dasio: &autoscaling.DescribeAutoScalingInstancesOutput{},
+			},
+			want: "",
+		},
+		{
+			name: "Error describing the instance",
+			asg: mockASG{
+				dasierr: errors.New("error"),
+			},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{services: connections{autoScaling: tt.asg}}
+			if got := r.autoScalingGroupOf(context.Background(), "i-123456"); got != tt.want {
+				t.Errorf("autoScalingGroupOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_replaceLaunchedInstance(t *testing.T) {
+
+	running := func(id, lifecycle, coveredBy string) *instance {
+		return &instance{
+			Instance: &ec2.Instance{
+				InstanceId:        aws.String(id),
+				State:             &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+				Placement:         &ec2.Placement{AvailabilityZone: aws.String("eu-west-1a")},
+				InstanceLifecycle: aws.String(lifecycle),
+			},
+			coveredBy: coveredBy,
+		}
+	}
+
+	// none of these cases launches a spot instance
+	tests := []struct {
+		name                     string
+		launched                 string
+		protectedFromScaleIn     bool
+		protectedFromTermination bool
+	}{
+		{
+			name:     "Spot instance already launched to replace it",
+			launched: "ondemand-2",
+		},
+		{
+			name:     "Launched instance not found",
+			launched: "ondemand-missing",
+		},
+		{
+			name:     "Launched spot instance",
+			launched: "spot-1",
+		},
+		{
+			name:     "Launched instance covered by a reservation",
+			launched: "ondemand-reserved",
+		},
+		{
+			name:                 "Launched instance protected from scale-in",
+			launched:             "ondemand-1",
+			protectedFromScaleIn: true,
+		},
+		{
+			name:                     "Launched instance protected from termination",
+			launched:                 "ondemand-1",
+			protectedFromTermination: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var launches []*ec2.RunInstancesInput
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{
+					Instances: []*autoscaling.Instance{
+						{InstanceId: aws.String("ondemand-1"), AvailabilityZone: aws.String("eu-west-1a"), ProtectedFromScaleIn: aws.Bool(tt.protectedFromScaleIn)},
+						{InstanceId: aws.String("ondemand-2"), AvailabilityZone: aws.String("eu-west-1a"), ProtectedFromScaleIn: aws.Bool(false)},
+						{InstanceId: aws.String("ondemand-reserved"), AvailabilityZone: aws.String("eu-west-1a"), ProtectedFromScaleIn: aws.Bool(false)},
+						{InstanceId: aws.String("spot-1"), AvailabilityZone: aws.String("eu-west-1a"), ProtectedFromScaleIn: aws.Bool(false)},
+					},
+				},
+				name: "asg-test",
+				region: &region{
+					name:               "eu-west-1",
+					conf:               &Config{},
+					launchedInstanceID: tt.launched,
+					services: connections{ec2: mockEC2{
+						rierr:    errors.New("unexpected launch"),
+						riInputs: &launches,
+						diao: &ec2.DescribeInstanceAttributeOutput{
+							DisableApiTermination: &ec2.AttributeBooleanValue{
+								Value: aws.Bool(tt.protectedFromTermination),
+							},
+						},
+					}},
+				},
+			}
+
+			instances := instanceMap{
+				"ondemand-1":        running("ondemand-1", "", ""),
+				"ondemand-2":        running("ondemand-2", "", ""),
+				"ondemand-reserved": running("ondemand-reserved", "", "reserved instance"),
+				"spot-1":            running("spot-1", "spot", ""),
+			}
+			for _, inst := range instances {
+				inst.asg, inst.region = a, a.region
+			}
+			a.instances = makeInstancesWithCatalog(instances)
+
+			pendingSpot := running("spot-pending", "spot", "")
+			pendingSpot.Tags = []*ec2.Tag{
+				{Key: aws.String("launched-for-asg"), Value: aws.String("asg-test")},
+				{Key: aws.String(launchedForInstanceTag), Value: aws.String("ondemand-2")},
+			}
+			regionInstances := instanceMap{"spot-pending": pendingSpot}
+			for id, inst := range instances {
+				regionInstances[id] = inst
+			}
+			a.region.instances = makeInstancesWithCatalog(regionInstances)
+
+			a.replaceLaunchedInstance(context.Background(), true)
+
+			if len(launches) != 0 {
+				t.Errorf("replaceLaunchedInstance() launched %d spot instances, want none", len(launches))
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_swapLaunchedInstance(t *testing.T) {
+
+	inAZ := func(id, az string) *ec2.Instance {
+		return &ec2.Instance{
+			InstanceId:   aws.String(id),
+			InstanceType: aws.String("m5.large"),
+			Placement:    &ec2.Placement{AvailabilityZone: aws.String(az)},
+			LaunchTime:   aws.Time(time.Now().Add(-time.Minute)),
+		}
+	}
+
+	asgInstance := func(state string) *autoscaling.DescribeAutoScalingInstancesOutput {
+		return &autoscaling.DescribeAutoScalingInstancesOutput{
+			AutoScalingInstances: []*autoscaling.InstanceDetails{{
+				InstanceId:     aws.String("ondemand-1"),
+				LifecycleState: aws.String(state),
+			}},
+		}
+	}
+
+	tests := []struct {
+		name           string
+		launched       *ec2.Instance
+		gracePeriod    int64
+		timeout        time.Duration
+		odState        string
+		waitErr        error
+		wantErr        bool
+		wantTerminated bool
+	}{
+		{
+			name:           "Swapped with the launched instance",
+			launched:       inAZ("spot-1", "eu-west-1a"),
+			odState:        autoscaling.LifecycleStateInService,
+			wantTerminated: true,
+		},
+		{
+			name:     "Launched in another availability zone",
+			launched: inAZ("spot-1", "eu-west-1b"),
+			odState:  autoscaling.LifecycleStateInService,
+		},
+		{
+			name:        "Not ready before the deadline",
+			launched:    inAZ("spot-1", "eu-west-1a"),
+			gracePeriod: 3600,
+			timeout:     time.Minute,
+			odState:     autoscaling.LifecycleStateInService,
+		},
+		{
+			name:     "Launched instance no longer in service",
+			launched: inAZ("spot-1", "eu-west-1a"),
+			odState:  autoscaling.LifecycleStateTerminatingWait,
+		},
+		{
+			name:     "Spot instance failing to run",
+			launched: inAZ("spot-1", "eu-west-1a"),
+			odState:  autoscaling.LifecycleStateInService,
+			waitErr:  errors.New("ResourceNotReady"),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var terminated []*autoscaling.TerminateInstanceInAutoScalingGroupInput
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{
+					DesiredCapacity:        aws.Int64(2),
+					MaxSize:                aws.Int64(4),
+					HealthCheckGracePeriod: aws.Int64(tt.gracePeriod),
+				},
+				name: "asg-test",
+				region: &region{
+					name: "eu-west-1",
+					conf: &Config{},
+					services: connections{
+						ec2: mockEC2{wuirerr: tt.waitErr},
+						autoScaling: mockASG{
+							dasio:        asgInstance(tt.odState),
+							tiiasgInputs: &terminated,
+						},
+					},
+				},
+			}
+			odInst := &instance{Instance: inAZ("ondemand-1", "eu-west-1a"), asg: a, region: a.region}
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			err := a.swapLaunchedInstance(ctx, odInst, tt.launched)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("swapLaunchedInstance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := len(terminated) == 1 && *terminated[0].InstanceId == "ondemand-1"; got != tt.wantTerminated {
+				t.Errorf("swapLaunchedInstance() terminated %v, want the launched instance terminated %v",
+					terminated, tt.wantTerminated)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The naming of the EBS volume devices, which changes between the instance
+// types running on the Xen and the Nitro hypervisors
+const (
+	deviceNamingXen  = "xen"
+	deviceNamingNVMe = "nvme"
+)
+
+// deviceNaming tells how the EBS volumes are exposed to the operating system,
+// either as NVMe devices like /dev/nvme1n1 on the Nitro instance types, or as
+// the /dev/sdf or /dev/xvdf devices given in the block device mappings on the
+// Xen instance types. It's empty when unknown.
+func (info instanceTypeInformation) deviceNaming() string {
+	switch info.ebsNVMeSupport {
+	case ec2.EbsNvmeSupportUnsupported:
+		return deviceNamingXen
+	case ec2.EbsNvmeSupportSupported, ec2.EbsNvmeSupportRequired:
+		return deviceNamingNVMe
+	}
+
+	switch info.hypervisor {
+	case ec2.InstanceTypeHypervisorXen:
+		return deviceNamingXen
+	case ec2.InstanceTypeHypervisorNitro:
+		return deviceNamingNVMe
+	}
+	return ""
+}
+
+// isHypervisorCompatible refuses the instance types running on a different
+// hypervisor or exposing the EBS volumes with different device names than the
+// current instance type, since the block device mappings are copied verbatim
+// and the software referencing the devices, such as the fstab entries set in
+// the user data, may break. This can be allowed for a group using the
+// autospotting_allow_hypervisor_change tag. The check is skipped when the data
+// is missing for either instance type.
+func (i *instance) isHypervisorCompatible(spotCandidate instanceTypeInformation) bool {
+	if i.asg != nil && strings.ToLower(i.asg.config.AllowHypervisorChange) == "true" {
+		return true
+	}
+
+	current := i.typeInfo
+
+	if current.hypervisor != "" && spotCandidate.hypervisor != "" &&
+		current.hypervisor != spotCandidate.hypervisor {
+		debug.Println("\tNot hypervisor compatible, current hypervisor", current.hypervisor,
+			"is different from candidate hypervisor", spotCandidate.hypervisor)
+		return false
+	}
+
+	currentNaming, candidateNaming := current.deviceNaming(), spotCandidate.deviceNaming()
+	if currentNaming != "" && candidateNaming != "" && currentNaming != candidateNaming {
+		debug.Println("\tNot hypervisor compatible, the EBS device naming changes from",
+			currentNaming, "to", candidateNaming)
+		return false
+	}
+	return true
+}
+
+// ebsOptimizedFor only requests EBS optimization for the spot instance if the
+// original instance was EBS optimized and the spot instance type supports it,
+// since launching the types without EBS optimization support would fail.
+func (i *instance) ebsOptimizedFor(instanceType string) *bool {
+	if !aws.BoolValue(i.EbsOptimized) || i.region == nil {
+		return i.EbsOptimized
+	}
+
+	if info, ok := i.region.instanceTypeInformation[instanceType]; ok && !info.hasEBSOptimization {
+		debug.Println("Not requesting EBS optimization for", instanceType, "which doesn't support it")
+		return nil
+	}
+	return i.EbsOptimized
+}
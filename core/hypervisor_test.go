@@ -0,0 +1,99 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_instanceTypeInformation_deviceNaming(t *testing.T) {
+	tests := []struct {
+		name     string
+		info     instanceTypeInformation
+		expected string
+	}{
+		{name: "NVMe required", info: instanceTypeInformation{ebsNVMeSupport: "required"}, expected: deviceNamingNVMe},
+		{name: "NVMe unsupported", info: instanceTypeInformation{ebsNVMeSupport: "unsupported", hypervisor: "nitro"}, expected: deviceNamingXen},
+		{name: "Nitro hypervisor", info: instanceTypeInformation{hypervisor: "nitro"}, expected: deviceNamingNVMe},
+		{name: "Xen hypervisor", info: instanceTypeInformation{hypervisor: "xen"}, expected: deviceNamingXen},
+		{name: "unknown", info: instanceTypeInformation{}, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.info.deviceNaming(); got != tt.expected {
+				t.Errorf("deviceNaming() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_isHypervisorCompatible(t *testing.T) {
+	xen := instanceTypeInformation{instanceType: "m4.large", hypervisor: "xen", ebsNVMeSupport: "unsupported"}
+	nitro := instanceTypeInformation{instanceType: "m5.large", hypervisor: "nitro", ebsNVMeSupport: "required"}
+	metal := instanceTypeInformation{instanceType: "m5.metal", ebsNVMeSupport: "required"}
+	static := instanceTypeInformation{instanceType: "m5a.large"}
+
+	tests := []struct {
+		name      string
+		allow     string
+		current   instanceTypeInformation
+		candidate instanceTypeInformation
+		expected  bool
+	}{
+		{name: "same hypervisor", current: nitro, candidate: nitro, expected: true},
+		{name: "Xen to Nitro", current: xen, candidate: nitro, expected: false},
+		{name: "Nitro to Xen", current: nitro, candidate: xen, expected: false},
+		{name: "Xen to Nitro acknowledged", allow: "true", current: xen, candidate: nitro, expected: true},
+		{name: "Nitro to bare metal", current: nitro, candidate: metal, expected: true},
+		{name: "Xen to bare metal", current: xen, candidate: metal, expected: false},
+		{name: "unknown candidate", current: xen, candidate: static, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				typeInfo: tt.current,
+				asg:      &autoScalingGroup{config: AutoScalingConfig{AllowHypervisorChange: tt.allow}},
+			}
+			if got := i.isHypervisorCompatible(tt.candidate); got != tt.expected {
+				t.Errorf("isHypervisorCompatible() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_ebsOptimizedFor(t *testing.T) {
+	r := &region{
+		instanceTypeInformation: map[string]instanceTypeInformation{
+			"m5.large": {instanceType: "m5.large", hasEBSOptimization: true},
+			"t2.large": {instanceType: "t2.large"},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		ebsOptimized *bool
+		instanceType string
+		expected     *bool
+	}{
+		{name: "supported", ebsOptimized: aws.Bool(true), instanceType: "m5.large", expected: aws.Bool(true)},
+		{name: "unsupported", ebsOptimized: aws.Bool(true), instanceType: "t2.large", expected: nil},
+		{name: "unknown type", ebsOptimized: aws.Bool(true), instanceType: "x9.large", expected: aws.Bool(true)},
+		{name: "not EBS optimized", ebsOptimized: aws.Bool(false), instanceType: "t2.large", expected: aws.Bool(false)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{Instance: &ec2.Instance{EbsOptimized: tt.ebsOptimized}, region: r}
+			got := i.ebsOptimizedFor(tt.instanceType)
+			if (got == nil) != (tt.expected == nil) || aws.BoolValue(got) != aws.BoolValue(tt.expected) {
+				t.Errorf("ebsOptimizedFor() = %v, expected %v", aws.BoolValue(got), aws.BoolValue(tt.expected))
+			}
+		})
+	}
+}
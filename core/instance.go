@@ -4,6 +4,7 @@
 package autospotting
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"path/filepath"
@@ -104,6 +105,12 @@ type instance struct {
 	region    *region
 	protected bool
 	asg       *autoScalingGroup
+
+	// set for the on-demand instances covered by reservations
+	coveredBy string
+
+	// the CPU credits option of the burstable instances, once described
+	cpuCredits *string
 }
 
 type acceptableInstance struct {
@@ -125,10 +132,36 @@ type instanceTypeInformation struct {
 	instanceStoreIsSSD       bool
 	hasEBSOptimization       bool
 	EBSThroughput            float32
+
+	// only known for the types reported by the DescribeInstanceTypes API,
+	// except for the architectures and the network performance also found
+	// in the static data
+	architectures            []string
+	enaSupport               string
+	networkPerformance       string
+	maxENIs                  int
+	ipv4PerENI               int
+	ebsNVMeSupport           string
+	instanceStoreNVMeSupport string
+	hypervisor               string
+
+	// the GPU, FPGA or inference accelerators, the model is the GPU model
+	// for the GPU instance types
+	acceleratorType  string
+	acceleratorModel string
+	acceleratorCount int
+	gpuMemory        float32
+
+	// burstable performance instance types, running on CPU credits
+	burstable bool
 }
 
 func (i *instance) calculatePrice(spotCandidate instanceTypeInformation) float64 {
-	spotPrice := spotCandidate.pricing.spot[*i.Placement.AvailabilityZone]
+	return i.calculatePriceInAZ(spotCandidate, *i.Placement.AvailabilityZone)
+}
+
+func (i *instance) calculatePriceInAZ(spotCandidate instanceTypeInformation, az string) float64 {
+	spotPrice := spotCandidate.pricing.spot[az]
 	debug.Println("Comparing price spot/instance:")
 
 	if i.EbsOptimized != nil && *i.EbsOptimized {
@@ -136,6 +169,11 @@ func (i *instance) calculatePrice(spotCandidate instanceTypeInformation) float64
 		debug.Println("\tEBS Surcharge : ", spotCandidate.pricing.ebsSurcharge)
 	}
 
+	if spotCandidate.pricing.licenseSurcharge > 0 {
+		spotPrice += spotCandidate.pricing.licenseSurcharge
+		debug.Println("\tLicense Surcharge : ", spotCandidate.pricing.licenseSurcharge)
+	}
+
 	debug.Println("\tSpot price: ", spotPrice)
 	debug.Println("\tInstance price: ", i.price)
 	return spotPrice
@@ -196,10 +234,10 @@ func (i *instance) canTerminate() bool {
 		*i.State.Name != ec2.InstanceStateNameShuttingDown
 }
 
-func (i *instance) terminate() error {
+func (i *instance) terminate(ctx context.Context) error {
 	svc := i.region.services.ec2
 	if i.canTerminate() {
-		_, err := svc.TerminateInstances(&ec2.TerminateInstancesInput{
+		_, err := svc.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{
 			InstanceIds: []*string{i.InstanceId},
 		})
 		if err != nil {
@@ -211,17 +249,44 @@ func (i *instance) terminate() error {
 }
 
 func (i *instance) isPriceCompatible(spotPrice float64) bool {
+	reason := i.priceIncompatibilityReason(spotPrice)
+	if reason == "" {
+		return true
+	}
+
+	debug.Printf("\tNot price compatible: %s", reason)
+	return false
+}
+
+// priceIncompatibilityReason explains why a spot price isn't acceptable for
+// replacing the current instance, or returns an empty string if it is.
+func (i *instance) priceIncompatibilityReason(spotPrice float64) string {
 	if spotPrice == 0 {
-		debug.Printf("\tUnavailable in this Availability Zone")
-		return false
+		return "unavailable in this Availability Zone"
 	}
 
-	if spotPrice <= i.price {
-		return true
+	if spotPrice > i.price {
+		return "more expensive than the on-demand price"
 	}
 
-	debug.Printf("\tNot price compatible")
-	return false
+	if i.asg == nil {
+		return ""
+	}
+
+	if ceiling := i.asg.config.SpotPriceCeiling; ceiling > 0 && spotPrice > ceiling {
+		return fmt.Sprintf("above the spot price ceiling of %v", ceiling)
+	}
+
+	if minSavings := i.asg.config.MinSavingsPercentage; minSavings > 0 &&
+		(i.price-spotPrice)/i.price*100 < minSavings {
+		return fmt.Sprintf("saving less than the minimum of %v%%", minSavings)
+	}
+
+	if maxRatio := i.asg.config.ReverseSpotPriceRatio; maxRatio > 0 && spotPrice > i.price*maxRatio {
+		return fmt.Sprintf("above the ratio of %v of the on-demand price", maxRatio)
+	}
+
+	return ""
 }
 
 func (i *instance) isClassCompatible(spotCandidate instanceTypeInformation) bool {
@@ -243,7 +308,24 @@ func (i *instance) isClassCompatible(spotCandidate instanceTypeInformation) bool
 	return false
 }
 
+// isSameArch checks that the candidate instance type supports the CPU
+// architecture of the instance's AMI, which is the only one it can run on.
+// When the AMI architecture isn't known it compares the architectures supported
+// by both instance types, and only as last resort their processor names.
 func (i *instance) isSameArch(other instanceTypeInformation) bool {
+	if arch := i.architecture(); arch != "" && len(other.architectures) > 0 {
+		if hasArch(other.architectures, arch) {
+			return true
+		}
+		debug.Println("\tInstance CPU architecture mismatch, the AMI architecture",
+			arch, "isn't supported by the candidate architectures", other.architectures)
+		return false
+	}
+
+	if len(i.typeInfo.architectures) > 0 && len(other.architectures) > 0 {
+		return i.hasCommonArch(other)
+	}
+
 	thisCPU := i.typeInfo.PhysicalProcessor
 	otherCPU := other.PhysicalProcessor
 
@@ -257,6 +339,58 @@ func (i *instance) isSameArch(other instanceTypeInformation) bool {
 	return ret
 }
 
+// architecture returns the CPU architecture of the instance, inherited from
+// its AMI, or an empty string if it's not known.
+func (i *instance) architecture() string {
+	if i.Instance == nil {
+		return ""
+	}
+	return aws.StringValue(i.Architecture)
+}
+
+// hasCommonArch compares the architectures reported by the EC2 API, when known
+// for both instance types.
+func (i *instance) hasCommonArch(other instanceTypeInformation) bool {
+	for _, this := range i.typeInfo.architectures {
+		if hasArch(other.architectures, this) {
+			return true
+		}
+	}
+	debug.Println("\tInstance CPU architecture mismatch, current architectures",
+		i.typeInfo.architectures, "are incompatible with candidate architectures", other.architectures)
+	return false
+}
+
+func hasArch(architectures []string, arch string) bool {
+	for _, a := range architectures {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// isCPUVendorCompatible prevents replacing Intel instances with AMD ones and
+// the other way round, unless explicitly allowed for the group, since software
+// may be tuned for or licensed on a specific CPU vendor even if both run the
+// same x86_64 AMIs. Processors of unknown vendors are considered compatible.
+func (i *instance) isCPUVendorCompatible(spotCandidate instanceTypeInformation) bool {
+	if i.asg == nil || strings.ToLower(i.asg.config.AllowCPUVendorSubstitution) == "true" {
+		return true
+	}
+
+	thisCPU := i.typeInfo.PhysicalProcessor
+	otherCPU := spotCandidate.PhysicalProcessor
+
+	if (isIntel(thisCPU) && isAMD(otherCPU)) || (isAMD(thisCPU) && isIntel(otherCPU)) {
+		debug.Println("\tInstance CPU vendor mismatch, current CPU", thisCPU,
+			"can't be replaced by candidate CPU", otherCPU, "unless allowed using the tag",
+			AllowCPUVendorSubstitutionTag)
+		return false
+	}
+	return true
+}
+
 func isIntelCompatible(cpuName string) bool {
 	return isIntel(cpuName) || isAMD(cpuName)
 }
@@ -357,17 +491,64 @@ func (i *instance) isAllowed(instanceType string, allowedList []string, disallow
 	return true
 }
 
+// incompatibilityReason runs the hardware compatibility checks of the candidate
+// instance type, returning the reason for which it can't replace the current
+// instance, or an empty string if it can.
+func (i *instance) incompatibilityReason(spotCandidate instanceTypeInformation,
+	attachedVolumes int, requireNetworkCapacity bool) string {
+	switch {
+	case !i.isEBSCompatible(spotCandidate):
+		return "insufficient EBS throughput"
+	case !i.isClassCompatible(spotCandidate):
+		return "different CPU architecture or fewer vCPUs, memory or GPUs"
+	case !i.isCPUVendorCompatible(spotCandidate):
+		return "different CPU vendor"
+	case !i.isAcceleratorCompatible(spotCandidate):
+		return "incompatible accelerators"
+	case !i.isBurstableCompatible(spotCandidate):
+		return "burstable performance mismatch"
+	case !i.isHypervisorCompatible(spotCandidate):
+		return "different hypervisor or EBS device naming"
+	case !i.isStorageCompatible(spotCandidate, attachedVolumes):
+		return "insufficient instance storage"
+	case !i.isVirtualizationCompatible(spotCandidate.virtualizationTypes):
+		return "unsupported virtualization type"
+	case !i.isNetworkCompatible(spotCandidate):
+		return "ENA support required"
+	case !i.isNetworkCapacityCompatible(spotCandidate, requireNetworkCapacity):
+		return "insufficient network capacity"
+	}
+	return ""
+}
+
+// attachedInstanceStoreVolumes counts the ephemeral volumes attached to the
+// original instance's block device mappings, which need to be available on
+// the candidate instance types.
+func (i *instance) attachedInstanceStoreVolumes() int {
+	usedMappings := i.asg.launchConfiguration.countLaunchConfigEphemeralVolumes()
+	return min(usedMappings, i.typeInfo.instanceStoreDeviceCount)
+}
+
 func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allowedList []string,
+	disallowedList []string) ([]instanceTypeInformation, error) {
+	return i.getCompatibleSpotInstanceTypesInAZ(*i.Placement.AvailabilityZone, allowedList, disallowedList)
+}
+
+// getCompatibleSpotInstanceTypesInAZ returns the instance types compatible with
+// the current instance, priced in the given availability zone and sorted
+// ascending by price.
+func (i *instance) getCompatibleSpotInstanceTypesInAZ(az string, allowedList []string,
 	disallowedList []string) ([]instanceTypeInformation, error) {
 	current := i.typeInfo
 	var acceptableInstanceTypes []acceptableInstance
 
-	// Count the ephemeral volumes attached to the original instance's block
-	// device mappings, this number is used later when comparing with each
-	// instance type.
+	attachedVolumesNumber := i.attachedInstanceStoreVolumes()
 
-	usedMappings := i.asg.launchConfiguration.countLaunchConfigEphemeralVolumes()
-	attachedVolumesNumber := min(usedMappings, current.instanceStoreDeviceCount)
+	// Count the reasons for which instance types were rejected because of their
+	// price, so we can explain why no candidate could be found.
+	priceRejections := make(map[string]int)
+
+	requireNetworkCapacity := i.requiresNetworkCapacity()
 
 	// Iterate alphabetically by instance type
 	keys := make([]string, 0)
@@ -378,18 +559,21 @@ func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allo
 
 	// Find all compatible and not blocked instance types
 	for _, k := range keys {
-		candidate := i.region.instanceTypeInformation[k]
+		candidate := i.region.instanceTypeInformation[k].forPlatform(i.launchPlatform())
 
-		candidatePrice := i.calculatePrice(candidate)
+		candidatePrice := i.calculatePriceInAZ(candidate, az)
 		debug.Println("Comparing current type", current.instanceType, "with price", i.price,
 			"with candidate", candidate.instanceType, "with price", candidatePrice)
 
-		if i.isAllowed(candidate.instanceType, allowedList, disallowedList) &&
+		allowed := i.isAllowed(candidate.instanceType, allowedList, disallowedList)
+
+		if reason := i.priceIncompatibilityReason(candidatePrice); allowed && reason != "" {
+			priceRejections[reason]++
+		}
+
+		if allowed &&
 			i.isPriceCompatible(candidatePrice) &&
-			i.isEBSCompatible(candidate) &&
-			i.isClassCompatible(candidate) &&
-			i.isStorageCompatible(candidate, attachedVolumesNumber) &&
-			i.isVirtualizationCompatible(candidate.virtualizationTypes) {
+			i.incompatibilityReason(candidate, attachedVolumesNumber, requireNetworkCapacity) == "" {
 			acceptableInstanceTypes = append(acceptableInstanceTypes, acceptableInstance{candidate, candidatePrice})
 			logger.Println("\tMATCH FOUND, added", candidate.instanceType, "to launch candiates list for instance", i.InstanceId)
 		} else if candidate.instanceType != "" {
@@ -410,51 +594,224 @@ func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allo
 		return result, nil
 	}
 
+	for reason, count := range priceRejections {
+		logger.Println(i.asg.name, count, "instance types were rejected for instance",
+			aws.StringValue(i.InstanceId), "because their spot price in", az, "is", reason)
+	}
+
 	return nil, fmt.Errorf("No cheaper spot instance types could be found")
 }
 
-func (i *instance) launchSpotReplacement() error {
+// spotLaunchCandidate is a combination of instance type and subnet in which
+// we can attempt to launch a spot instance.
+type spotLaunchCandidate struct {
+	instanceType instanceTypeInformation
+	subnetID     *string
+	az           string
+	price        float64
+}
+
+// getFailoverSubnets returns the other subnets of the group in which we may
+// launch the spot replacement when the subnet of the current instance has no
+// capacity, mapped to their availability zone. Only the subnets from
+// availability zones where a spot instance could later replace an on-demand
+// instance are considered.
+func (i *instance) getFailoverSubnets(ctx context.Context) map[string]string {
+	ownAZ := *i.Placement.AvailabilityZone
+	ownSubnet := aws.StringValue(i.SubnetId)
+	subnets := make(map[string]string)
+
+	if strings.ToLower(i.asg.config.SubnetFailover) != "true" ||
+		i.asg.VPCZoneIdentifier == nil || ownSubnet == "" {
+		return subnets
+	}
+
+	var subnetIDs []*string
+	for _, id := range strings.Split(*i.asg.VPCZoneIdentifier, ",") {
+		if id = strings.TrimSpace(id); id != "" && id != ownSubnet {
+			subnetIDs = append(subnetIDs, aws.String(id))
+		}
+	}
+
+	if len(subnetIDs) == 0 {
+		return subnets
+	}
+
+	resp, err := i.region.services.ec2.DescribeSubnetsWithContext(ctx,
+		&ec2.DescribeSubnetsInput{SubnetIds: subnetIDs})
+
+	if err != nil {
+		logger.Println(i.asg.name, "Failed to describe the subnets of the group, "+
+			"not failing over to other subnets:", err.Error())
+		return subnets
+	}
+
+	for _, subnet := range resp.Subnets {
+		az := *subnet.AvailabilityZone
+		if az != ownAZ && i.asg.getUnprotectedOnDemandInstanceToReplaceWithSpotInAZ(&az) == nil {
+			debug.Println(i.asg.name, "Not failing over to subnet", *subnet.SubnetId,
+				"since there's no on-demand instance to replace from", az)
+			continue
+		}
+		subnets[*subnet.SubnetId] = az
+	}
+	return subnets
+}
+
+// getSpotLaunchCandidates combines the compatible instance types, already
+// sorted ascending by price, with the subnet of the current instance.
+func (i *instance) getSpotLaunchCandidates(instanceTypes []instanceTypeInformation) []spotLaunchCandidate {
+	var candidates []spotLaunchCandidate
+	az := *i.Placement.AvailabilityZone
+
+	for _, instanceType := range instanceTypes {
+		candidates = append(candidates, spotLaunchCandidate{
+			instanceType: instanceType,
+			subnetID:     i.SubnetId,
+			az:           az,
+			price:        i.calculatePriceInAZ(instanceType, az),
+		})
+	}
+	return candidates
+}
+
+// getFailoverLaunchCandidates combines the instance types compatible in the
+// availability zone of each of the failover subnets with those subnets,
+// sorted ascending by their price in that availability zone.
+func (i *instance) getFailoverLaunchCandidates(ctx context.Context, allowedList []string,
+	disallowedList []string) []spotLaunchCandidate {
+	var candidates []spotLaunchCandidate
+
+	subnets := i.getFailoverSubnets(ctx)
+	subnetIDs := make([]string, 0, len(subnets))
+	for id := range subnets {
+		subnetIDs = append(subnetIDs, id)
+	}
+	sort.Strings(subnetIDs)
+
+	for _, id := range subnetIDs {
+		az := subnets[id]
+		instanceTypes, err := i.getCompatibleSpotInstanceTypesInAZ(az, allowedList, disallowedList)
+		if err != nil {
+			debug.Println(i.asg.name, "Not failing over to subnet", id, "in", az, err.Error())
+			continue
+		}
+
+		for _, instanceType := range instanceTypes {
+			candidates = append(candidates, spotLaunchCandidate{
+				instanceType: instanceType,
+				subnetID:     aws.String(id),
+				az:           az,
+				price:        i.calculatePriceInAZ(instanceType, az),
+			})
+		}
+	}
+
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return candidates[a].price < candidates[b].price
+	})
+	return candidates
+}
+
+// useSubnet updates the RunInstances input generated for the current instance
+// so the spot instance is launched in a different subnet.
+func (i *instance) useSubnet(input *ec2.RunInstancesInput, subnetID *string, az string) {
+	placement := ec2.Placement{}
+	if i.Placement != nil {
+		placement = *i.Placement
+	}
+	placement.AvailabilityZone = aws.String(az)
+	input.Placement = &placement
+
+	if input.SubnetId != nil {
+		input.SubnetId = subnetID
+	}
+	for _, ni := range input.NetworkInterfaces {
+		if ni.SubnetId != nil {
+			ni.SubnetId = subnetID
+		}
+	}
+}
+
+// launchSpotReplacement launches the cheapest compatible spot instance in the
+// subnet of the current instance, and only when that fails for lack of
+// capacity, in the other subnets of the group if subnet failover is enabled.
+// It returns the launched spot instance.
+func (i *instance) launchSpotReplacement(ctx context.Context) (*ec2.Instance, error) {
+	allowedList := i.asg.getAllowedInstanceTypes(i)
+	disallowedList := i.asg.getDisallowedInstanceTypes(i)
+
 	instanceTypes, err := i.getCompatibleSpotInstanceTypesListSortedAscendingByPrice(
-		i.asg.getAllowedInstanceTypes(i),
-		i.asg.getDisallowedInstanceTypes(i))
+		allowedList, disallowedList)
 
 	if err != nil {
 		logger.Println("Couldn't determine the cheapest compatible spot instance type")
-		return err
+		return nil, err
 	}
 
-	//Go through all compatible instances until one type launches or we are out of options.
-	for _, instanceType := range instanceTypes {
-		az := *i.Placement.AvailabilityZone
+	spotInst, noCapacity, err := i.launchSpotCandidates(ctx, i.getSpotLaunchCandidates(instanceTypes))
+	if err == nil {
+		return spotInst, nil
+	}
+
+	if noCapacity {
+		if candidates := i.getFailoverLaunchCandidates(ctx, allowedList, disallowedList); len(candidates) > 0 {
+			logger.Println(i.asg.name, "No spot capacity in subnet", aws.StringValue(i.SubnetId),
+				"failing over to the other subnets of the group")
+			if spotInst, _, err = i.launchSpotCandidates(ctx, candidates); err == nil {
+				return spotInst, nil
+			}
+		}
+	}
+
+	logger.Println(i.asg.name, "Exhausted all compatible instance types and subnets without launch success. Aborting.")
+	return nil, err
+}
+
+// launchSpotCandidates goes through the launch candidates until one of them
+// launches, returning the launched spot instance, or the last error if none
+// did and whether any of them failed for lack of capacity.
+func (i *instance) launchSpotCandidates(ctx context.Context, candidates []spotLaunchCandidate) (*ec2.Instance, bool, error) {
+	var err error
+	var noCapacity bool
+
+	for _, candidate := range candidates {
+		instanceType, az := candidate.instanceType, candidate.az
 		bidPrice := i.getPricetoBid(i.price,
 			instanceType.pricing.spot[az], instanceType.pricing.premium)
 
-		runInstancesInput := i.createRunInstancesInput(instanceType.instanceType, bidPrice)
-		logger.Println(az, i.asg.name, "Launching spot instance of type", instanceType.instanceType, "with bid price", bidPrice)
-		logger.Println(az, i.asg.name)
-		resp, err := i.region.services.ec2.RunInstances(runInstancesInput)
+		runInstancesInput := i.createRunInstancesInput(ctx, instanceType.instanceType, bidPrice)
+		if aws.StringValue(candidate.subnetID) != aws.StringValue(i.SubnetId) {
+			i.useSubnet(runInstancesInput, candidate.subnetID, az)
+		}
+
+		logger.Println(az, i.asg.name, "Launching spot instance of type", instanceType.instanceType,
+			"in subnet", aws.StringValue(candidate.subnetID), "with bid price", bidPrice)
+		var resp *ec2.Reservation
+		resp, err = i.region.services.ec2.RunInstancesWithContext(ctx, runInstancesInput)
 
 		if err != nil {
 			if strings.Contains(err.Error(), "InsufficientInstanceCapacity") {
-				logger.Println("Couldn't launch spot instance due to lack of capacity, trying next instance type:", err.Error())
+				noCapacity = true
+				logger.Println("Couldn't launch spot instance due to lack of capacity, trying next option:", err.Error())
 			} else {
-				logger.Println("Couldn't launch spot instance:", err.Error(), "trying next instance type")
+				logger.Println("Couldn't launch spot instance:", err.Error(), "trying next option")
 				debug.Println(runInstancesInput)
 			}
-		} else {
-			spotInst := resp.Instances[0]
-			logger.Println(i.asg.name, "Successfully launched spot instance", *spotInst.InstanceId,
-				"of type", *spotInst.InstanceType,
-				"with bid price", bidPrice,
-				"current spot price", instanceType.pricing.spot[az])
-
-			debug.Println("RunInstances response:", spew.Sdump(resp))
-			return nil
+			continue
 		}
-	}
 
-	logger.Println(i.asg.name, "Exhausted all compatible instance types without launch success. Aborting.")
-	return err
+		spotInst := resp.Instances[0]
+		logger.Println(i.asg.name, "Successfully launched spot instance", *spotInst.InstanceId,
+			"of type", *spotInst.InstanceType,
+			"in", az,
+			"with bid price", bidPrice,
+			"current spot price", instanceType.pricing.spot[az])
+
+		debug.Println("RunInstances response:", spew.Sdump(resp))
+		return spotInst, false, nil
+	}
+	return nil, noCapacity, err
 }
 
 func (i *instance) getPricetoBid(
@@ -462,6 +819,13 @@ func (i *instance) getPricetoBid(
 
 	debug.Println("BiddingPolicy: ", i.region.conf.BiddingPolicy)
 
+	if i.asg != nil && i.asg.config.SpotPriceCeiling > 0 &&
+		i.asg.config.SpotPriceCeiling < baseOnDemandPrice {
+		logger.Println("Limiting the bid price to the spot price ceiling of",
+			i.asg.config.SpotPriceCeiling, "to replace instance", i.InstanceId)
+		baseOnDemandPrice = i.asg.config.SpotPriceCeiling
+	}
+
 	if i.region.conf.BiddingPolicy == DefaultBiddingPolicy {
 		logger.Println("Bidding base on demand price", baseOnDemandPrice, "to replace instance", i.InstanceId)
 		return baseOnDemandPrice
@@ -539,14 +903,14 @@ func (i *instance) launchTemplateHasNetworkInterfaces(id, ver *string) (bool, []
 	return false, nil
 }
 
-func (i *instance) createRunInstancesInput(instanceType string, price float64) *ec2.RunInstancesInput {
+func (i *instance) createRunInstancesInput(ctx context.Context, instanceType string, price float64) *ec2.RunInstancesInput {
 	var retval ec2.RunInstancesInput
 
 	// information we must (or can safely) copy/convert from the currently running
 	// on-demand instance or we had to compute in order to place the spot bid
 	retval = ec2.RunInstancesInput{
 
-		EbsOptimized: i.EbsOptimized,
+		EbsOptimized: i.ebsOptimizedFor(instanceType),
 
 		InstanceMarketOptions: &ec2.InstanceMarketOptionsRequest{
 			MarketType: aws.String("spot"),
@@ -567,6 +931,10 @@ func (i *instance) createRunInstancesInput(instanceType string, price float64) *
 		TagSpecifications: i.generateTagsList(),
 	}
 
+	if cs := i.creditSpecification(ctx, instanceType); cs != nil {
+		retval.CreditSpecification = cs
+	}
+
 	if i.asg.LaunchTemplate != nil {
 		ver := i.asg.LaunchTemplate.Version
 		id := i.asg.LaunchTemplate.LaunchTemplateId
@@ -657,6 +1025,10 @@ func (i *instance) generateTagsList() []*ec2.TagSpecification {
 				Key:   aws.String("launched-for-asg"),
 				Value: aws.String(i.asg.name),
 			},
+			{
+				Key:   aws.String(launchedForInstanceTag),
+				Value: i.InstanceId,
+			},
 		},
 	}
 
@@ -680,6 +1052,7 @@ func (i *instance) generateTagsList() []*ec2.TagSpecification {
 		if !strings.HasPrefix(*tag.Key, "aws:") &&
 			*tag.Key != "launched-by-autospotting" &&
 			*tag.Key != "launched-for-asg" &&
+			*tag.Key != launchedForInstanceTag &&
 			*tag.Key != "LaunchTemplateID" &&
 			*tag.Key != "LaunchTemplateVersion" &&
 			*tag.Key != "LaunchConfiguationName" {
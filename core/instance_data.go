@@ -0,0 +1,227 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ec2instancesinfo "github.com/vkhodor/ec2-instances-info"
+)
+
+const (
+	// BundledInstanceDataSource uses the instance data snapshot compiled into
+	// the AutoSpotting binary.
+	BundledInstanceDataSource = "bundled"
+
+	// DefaultInstanceDataCacheTTL is how long the instance data downloaded from
+	// an URL is reused before being downloaded again.
+	DefaultInstanceDataCacheTTL = 24 * time.Hour
+
+	instanceDataCacheFile   = "autospotting-instance-data.json"
+	instanceDataHTTPTimeout = 30 * time.Second
+)
+
+// InstanceDataProvider loads the pricing and hardware specs of the EC2
+// instance types, in the format used by ec2instances.info.
+type InstanceDataProvider interface {
+	Load() (*ec2instancesinfo.InstanceData, error)
+}
+
+// newInstanceDataProvider creates the provider for the configured source,
+// which can be "bundled", a local file path or an HTTP(S) URL.
+func newInstanceDataProvider(source string, ttl time.Duration) InstanceDataProvider {
+	switch {
+	case source == "" || source == BundledInstanceDataSource:
+		return bundledInstanceData{}
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		return &urlInstanceData{
+			url:       source,
+			ttl:       ttl,
+			cachePath: filepath.Join(os.TempDir(), instanceDataCacheFile),
+			client:    &http.Client{Timeout: instanceDataHTTPTimeout},
+		}
+	default:
+		return fileInstanceData{path: strings.TrimPrefix(source, "file://")}
+	}
+}
+
+// parseInstanceData unmarshals the ec2instances.info JSON data, handling the
+// fields which may have different types, just like the bundled data loader.
+func parseInstanceData(raw []byte) (*ec2instancesinfo.InstanceData, error) {
+	var d ec2instancesinfo.InstanceData
+
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return nil, fmt.Errorf("couldn't parse the instance data: %s", err.Error())
+	}
+
+	if len(d) == 0 {
+		return nil, fmt.Errorf("the instance data contains no instance types")
+	}
+
+	// The vCPU field is "N/A" for some of the metal instance types, and the ECU
+	// can be either a number or the string "variable"
+	for i := range d {
+		var vcpu, intECU int
+		var stringECU string
+		if err := json.Unmarshal(d[i].VCPURaw, &vcpu); err == nil {
+			d[i].VCPU = vcpu
+		}
+		if err := json.Unmarshal(d[i].ECURaw, &intECU); err == nil {
+			d[i].ECU = strconv.Itoa(intECU)
+		} else if err := json.Unmarshal(d[i].ECURaw, &stringECU); err == nil {
+			d[i].ECU = stringECU
+		}
+	}
+	return &d, nil
+}
+
+// bundledInstanceData uses the data compiled into the binary.
+type bundledInstanceData struct{}
+
+func (bundledInstanceData) Load() (*ec2instancesinfo.InstanceData, error) {
+	return ec2instancesinfo.Data()
+}
+
+// fileInstanceData reads the data from a local JSON file on every load, so it
+// can be refreshed without recompiling or restarting AutoSpotting.
+type fileInstanceData struct {
+	path string
+}
+
+func (f fileInstanceData) Load() (*ec2instancesinfo.InstanceData, error) {
+	raw, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	return parseInstanceData(raw)
+}
+
+// urlInstanceData downloads the data from an URL, caching it both in memory
+// and on disk for the configured time. When the download fails the previously
+// cached data is used, even if it expired. It logs using the standard logger
+// because it's also used while parsing the configuration, before our loggers
+// are set up.
+type urlInstanceData struct {
+	url       string
+	ttl       time.Duration
+	cachePath string
+	client    *http.Client
+
+	mu        sync.Mutex
+	data      *ec2instancesinfo.InstanceData
+	fetchedAt time.Time
+}
+
+func (u *urlInstanceData) Load() (*ec2instancesinfo.InstanceData, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.data != nil && time.Since(u.fetchedAt) < u.ttl {
+		return u.data, nil
+	}
+
+	if u.data == nil {
+		if data, fetchedAt, err := u.readCache(); err == nil && time.Since(fetchedAt) < u.ttl {
+			u.data, u.fetchedAt = data, fetchedAt
+			return u.data, nil
+		}
+	}
+
+	data, err := u.download()
+	if err == nil {
+		u.data, u.fetchedAt = data, time.Now()
+		return u.data, nil
+	}
+
+	if u.data != nil {
+		log.Println("Failed to refresh the instance data, using the cached data:", err.Error())
+		return u.data, nil
+	}
+
+	if data, fetchedAt, cacheErr := u.readCache(); cacheErr == nil {
+		log.Println("Failed to download the instance data, using the cached data:", err.Error())
+		u.data, u.fetchedAt = data, fetchedAt
+		return u.data, nil
+	}
+	return nil, err
+}
+
+func (u *urlInstanceData) download() (*ec2instancesinfo.InstanceData, error) {
+	resp, err := u.client.Get(u.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("couldn't download the instance data from %s: %s", u.url, resp.Status)
+	}
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := parseInstanceData(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(u.cachePath, raw, 0600); err != nil {
+		log.Println("Couldn't cache the instance data in", u.cachePath, err.Error())
+	}
+	return data, nil
+}
+
+func (u *urlInstanceData) readCache() (*ec2instancesinfo.InstanceData, time.Time, error) {
+	info, err := os.Stat(u.cachePath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	raw, err := ioutil.ReadFile(u.cachePath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, err := parseInstanceData(raw)
+	return data, info.ModTime(), err
+}
+
+// refreshInstanceData reloads the instance data from the configured provider
+// before each run, keeping the previously loaded data in case of failures. The
+// bundled data never changes, so it's only loaded once.
+func refreshInstanceData(cfg *Config) error {
+	if _, bundled := cfg.InstanceDataProvider.(bundledInstanceData); cfg.InstanceData != nil &&
+		(cfg.InstanceDataProvider == nil || bundled) {
+		return nil
+	}
+
+	if cfg.InstanceDataProvider == nil {
+		cfg.InstanceDataProvider = bundledInstanceData{}
+	}
+
+	data, err := cfg.InstanceDataProvider.Load()
+	if err == nil {
+		cfg.InstanceData = data
+		return nil
+	}
+
+	if cfg.InstanceData == nil {
+		return err
+	}
+
+	logger.Println("Failed to load the instance data, using the previously loaded data:", err.Error())
+	return nil
+}
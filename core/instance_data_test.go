@@ -0,0 +1,230 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	ec2instancesinfo "github.com/vkhodor/ec2-instances-info"
+)
+
+const testInstanceDataJSON = `[
+	{
+		"instance_type": "m5.large",
+		"vCPU": 2,
+		"ECU": "variable",
+		"memory": 8,
+		"pricing": {"us-east-1": {"linux": {"ondemand": "0.096"}}}
+	},
+	{
+		"instance_type": "i3.metal",
+		"vCPU": "N/A",
+		"ECU": 208,
+		"memory": 512
+	}
+]`
+
+type fakeInstanceDataProvider struct {
+	data *ec2instancesinfo.InstanceData
+	err  error
+}
+
+func (f fakeInstanceDataProvider) Load() (*ec2instancesinfo.InstanceData, error) {
+	return f.data, f.err
+}
+
+func Test_newInstanceDataProvider(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected interface{}
+	}{
+		{source: "", expected: bundledInstanceData{}},
+		{source: "bundled", expected: bundledInstanceData{}},
+		{source: "/tmp/instances.json", expected: fileInstanceData{path: "/tmp/instances.json"}},
+		{source: "file:///tmp/instances.json", expected: fileInstanceData{path: "/tmp/instances.json"}},
+		{source: "https://example.com/instances.json", expected: &urlInstanceData{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			got := newInstanceDataProvider(tt.source, time.Hour)
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.expected) {
+				t.Errorf("newInstanceDataProvider() = %T, expected %T", got, tt.expected)
+			}
+			if f, ok := got.(fileInstanceData); ok && f != tt.expected {
+				t.Errorf("newInstanceDataProvider() = %v, expected %v", f, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_parseInstanceData(t *testing.T) {
+	data, err := parseInstanceData([]byte(testInstanceDataJSON))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if len(*data) != 2 {
+		t.Fatalf("expected 2 instance types, got %d", len(*data))
+	}
+
+	m5, metal := (*data)[0], (*data)[1]
+	if m5.VCPU != 2 || m5.ECU != "variable" || m5.Pricing["us-east-1"].Linux.OnDemand != 0.096 {
+		t.Errorf("unexpected m5.large data %+v", m5)
+	}
+	if metal.VCPU != 0 || metal.ECU != "208" {
+		t.Errorf("unexpected i3.metal data %+v", metal)
+	}
+
+	for _, invalid := range []string{`[]`, `{"instance_type": "m5.large"}`, `not json`} {
+		if _, err := parseInstanceData([]byte(invalid)); err == nil {
+			t.Errorf("expected an error parsing %q", invalid)
+		}
+	}
+}
+
+func Test_fileInstanceData_Load(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autospotting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "instances.json")
+	ioutil.WriteFile(path, []byte(testInstanceDataJSON), 0600)
+
+	data, err := fileInstanceData{path: path}.Load()
+	if err != nil || len(*data) != 2 {
+		t.Errorf("Load() = %v, %v, expected 2 instance types", data, err)
+	}
+
+	if _, err := (fileInstanceData{path: filepath.Join(dir, "missing.json")}).Load(); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func Test_urlInstanceData_Load(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autospotting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	requests, fail := 0, false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(testInstanceDataJSON))
+	}))
+	defer server.Close()
+
+	newProvider := func(ttl time.Duration) *urlInstanceData {
+		return &urlInstanceData{
+			url:       server.URL,
+			ttl:       ttl,
+			cachePath: filepath.Join(dir, instanceDataCacheFile),
+			client:    server.Client(),
+		}
+	}
+
+	u := newProvider(time.Hour)
+	if data, err := u.Load(); err != nil || len(*data) != 2 {
+		t.Fatalf("Load() = %v, %v, expected 2 instance types", data, err)
+	}
+	if _, err := u.Load(); err != nil || requests != 1 {
+		t.Errorf("expected the data to be served from memory, got %d requests", requests)
+	}
+
+	// a new provider, such as a new Lambda container, uses the disk cache
+	if _, err := newProvider(time.Hour).Load(); err != nil || requests != 1 {
+		t.Errorf("expected the data to be served from disk, got %d requests", requests)
+	}
+
+	// expired caches are refreshed, but still used when the download fails
+	fail = true
+	expired := newProvider(0)
+	if data, err := expired.Load(); err != nil || len(*data) != 2 || requests != 2 {
+		t.Errorf("expected the expired cache to be used, got %v, %v after %d requests",
+			data, err, requests)
+	}
+
+	os.Remove(filepath.Join(dir, instanceDataCacheFile))
+	if _, err := newProvider(0).Load(); err == nil {
+		t.Errorf("expected an error without any cached data")
+	}
+}
+
+func Test_refreshInstanceData(t *testing.T) {
+	previous := &ec2instancesinfo.InstanceData{}
+	fixture := &ec2instancesinfo.InstanceData{{InstanceType: "m5.large"}}
+
+	tests := []struct {
+		name     string
+		cfg      *Config
+		expected *ec2instancesinfo.InstanceData
+		wantErr  bool
+	}{
+		{
+			name: "data injected without a provider",
+			cfg: &Config{
+				InstanceData: previous,
+			},
+			expected: previous,
+		},
+		{
+			name: "bundled data loaded once",
+			cfg: &Config{
+				InstanceData:         previous,
+				InstanceDataProvider: bundledInstanceData{},
+			},
+			expected: previous,
+		},
+		{
+			name: "refreshed from the provider",
+			cfg: &Config{
+				InstanceData:         previous,
+				InstanceDataProvider: fakeInstanceDataProvider{data: fixture},
+			},
+			expected: fixture,
+		},
+		{
+			name: "failure keeps the previous data",
+			cfg: &Config{
+				InstanceData:         previous,
+				InstanceDataProvider: fakeInstanceDataProvider{err: errors.New("unavailable")},
+			},
+			expected: previous,
+		},
+		{
+			name: "failure without previous data",
+			cfg: &Config{
+				InstanceDataProvider: fakeInstanceDataProvider{err: errors.New("unavailable")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := refreshInstanceData(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("refreshInstanceData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.cfg.InstanceData != tt.expected {
+				t.Errorf("refreshInstanceData() loaded %v, expected %v", tt.cfg.InstanceData, tt.expected)
+			}
+		})
+	}
+}
@@ -4,8 +4,10 @@
 package autospotting
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"math"
 	"reflect"
@@ -1233,7 +1235,7 @@ func TestTerminate(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		ret := tt.inst.terminate()
+		ret := tt.inst.terminate(context.Background())
 		if ret != nil && ret.Error() != tt.expected.Error() {
 			t.Errorf("error actual: %s, expected: %s", ret.Error(), tt.expected.Error())
 		}
@@ -1296,6 +1298,10 @@ func TestGenerateTagList(t *testing.T) {
 							Key:   aws.String("launched-for-asg"),
 							Value: aws.String("myASG"),
 						},
+						{
+							Key:   aws.String("launched-for-instance"),
+							Value: aws.String("i-ondemand"),
+						},
 					},
 				},
 			},
@@ -1329,6 +1335,10 @@ func TestGenerateTagList(t *testing.T) {
 							Key:   aws.String("launched-for-asg"),
 							Value: aws.String("myASG"),
 						},
+						{
+							Key:   aws.String("launched-for-instance"),
+							Value: aws.String("i-ondemand"),
+						},
 						{
 							Key:   aws.String("foo"),
 							Value: aws.String("bar"),
@@ -1348,7 +1358,8 @@ func TestGenerateTagList(t *testing.T) {
 
 			i := instance{
 				Instance: &ec2.Instance{
-					Tags: tt.instanceTags,
+					InstanceId: aws.String("i-ondemand"),
+					Tags:       tt.instanceTags,
 				},
 				asg: &autoScalingGroup{
 					name: tt.ASGName,
@@ -1592,6 +1603,8 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 					},
 				},
 				Instance: &ec2.Instance{
+					InstanceId: aws.String("i-ondemand"),
+
 					EbsOptimized: aws.Bool(true),
 
 					IamInstanceProfile: &ec2.IamInstanceProfile{
@@ -1672,6 +1685,10 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 							Key:   aws.String("launched-for-asg"),
 							Value: aws.String("mygroup"),
 						},
+						{
+							Key:   aws.String("launched-for-instance"),
+							Value: aws.String("i-ondemand"),
+						},
 					},
 				},
 				},
@@ -1710,6 +1727,8 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 					},
 				},
 				Instance: &ec2.Instance{
+					InstanceId: aws.String("i-ondemand"),
+
 					EbsOptimized: aws.Bool(true),
 
 					IamInstanceProfile: &ec2.IamInstanceProfile{
@@ -1791,6 +1810,10 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 							Key:   aws.String("launched-for-asg"),
 							Value: aws.String("mygroup"),
 						},
+						{
+							Key:   aws.String("launched-for-instance"),
+							Value: aws.String("i-ondemand"),
+						},
 					},
 				},
 				},
@@ -1817,6 +1840,8 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 					},
 				},
 				Instance: &ec2.Instance{
+					InstanceId: aws.String("i-ondemand"),
+
 					EbsOptimized: aws.Bool(true),
 
 					IamInstanceProfile: &ec2.IamInstanceProfile{
@@ -1895,6 +1920,10 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 							Key:   aws.String("launched-for-asg"),
 							Value: aws.String("mygroup"),
 						},
+						{
+							Key:   aws.String("launched-for-instance"),
+							Value: aws.String("i-ondemand"),
+						},
 					},
 				},
 				},
@@ -1929,6 +1958,8 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 					},
 				},
 				Instance: &ec2.Instance{
+					InstanceId: aws.String("i-ondemand"),
+
 					EbsOptimized: aws.Bool(true),
 
 					IamInstanceProfile: &ec2.IamInstanceProfile{
@@ -2022,6 +2053,10 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 							Key:   aws.String("launched-for-asg"),
 							Value: aws.String("mygroup"),
 						},
+						{
+							Key:   aws.String("launched-for-instance"),
+							Value: aws.String("i-ondemand"),
+						},
 					},
 				},
 				},
@@ -2058,6 +2093,8 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 					},
 				},
 				Instance: &ec2.Instance{
+					InstanceId: aws.String("i-ondemand"),
+
 					EbsOptimized: aws.Bool(true),
 
 					IamInstanceProfile: &ec2.IamInstanceProfile{
@@ -2151,6 +2188,10 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 							Key:   aws.String("launched-for-asg"),
 							Value: aws.String("mygroup"),
 						},
+						{
+							Key:   aws.String("launched-for-instance"),
+							Value: aws.String("i-ondemand"),
+						},
 					},
 				},
 				},
@@ -2161,7 +2202,7 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			got := tt.inst.createRunInstancesInput(tt.args.instanceType, tt.args.price)
+			got := tt.inst.createRunInstancesInput(context.Background(), tt.args.instanceType, tt.args.price)
 
 			// make sure the lists of tags are sorted, otherwise the comparison fails
 			sort.Slice(got.TagSpecifications[0].Tags, func(i, j int) bool {
@@ -2417,3 +2458,326 @@ func Test_instance_isSameArch(t *testing.T) {
 		})
 	}
 }
+
+func Test_instance_getSpotLaunchCandidates(t *testing.T) {
+	cheap := instanceTypeInformation{
+		instanceType: "m5.large",
+		pricing:      prices{spot: spotPriceMap{"1a": 0.01, "1b": 0.02}},
+	}
+	pricey := instanceTypeInformation{
+		instanceType: "c5.large",
+		pricing:      prices{spot: spotPriceMap{"1a": 0.03, "1b": 0.01}},
+	}
+
+	i := &instance{
+		Instance: &ec2.Instance{
+			InstanceId: aws.String("od-a"),
+			SubnetId:   aws.String("subnet-a"),
+			Placement:  &ec2.Placement{AvailabilityZone: aws.String("1a")},
+		},
+		asg: &autoScalingGroup{
+			name:   "test-asg",
+			Group:  &autoscaling.Group{VPCZoneIdentifier: aws.String("subnet-a,subnet-b")},
+			config: AutoScalingConfig{SubnetFailover: "true"},
+		},
+	}
+
+	var got []string
+	for _, c := range i.getSpotLaunchCandidates([]instanceTypeInformation{cheap, pricey}) {
+		got = append(got, fmt.Sprintf("%s/%s/%v", c.instanceType.instanceType, *c.subnetID, c.price))
+	}
+	want := []string{"m5.large/subnet-a/0.01", "c5.large/subnet-a/0.03"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("instance.getSpotLaunchCandidates() = %v, want %v", got, want)
+	}
+}
+
+func Test_instance_getFailoverLaunchCandidates(t *testing.T) {
+	spotInfo := func(instanceType string, spot spotPriceMap) instanceTypeInformation {
+		return instanceTypeInformation{
+			instanceType:      instanceType,
+			vCPU:              2,
+			memory:            8,
+			PhysicalProcessor: "Intel",
+			pricing:           prices{spot: spot},
+		}
+	}
+
+	subnets := &ec2.DescribeSubnetsOutput{
+		Subnets: []*ec2.Subnet{
+			{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("1b")},
+			{SubnetId: aws.String("subnet-c"), AvailabilityZone: aws.String("1c")},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		subnetFailover string
+		ec2            mockEC2
+		want           []string
+	}{
+		{
+			name:           "subnet failover disabled",
+			subnetFailover: "false",
+			ec2:            mockEC2{dso: subnets},
+		},
+		{
+			name:           "subnet failover failed to describe subnets",
+			subnetFailover: "true",
+			ec2:            mockEC2{dserr: errors.New("describe-subnets")},
+		},
+		{
+			name:           "subnet failover enabled",
+			subnetFailover: "true",
+			ec2:            mockEC2{dso: subnets},
+			want:           []string{"m5.large/subnet-b", "c5.large/subnet-b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					InstanceId:         aws.String("od-a"),
+					SubnetId:           aws.String("subnet-a"),
+					Placement:          &ec2.Placement{AvailabilityZone: aws.String("1a")},
+					VirtualizationType: aws.String("hvm"),
+				},
+				typeInfo: spotInfo("m5.large", nil),
+				price:    0.1,
+				region: &region{
+					services: connections{ec2: tt.ec2},
+					instanceTypeInformation: map[string]instanceTypeInformation{
+						// c5.large is too expensive in the availability zone of the
+						// instance, but not in the failover one
+						"m5.large": spotInfo("m5.large", spotPriceMap{"1a": 0.05, "1b": 0.02, "1c": 0.01}),
+						"c5.large": spotInfo("c5.large", spotPriceMap{"1a": 0.3, "1b": 0.03, "1c": 0.01}),
+					},
+				},
+			}
+			i.asg = &autoScalingGroup{
+				name: "test-asg",
+				Group: &autoscaling.Group{
+					VPCZoneIdentifier: aws.String("subnet-a,subnet-b,subnet-c"),
+				},
+				config: AutoScalingConfig{SubnetFailover: tt.subnetFailover},
+				instances: makeInstancesWithCatalog(instanceMap{
+					"od-a":    testAZInstance("od-a", "1a", "", ec2.InstanceStateNameRunning),
+					"od-b":    testAZInstance("od-b", "1b", "", ec2.InstanceStateNameRunning),
+					"spot-c1": testAZInstance("spot-c1", "1c", "spot", ec2.InstanceStateNameRunning),
+					"spot-c2": testAZInstance("spot-c2", "1c", "spot", ec2.InstanceStateNameRunning),
+				}),
+			}
+
+			var got []string
+			for _, c := range i.getFailoverLaunchCandidates(context.Background(), nil, nil) {
+				got = append(got, c.instanceType.instanceType+"/"+*c.subnetID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("instance.getFailoverLaunchCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_launchSpotCandidates(t *testing.T) {
+	candidates := []spotLaunchCandidate{
+		{instanceType: instanceTypeInformation{instanceType: "m5.large"}, subnetID: aws.String("subnet-a"), az: "1a"},
+		{instanceType: instanceTypeInformation{instanceType: "c5.large"}, subnetID: aws.String("subnet-a"), az: "1a"},
+	}
+
+	tests := []struct {
+		name           string
+		rierr          error
+		wantNoCapacity bool
+		wantErr        bool
+		wantLaunches   int
+	}{
+		{
+			name:         "launched",
+			wantLaunches: 1,
+		},
+		{
+			name:           "no capacity",
+			rierr:          errors.New("InsufficientInstanceCapacity: no capacity"),
+			wantNoCapacity: true,
+			wantErr:        true,
+			wantLaunches:   2,
+		},
+		{
+			name:         "other error",
+			rierr:        errors.New("InvalidParameterValue"),
+			wantErr:      true,
+			wantLaunches: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inputs []*ec2.RunInstancesInput
+			i := &instance{
+				Instance: &ec2.Instance{
+					InstanceId: aws.String("od-a"),
+					SubnetId:   aws.String("subnet-a"),
+					Placement:  &ec2.Placement{AvailabilityZone: aws.String("1a")},
+				},
+				region: &region{
+					conf: &Config{},
+					services: connections{ec2: mockEC2{
+						rio: &ec2.Reservation{Instances: []*ec2.Instance{{
+							InstanceId:   aws.String("spot-a"),
+							InstanceType: aws.String("m5.large"),
+						}}},
+						rierr:    tt.rierr,
+						riInputs: &inputs,
+					}},
+				},
+				asg: &autoScalingGroup{
+					name:  "test-asg",
+					Group: &autoscaling.Group{},
+				},
+			}
+
+			spotInst, noCapacity, err := i.launchSpotCandidates(context.Background(), candidates)
+			if noCapacity != tt.wantNoCapacity || (err != nil) != tt.wantErr {
+				t.Errorf("instance.launchSpotCandidates() = %v, %v, want %v, error %v",
+					noCapacity, err, tt.wantNoCapacity, tt.wantErr)
+			}
+			if (spotInst != nil) == tt.wantErr {
+				t.Errorf("instance.launchSpotCandidates() returned the spot instance %v, error %v",
+					spotInst, err)
+			}
+			if len(inputs) != tt.wantLaunches {
+				t.Errorf("instance.launchSpotCandidates() launched %d times, want %d",
+					len(inputs), tt.wantLaunches)
+			}
+		})
+	}
+}
+
+func Test_instance_useSubnet(t *testing.T) {
+	i := &instance{
+		Instance: &ec2.Instance{
+			SubnetId: aws.String("subnet-a"),
+			Placement: &ec2.Placement{
+				AvailabilityZone: aws.String("1a"),
+				Tenancy:          aws.String("default"),
+			},
+		},
+	}
+	input := &ec2.RunInstancesInput{
+		Placement: i.Placement,
+		NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{
+			{SubnetId: aws.String("subnet-a")},
+		},
+	}
+
+	i.useSubnet(input, aws.String("subnet-b"), "1b")
+
+	if *input.Placement.AvailabilityZone != "1b" || *input.Placement.Tenancy != "default" {
+		t.Errorf("unexpected placement %v", input.Placement)
+	}
+	if *i.Placement.AvailabilityZone != "1a" {
+		t.Errorf("the placement of the original instance was changed")
+	}
+	if input.SubnetId != nil || *input.NetworkInterfaces[0].SubnetId != "subnet-b" {
+		t.Errorf("unexpected subnets %v %v", input.SubnetId, input.NetworkInterfaces)
+	}
+}
+
+func Test_instance_priceIncompatibilityReason(t *testing.T) {
+	tests := []struct {
+		name      string
+		spotPrice float64
+		config    AutoScalingConfig
+		want      string
+	}{
+		{
+			name:      "unavailable",
+			spotPrice: 0,
+			want:      "unavailable in this Availability Zone",
+		},
+		{
+			name:      "more expensive than on-demand",
+			spotPrice: 1.5,
+			want:      "more expensive than the on-demand price",
+		},
+		{
+			name:      "no limits",
+			spotPrice: 0.95,
+			want:      "",
+		},
+		{
+			name:      "above the ceiling",
+			spotPrice: 0.5,
+			config:    AutoScalingConfig{SpotPriceCeiling: 0.4},
+			want:      "above the spot price ceiling of 0.4",
+		},
+		{
+			name:      "below the ceiling",
+			spotPrice: 0.3,
+			config:    AutoScalingConfig{SpotPriceCeiling: 0.4},
+			want:      "",
+		},
+		{
+			name:      "insufficient savings",
+			spotPrice: 0.8,
+			config:    AutoScalingConfig{MinSavingsPercentage: 30},
+			want:      "saving less than the minimum of 30%",
+		},
+		{
+			name:      "sufficient savings",
+			spotPrice: 0.6,
+			config:    AutoScalingConfig{MinSavingsPercentage: 30},
+			want:      "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				price: 1.0,
+				asg:   &autoScalingGroup{config: tt.config},
+			}
+			if got := i.priceIncompatibilityReason(tt.spotPrice); got != tt.want {
+				t.Errorf("instance.priceIncompatibilityReason() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_getPricetoBidWithCeiling(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		ceiling float64
+		want    float64
+	}{
+		{name: "normal without ceiling", policy: "normal", want: 0.0464},
+		{name: "normal with ceiling", policy: "normal", ceiling: 0.03, want: 0.03},
+		{name: "aggressive with ceiling", policy: "aggressive", ceiling: 0.03, want: 0.03},
+		{name: "aggressive below ceiling", policy: "aggressive", ceiling: 0.04, want: 0.0324},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{
+							SpotPriceBufferPercentage: 50.0,
+							BiddingPolicy:             tt.policy,
+						},
+					},
+				},
+				asg: &autoScalingGroup{
+					config: AutoScalingConfig{SpotPriceCeiling: tt.ceiling},
+				},
+				Instance: &ec2.Instance{InstanceId: aws.String("i-0000000")},
+			}
+			if got := i.getPricetoBid(0.0464, 0.0216, 0); math.Abs(got-tt.want) > 0.000001 {
+				t.Errorf("instance.getPricetoBid() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
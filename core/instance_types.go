@@ -0,0 +1,151 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The virtualization types as named in the static instance data
+var virtualizationTypeNames = map[string]string{
+	ec2.VirtualizationTypeHvm:         "HVM",
+	ec2.VirtualizationTypeParavirtual: "PV",
+}
+
+// describeInstanceTypes fetches the hardware specs of all the instance types
+// available in the region, indexed by the instance type name.
+func (r *region) describeInstanceTypes(ctx context.Context) (map[string]*ec2.InstanceTypeInfo, error) {
+	specs := make(map[string]*ec2.InstanceTypeInfo)
+
+	err := r.services.ec2.DescribeInstanceTypesPagesWithContext(ctx,
+		&ec2.DescribeInstanceTypesInput{},
+		func(page *ec2.DescribeInstanceTypesOutput, lastPage bool) bool {
+			for _, spec := range page.InstanceTypes {
+				if spec != nil && spec.InstanceType != nil {
+					specs[*spec.InstanceType] = spec
+				}
+			}
+			return true
+		})
+
+	if err != nil {
+		return nil, err
+	}
+	return specs, nil
+}
+
+// mergeInstanceTypeSpecs overrides the hardware specs from the static data with
+// the ones reported by the EC2 API, which are authoritative and also available
+// for the instance types missing from the static data. The static data is kept
+// as it is if the API call fails.
+func (r *region) mergeInstanceTypeSpecs(ctx context.Context) {
+	specs, err := r.describeInstanceTypes(ctx)
+	if err != nil {
+		logger.Println(r.name, "Failed to describe the instance types, using the static data:", err.Error())
+		return
+	}
+
+	for name, spec := range specs {
+		info, ok := r.instanceTypeInformation[name]
+		if !ok {
+			// we can't compare the prices of the types without pricing data
+			debug.Println(r.name, "Skipping instance type", name, "missing from the pricing data")
+			continue
+		}
+		info.mergeSpecs(spec)
+		r.instanceTypeInformation[name] = info
+	}
+}
+
+// mergeSpecs copies the fields set in the instance type specs returned by the
+// EC2 API over the static data.
+func (info *instanceTypeInformation) mergeSpecs(spec *ec2.InstanceTypeInfo) {
+	if spec.VCpuInfo != nil && spec.VCpuInfo.DefaultVCpus != nil {
+		info.vCPU = int(*spec.VCpuInfo.DefaultVCpus)
+	}
+
+	if spec.MemoryInfo != nil && spec.MemoryInfo.SizeInMiB != nil {
+		info.memory = float32(*spec.MemoryInfo.SizeInMiB) / 1024
+	}
+
+	if spec.ProcessorInfo != nil && len(spec.ProcessorInfo.SupportedArchitectures) > 0 {
+		info.architectures = aws.StringValueSlice(spec.ProcessorInfo.SupportedArchitectures)
+	}
+
+	if spec.GpuInfo != nil {
+		gpus := 0
+		for _, gpu := range spec.GpuInfo.Gpus {
+			gpus += int(aws.Int64Value(gpu.Count))
+		}
+		info.GPU = gpus
+	}
+
+	info.mergeAcceleratorSpecs(spec)
+
+	if spec.Hypervisor != nil {
+		info.hypervisor = *spec.Hypervisor
+	}
+
+	if spec.BurstablePerformanceSupported != nil {
+		info.burstable = *spec.BurstablePerformanceSupported
+	}
+
+	if len(spec.SupportedVirtualizationTypes) > 0 {
+		var types []string
+		for _, t := range spec.SupportedVirtualizationTypes {
+			if name, ok := virtualizationTypeNames[aws.StringValue(t)]; ok {
+				types = append(types, name)
+			}
+		}
+		info.virtualizationTypes = types
+	}
+
+	if spec.NetworkInfo != nil {
+		info.enaSupport = aws.StringValue(spec.NetworkInfo.EnaSupport)
+		if spec.NetworkInfo.NetworkPerformance != nil {
+			info.networkPerformance = *spec.NetworkInfo.NetworkPerformance
+		}
+		info.maxENIs = int(aws.Int64Value(spec.NetworkInfo.MaximumNetworkInterfaces))
+		info.ipv4PerENI = int(aws.Int64Value(spec.NetworkInfo.Ipv4AddressesPerInterface))
+	}
+
+	if spec.EbsInfo != nil {
+		info.hasEBSOptimization = aws.StringValue(spec.EbsInfo.EbsOptimizedSupport) != ec2.EbsOptimizedSupportUnsupported
+		info.ebsNVMeSupport = aws.StringValue(spec.EbsInfo.NvmeSupport)
+		if spec.EbsInfo.EbsOptimizedInfo != nil && spec.EbsInfo.EbsOptimizedInfo.MaximumThroughputInMBps != nil {
+			info.EBSThroughput = float32(*spec.EbsInfo.EbsOptimizedInfo.MaximumThroughputInMBps)
+		}
+	}
+
+	if spec.InstanceStorageSupported != nil {
+		info.hasInstanceStore = *spec.InstanceStorageSupported
+	}
+
+	if storage := spec.InstanceStorageInfo; storage != nil {
+		info.instanceStoreNVMeSupport = aws.StringValue(storage.NvmeSupport)
+		if len(storage.Disks) > 0 {
+			disk := storage.Disks[0]
+			info.hasInstanceStore = true
+			info.instanceStoreDeviceCount = int(aws.Int64Value(disk.Count))
+			info.instanceStoreDeviceSize = float32(aws.Int64Value(disk.SizeInGB))
+			info.instanceStoreIsSSD = strings.EqualFold(aws.StringValue(disk.Type), ec2.DiskTypeSsd)
+		}
+	}
+}
+
+// isNetworkCompatible checks that the candidate instance type can run the AMI
+// of the current instance, since the types requiring ENA can't be launched from
+// AMIs without the ENA driver.
+func (i *instance) isNetworkCompatible(spotCandidate instanceTypeInformation) bool {
+	if spotCandidate.enaSupport == ec2.EnaSupportRequired && !aws.BoolValue(i.EnaSupport) {
+		debug.Println("\tNot network compatible, ENA is required by", spotCandidate.instanceType,
+			"but not enabled on the current instance")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func testInstanceTypeSpec(name string) *ec2.InstanceTypeInfo {
+	return &ec2.InstanceTypeInfo{
+		InstanceType:                 aws.String(name),
+		VCpuInfo:                     &ec2.VCpuInfo{DefaultVCpus: aws.Int64(4)},
+		MemoryInfo:                   &ec2.MemoryInfo{SizeInMiB: aws.Int64(16384)},
+		ProcessorInfo:                &ec2.ProcessorInfo{SupportedArchitectures: aws.StringSlice([]string{"arm64"})},
+		SupportedVirtualizationTypes: aws.StringSlice([]string{"hvm"}),
+		NetworkInfo: &ec2.NetworkInfo{
+			EnaSupport:         aws.String("required"),
+			NetworkPerformance: aws.String("Up to 10 Gigabit"),
+		},
+		EbsInfo: &ec2.EbsInfo{
+			EbsOptimizedSupport: aws.String("default"),
+			NvmeSupport:         aws.String("required"),
+			EbsOptimizedInfo:    &ec2.EbsOptimizedInfo{MaximumThroughputInMBps: aws.Float64(593.75)},
+		},
+		InstanceStorageSupported: aws.Bool(true),
+		InstanceStorageInfo: &ec2.InstanceStorageInfo{
+			NvmeSupport: aws.String("required"),
+			Disks: []*ec2.DiskInfo{
+				{Count: aws.Int64(1), SizeInGB: aws.Int64(237), Type: aws.String("ssd")},
+			},
+		},
+	}
+}
+
+func Test_instanceTypeInformation_mergeSpecs(t *testing.T) {
+	info := instanceTypeInformation{
+		instanceType:        "m6gd.xlarge",
+		vCPU:                2,
+		memory:              8,
+		PhysicalProcessor:   "AWS Graviton2 Processor",
+		virtualizationTypes: []string{"PV"},
+		pricing:             prices{onDemand: 0.18},
+	}
+
+	info.mergeSpecs(testInstanceTypeSpec("m6gd.xlarge"))
+
+	expected := instanceTypeInformation{
+		instanceType:             "m6gd.xlarge",
+		vCPU:                     4,
+		memory:                   16,
+		PhysicalProcessor:        "AWS Graviton2 Processor",
+		virtualizationTypes:      []string{"HVM"},
+		pricing:                  prices{onDemand: 0.18},
+		architectures:            []string{"arm64"},
+		enaSupport:               "required",
+		networkPerformance:       "Up to 10 Gigabit",
+		hasEBSOptimization:       true,
+		ebsNVMeSupport:           "required",
+		EBSThroughput:            593.75,
+		hasInstanceStore:         true,
+		instanceStoreNVMeSupport: "required",
+		instanceStoreDeviceCount: 1,
+		instanceStoreDeviceSize:  237,
+		instanceStoreIsSSD:       true,
+		GPU:                      0,
+	}
+
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("mergeSpecs() = %+v, expected %+v", info, expected)
+	}
+
+	// empty specs keep the static data
+	static := instanceTypeInformation{instanceType: "m5.large", vCPU: 2, memory: 8, GPU: 1}
+	merged := static
+	merged.mergeSpecs(&ec2.InstanceTypeInfo{InstanceType: aws.String("m5.large")})
+	if !reflect.DeepEqual(merged, static) {
+		t.Errorf("mergeSpecs() = %+v, expected the static data %+v", merged, static)
+	}
+}
+
+func Test_region_mergeInstanceTypeSpecs(t *testing.T) {
+	tests := []struct {
+		name         string
+		ditpo        []*ec2.DescribeInstanceTypesOutput
+		ditperr      error
+		expectedVCPU int
+	}{
+		{
+			name: "specs merged from multiple pages",
+			ditpo: []*ec2.DescribeInstanceTypesOutput{
+				{InstanceTypes: []*ec2.InstanceTypeInfo{testInstanceTypeSpec("c5.large")}},
+				{InstanceTypes: []*ec2.InstanceTypeInfo{
+					testInstanceTypeSpec("m6gd.xlarge"),
+					testInstanceTypeSpec("x9.huge"),
+				}},
+			},
+			expectedVCPU: 4,
+		},
+		{
+			name:         "static data kept on errors",
+			ditperr:      errors.New("denied"),
+			expectedVCPU: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name: "us-east-1",
+				instanceTypeInformation: map[string]instanceTypeInformation{
+					"m6gd.xlarge": {instanceType: "m6gd.xlarge", vCPU: 2},
+				},
+				services: connections{
+					ec2: mockEC2{ditpo: tt.ditpo, ditperr: tt.ditperr},
+				},
+			}
+
+			r.mergeInstanceTypeSpecs(context.Background())
+
+			if got := r.instanceTypeInformation["m6gd.xlarge"].vCPU; got != tt.expectedVCPU {
+				t.Errorf("vCPU = %v, expected %v", got, tt.expectedVCPU)
+			}
+			if _, ok := r.instanceTypeInformation["x9.huge"]; ok {
+				t.Errorf("expected the types without pricing data to be skipped")
+			}
+		})
+	}
+}
+
+func Test_instance_isSameArchFromSpecs(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   instanceTypeInformation
+		candidate instanceTypeInformation
+		expected  bool
+	}{
+		{
+			name:      "common architecture",
+			current:   instanceTypeInformation{architectures: []string{"i386", "x86_64"}, PhysicalProcessor: "AWS"},
+			candidate: instanceTypeInformation{architectures: []string{"x86_64"}, PhysicalProcessor: "Intel"},
+			expected:  true,
+		},
+		{
+			name:      "different architectures",
+			current:   instanceTypeInformation{architectures: []string{"x86_64"}, PhysicalProcessor: "Intel"},
+			candidate: instanceTypeInformation{architectures: []string{"arm64"}, PhysicalProcessor: "Intel"},
+			expected:  false,
+		},
+		{
+			name:      "falls back to the processor names",
+			current:   instanceTypeInformation{architectures: []string{"x86_64"}, PhysicalProcessor: "Intel"},
+			candidate: instanceTypeInformation{PhysicalProcessor: "AMD"},
+			expected:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{typeInfo: tt.current}
+			if got := i.isSameArch(tt.candidate); got != tt.expected {
+				t.Errorf("isSameArch() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_isSameArchFromAMI(t *testing.T) {
+	tests := []struct {
+		name      string
+		amiArch   *string
+		current   instanceTypeInformation
+		candidate instanceTypeInformation
+		expected  bool
+	}{
+		{
+			name:      "candidate supports the AMI architecture",
+			amiArch:   aws.String("x86_64"),
+			current:   instanceTypeInformation{architectures: []string{"x86_64"}, PhysicalProcessor: "Intel Xeon"},
+			candidate: instanceTypeInformation{architectures: []string{"i386", "x86_64"}, PhysicalProcessor: "New Processor"},
+			expected:  true,
+		},
+		{
+			name:      "candidate doesn't support the AMI architecture",
+			amiArch:   aws.String("arm64"),
+			current:   instanceTypeInformation{architectures: []string{"arm64"}, PhysicalProcessor: "AWS Graviton2 Processor"},
+			candidate: instanceTypeInformation{architectures: []string{"x86_64"}, PhysicalProcessor: "AWS Graviton2 Processor"},
+			expected:  false,
+		},
+		{
+			name:      "32-bit AMI on a 64-bit only candidate",
+			amiArch:   aws.String("i386"),
+			current:   instanceTypeInformation{architectures: []string{"i386", "x86_64"}},
+			candidate: instanceTypeInformation{architectures: []string{"x86_64"}},
+			expected:  false,
+		},
+		{
+			name:      "unknown AMI architecture uses the instance type architectures",
+			current:   instanceTypeInformation{architectures: []string{"arm64"}},
+			candidate: instanceTypeInformation{architectures: []string{"arm64"}},
+			expected:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{Architecture: tt.amiArch},
+				typeInfo: tt.current,
+			}
+			if got := i.isSameArch(tt.candidate); got != tt.expected {
+				t.Errorf("isSameArch() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_isCPUVendorCompatible(t *testing.T) {
+	tests := []struct {
+		name      string
+		allow     string
+		asg       bool
+		current   string
+		candidate string
+		expected  bool
+	}{
+		{name: "Intel to Intel", asg: true, current: "Intel Xeon Platinum 8175", candidate: "Intel Xeon E5-2686 v4", expected: true},
+		{name: "Intel to AMD not allowed", asg: true, current: "Intel Xeon Platinum 8175", candidate: "AMD EPYC 7571", expected: false},
+		{name: "AMD to Intel not allowed", asg: true, current: "AMD EPYC 7571", candidate: "Intel Xeon Platinum 8175", expected: false},
+		{name: "Intel to AMD allowed", allow: "true", asg: true, current: "Intel Xeon Platinum 8175", candidate: "AMD EPYC 7571", expected: true},
+		{name: "unknown vendor", asg: true, current: "Intel Xeon Platinum 8175", candidate: "New Processor", expected: true},
+		{name: "no group", current: "Intel Xeon Platinum 8175", candidate: "AMD EPYC 7571", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{typeInfo: instanceTypeInformation{PhysicalProcessor: tt.current}}
+			if tt.asg {
+				i.asg = &autoScalingGroup{config: AutoScalingConfig{AllowCPUVendorSubstitution: tt.allow}}
+			}
+			candidate := instanceTypeInformation{instanceType: "m5a.large", PhysicalProcessor: tt.candidate}
+			if got := i.isCPUVendorCompatible(candidate); got != tt.expected {
+				t.Errorf("isCPUVendorCompatible() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_isNetworkCompatible(t *testing.T) {
+	tests := []struct {
+		name       string
+		enaEnabled *bool
+		enaSupport string
+		expected   bool
+	}{
+		{name: "ENA required and enabled", enaEnabled: aws.Bool(true), enaSupport: "required", expected: true},
+		{name: "ENA required but not enabled", enaEnabled: aws.Bool(false), enaSupport: "required", expected: false},
+		{name: "ENA required and unknown", enaEnabled: nil, enaSupport: "required", expected: false},
+		{name: "ENA supported", enaEnabled: nil, enaSupport: "supported", expected: true},
+		{name: "no ENA data", enaEnabled: nil, enaSupport: "", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{Instance: &ec2.Instance{EnaSupport: tt.enaEnabled}}
+			candidate := instanceTypeInformation{instanceType: "m5.large", enaSupport: tt.enaSupport}
+			if got := i.isNetworkCompatible(candidate); got != tt.expected {
+				t.Errorf("isNetworkCompatible() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
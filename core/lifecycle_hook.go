@@ -0,0 +1,252 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+const (
+	// launchLifecycleTransition is the transition name used by the lifecycle
+	// hooks executed when instances are launched by the AutoScaling group.
+	launchLifecycleTransition = "autoscaling:EC2_INSTANCE_LAUNCHING"
+
+	// lifecycleStatePollInterval is how often we check the lifecycle state of
+	// an instance while waiting for it to reach a certain state.
+	lifecycleStatePollInterval = 10 * time.Second
+
+	// maxLifecycleHookWait caps the time we wait for the launch lifecycle hooks
+	// to complete, so we don't run into the Lambda execution timeout.
+	maxLifecycleHookWait = 10 * time.Minute
+)
+
+// getLaunchLifecycleHooks returns the lifecycle hooks configured on the group
+// for the EC2_INSTANCE_LAUNCHING transition.
+func (a *autoScalingGroup) getLaunchLifecycleHooks(ctx context.Context) []*autoscaling.LifecycleHook {
+	var hooks []*autoscaling.LifecycleHook
+
+	result, err := a.region.services.autoScaling.DescribeLifecycleHooksWithContext(ctx,
+		&autoscaling.DescribeLifecycleHooksInput{
+			AutoScalingGroupName: aws.String(a.name),
+		})
+
+	if err != nil {
+		logger.Println(a.name, "Failed to describe lifecycle hooks:", err.Error())
+		return nil
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	for _, hook := range result.LifecycleHooks {
+		if hook.LifecycleTransition != nil &&
+			*hook.LifecycleTransition == launchLifecycleTransition {
+			debug.Println(a.name, "Found launch lifecycle hook", *hook.LifecycleHookName)
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+// launchLifecycleHookTimeout computes how long we should wait for the given
+// launch lifecycle hooks to be completed, based on their heartbeat timeout.
+func launchLifecycleHookTimeout(hooks []*autoscaling.LifecycleHook) time.Duration {
+	var timeout time.Duration
+
+	for _, hook := range hooks {
+		if hook.HeartbeatTimeout == nil {
+			continue
+		}
+		if t := time.Duration(*hook.HeartbeatTimeout) * time.Second; t > timeout {
+			timeout = t
+		}
+	}
+
+	if timeout == 0 || timeout > maxLifecycleHookWait {
+		timeout = maxLifecycleHookWait
+	}
+	return timeout
+}
+
+// getAutoScalingInstance returns the details of an instance as seen by the
+// AutoScaling group, or nil if the instance isn't a member of any group.
+func (a *autoScalingGroup) getAutoScalingInstance(ctx context.Context, instanceID *string) (*autoscaling.InstanceDetails, error) {
+	result, err := a.region.services.autoScaling.DescribeAutoScalingInstancesWithContext(ctx,
+		&autoscaling.DescribeAutoScalingInstancesInput{
+			InstanceIds: []*string{instanceID},
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if result == nil {
+		return nil, nil
+	}
+
+	for _, inst := range result.AutoScalingInstances {
+		if inst.InstanceId != nil && *inst.InstanceId == *instanceID {
+			return inst, nil
+		}
+	}
+	return nil, nil
+}
+
+// getInstanceLifecycleState returns the lifecycle state of an instance as seen
+// by the AutoScaling group, or an empty string if the instance isn't a member.
+func (a *autoScalingGroup) getInstanceLifecycleState(ctx context.Context, instanceID *string) (string, error) {
+	inst, err := a.getAutoScalingInstance(ctx, instanceID)
+
+	if err != nil || inst == nil || inst.LifecycleState == nil {
+		return "", err
+	}
+	return *inst.LifecycleState, nil
+}
+
+// lifecycleWaitTimeout shortens the timeout of a wait so it ends before the
+// deadline of the run, leaving the safety margin for rolling back.
+func (a *autoScalingGroup) lifecycleWaitTimeout(ctx context.Context,
+	timeout time.Duration) time.Duration {
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return timeout
+	}
+
+	if left := time.Until(deadline) - a.region.conf.DeadlineSafetyMargin; left < timeout {
+		return left
+	}
+	return timeout
+}
+
+// waitForLifecycleState polls the lifecycle state of an instance until it
+// reaches the expected state. It gives up when the timeout or the deadline of
+// the run is reached, or when the instance is on its way out of the group.
+func (a *autoScalingGroup) waitForLifecycleState(ctx context.Context, instanceID *string,
+	expected string, timeout time.Duration) error {
+
+	timeout = a.lifecycleWaitTimeout(ctx, timeout)
+	if timeout <= 0 {
+		return fmt.Errorf("no time left to wait for instance %s to reach lifecycle state %s",
+			*instanceID, expected)
+	}
+
+	attempts := int(timeout / lifecycleStatePollInterval)
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		state, err := a.getInstanceLifecycleState(ctx, instanceID)
+		if err != nil {
+			logger.Println(a.name, "Failed to determine lifecycle state of",
+				*instanceID, err.Error())
+			return err
+		}
+
+		debug.Println(a.name, "Instance", *instanceID, "is in lifecycle state", state)
+
+		if state == expected {
+			return nil
+		}
+
+		if strings.HasPrefix(state, autoscaling.LifecycleStateTerminating) ||
+			state == autoscaling.LifecycleStateTerminated ||
+			state == autoscaling.LifecycleStateDetaching ||
+			state == autoscaling.LifecycleStateDetached {
+			return fmt.Errorf("instance %s is in lifecycle state %s, expected %s",
+				*instanceID, state, expected)
+		}
+
+		if err := sleepContext(ctx, lifecycleStatePollInterval*a.region.conf.SleepMultiplier); err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("timed out waiting for instance %s to reach lifecycle state %s",
+		*instanceID, expected)
+}
+
+// enterStandby moves an instance of the group into the Standby state,
+// decrementing the desired capacity so no replacement instance is launched.
+func (a *autoScalingGroup) enterStandby(ctx context.Context, instanceID *string) error {
+	logger.Println(a.region.name, a.name, "Moving instance", *instanceID, "to Standby")
+
+	_, err := a.region.services.autoScaling.EnterStandbyWithContext(ctx,
+		&autoscaling.EnterStandbyInput{
+			AutoScalingGroupName:           aws.String(a.name),
+			InstanceIds:                    []*string{instanceID},
+			ShouldDecrementDesiredCapacity: aws.Bool(true),
+		})
+
+	if err != nil {
+		logger.Println(a.name, "Failed to move instance", *instanceID,
+			"to Standby:", err.Error())
+		return err
+	}
+	return nil
+}
+
+// exitStandby moves an instance of the group from Standby back in service,
+// incrementing the desired capacity. The instance goes through the Pending
+// state, which triggers the launch lifecycle hooks configured on the group.
+func (a *autoScalingGroup) exitStandby(ctx context.Context, instanceID *string) error {
+	logger.Println(a.region.name, a.name, "Moving instance", *instanceID, "out of Standby")
+
+	_, err := a.region.services.autoScaling.ExitStandbyWithContext(ctx,
+		&autoscaling.ExitStandbyInput{
+			AutoScalingGroupName: aws.String(a.name),
+			InstanceIds:          []*string{instanceID},
+		})
+
+	if err != nil {
+		logger.Println(a.name, "Failed to move instance", *instanceID,
+			"out of Standby:", err.Error())
+		return err
+	}
+	return nil
+}
+
+// runLaunchLifecycleHooks makes sure the launch lifecycle hooks are executed
+// for an instance we attached to the group. Attached instances skip those
+// hooks, so we cycle the instance through the Standby state, which makes the
+// group run them when the instance is put back in service. It returns an error
+// unless the instance went back in service, in which case the hooks completed
+// with the CONTINUE result.
+func (a *autoScalingGroup) runLaunchLifecycleHooks(ctx context.Context, instanceID *string,
+	hooks []*autoscaling.LifecycleHook) error {
+
+	timeout := launchLifecycleHookTimeout(hooks)
+
+	logger.Println(a.region.name, a.name, "Running", len(hooks),
+		"launch lifecycle hook(s) for instance", *instanceID)
+
+	if err := a.waitForLifecycleState(ctx, instanceID,
+		autoscaling.LifecycleStateInService, maxLifecycleHookWait); err != nil {
+		return err
+	}
+
+	if err := a.enterStandby(ctx, instanceID); err != nil {
+		return err
+	}
+
+	if err := a.waitForLifecycleState(ctx, instanceID,
+		autoscaling.LifecycleStateStandby, maxLifecycleHookWait); err != nil {
+		return err
+	}
+
+	if err := a.exitStandby(ctx, instanceID); err != nil {
+		return err
+	}
+
+	return a.waitForLifecycleState(ctx, instanceID,
+		autoscaling.LifecycleStateInService, timeout)
+}
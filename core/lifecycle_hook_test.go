@@ -0,0 +1,295 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func asgInstancesInState(instanceID string, state string) *autoscaling.DescribeAutoScalingInstancesOutput {
+	return &autoscaling.DescribeAutoScalingInstancesOutput{
+		AutoScalingInstances: []*autoscaling.InstanceDetails{
+			{
+				InstanceId:     aws.String(instanceID),
+				LifecycleState: aws.String(state),
+			},
+		},
+	}
+}
+
+func Test_autoScalingGroup_getLaunchLifecycleHooks(t *testing.T) {
+	tests := []struct {
+		name     string
+		asSvc    mockASG
+		expected []string
+	}{
+		{
+			name:     "describe error",
+			asSvc:    mockASG{dlherr: errors.New("error")},
+			expected: nil,
+		},
+		{
+			name:     "no hooks",
+			asSvc:    mockASG{},
+			expected: nil,
+		},
+		{
+			name: "only termination hooks",
+			asSvc: mockASG{
+				dlho: &autoscaling.DescribeLifecycleHooksOutput{
+					LifecycleHooks: []*autoscaling.LifecycleHook{
+						{
+							LifecycleHookName:   aws.String("drain"),
+							LifecycleTransition: aws.String("autoscaling:EC2_INSTANCE_TERMINATING"),
+						},
+					},
+				},
+			},
+			expected: nil,
+		},
+		{
+			name: "launch and termination hooks",
+			asSvc: mockASG{
+				dlho: &autoscaling.DescribeLifecycleHooksOutput{
+					LifecycleHooks: []*autoscaling.LifecycleHook{
+						{
+							LifecycleHookName:   aws.String("drain"),
+							LifecycleTransition: aws.String("autoscaling:EC2_INSTANCE_TERMINATING"),
+						},
+						{
+							LifecycleHookName:   aws.String("bootstrap"),
+							LifecycleTransition: aws.String("autoscaling:EC2_INSTANCE_LAUNCHING"),
+						},
+					},
+				},
+			},
+			expected: []string{"bootstrap"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name: "test-asg",
+				region: &region{
+					services: connections{autoScaling: tt.asSvc},
+				},
+			}
+			hooks := a.getLaunchLifecycleHooks(context.Background())
+
+			if len(hooks) != len(tt.expected) {
+				t.Fatalf("expected %d hooks, got %d", len(tt.expected), len(hooks))
+			}
+			for i, hook := range hooks {
+				if *hook.LifecycleHookName != tt.expected[i] {
+					t.Errorf("expected hook %s, got %s", tt.expected[i], *hook.LifecycleHookName)
+				}
+			}
+		})
+	}
+}
+
+func Test_launchLifecycleHookTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		hooks    []*autoscaling.LifecycleHook
+		expected time.Duration
+	}{
+		{
+			name:     "no timeout set",
+			hooks:    []*autoscaling.LifecycleHook{{}},
+			expected: maxLifecycleHookWait,
+		},
+		{
+			name: "largest timeout is used",
+			hooks: []*autoscaling.LifecycleHook{
+				{HeartbeatTimeout: aws.Int64(60)},
+				{HeartbeatTimeout: aws.Int64(300)},
+			},
+			expected: 300 * time.Second,
+		},
+		{
+			name: "timeout is capped",
+			hooks: []*autoscaling.LifecycleHook{
+				{HeartbeatTimeout: aws.Int64(3600)},
+			},
+			expected: maxLifecycleHookWait,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := launchLifecycleHookTimeout(tt.hooks); got != tt.expected {
+				t.Errorf("launchLifecycleHookTimeout() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_waitForLifecycleState(t *testing.T) {
+	tests := []struct {
+		name    string
+		asSvc   mockASG
+		wantErr bool
+	}{
+		{
+			name:    "describe error",
+			asSvc:   mockASG{dasierr: errors.New("error")},
+			wantErr: true,
+		},
+		{
+			name:    "expected state reached",
+			asSvc:   mockASG{dasio: asgInstancesInState("i-spot", "InService")},
+			wantErr: false,
+		},
+		{
+			name:    "instance terminating",
+			asSvc:   mockASG{dasio: asgInstancesInState("i-spot", "Terminating:Wait")},
+			wantErr: true,
+		},
+		{
+			name:    "timed out",
+			asSvc:   mockASG{dasio: asgInstancesInState("i-spot", "Pending:Wait")},
+			wantErr: true,
+		},
+		{
+			name: "expected state reached after a while",
+			asSvc: mockASG{
+				dasios: []*autoscaling.DescribeAutoScalingInstancesOutput{
+					asgInstancesInState("i-spot", "Pending"),
+					asgInstancesInState("i-spot", "Pending:Wait"),
+					asgInstancesInState("i-spot", "InService"),
+				},
+				dasiCalls: new(int),
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name: "test-asg",
+				region: &region{
+					conf:     &Config{},
+					services: connections{autoScaling: tt.asSvc},
+				},
+			}
+			err := a.waitForLifecycleState(context.Background(), aws.String("i-spot"), "InService", time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("waitForLifecycleState() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_waitForLifecycleState_deadline(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	a := &autoScalingGroup{
+		name: "test-asg",
+		region: &region{
+			conf: &Config{DeadlineSafetyMargin: time.Minute},
+			services: connections{autoScaling: mockASG{
+				dasio: asgInstancesInState("i-spot", "InService"),
+			}},
+		},
+	}
+
+	if got := a.lifecycleWaitTimeout(context.Background(), time.Minute); got != time.Minute {
+		t.Errorf("lifecycleWaitTimeout() = %v, want %v without a deadline", got, time.Minute)
+	}
+
+	// the state was reached, but there's no time left for waiting
+	if err := a.waitForLifecycleState(ctx, aws.String("i-spot"), "InService", 10*time.Minute); err == nil {
+		t.Errorf("waitForLifecycleState() expected an error within the safety margin")
+	}
+}
+
+func Test_autoScalingGroup_runLaunchLifecycleHooks(t *testing.T) {
+	hooks := []*autoscaling.LifecycleHook{
+		{
+			LifecycleHookName:   aws.String("bootstrap"),
+			LifecycleTransition: aws.String("autoscaling:EC2_INSTANCE_LAUNCHING"),
+			HeartbeatTimeout:    aws.Int64(300),
+		},
+	}
+
+	tests := []struct {
+		name    string
+		asSvc   mockASG
+		wantErr bool
+	}{
+		{
+			name: "hooks completed",
+			asSvc: mockASG{
+				dasios: []*autoscaling.DescribeAutoScalingInstancesOutput{
+					asgInstancesInState("i-spot", "InService"),
+					asgInstancesInState("i-spot", "Standby"),
+					asgInstancesInState("i-spot", "Pending:Wait"),
+					asgInstancesInState("i-spot", "InService"),
+				},
+				dasiCalls: new(int),
+			},
+			wantErr: false,
+		},
+		{
+			name: "hooks abandoned",
+			asSvc: mockASG{
+				dasios: []*autoscaling.DescribeAutoScalingInstancesOutput{
+					asgInstancesInState("i-spot", "InService"),
+					asgInstancesInState("i-spot", "Standby"),
+					asgInstancesInState("i-spot", "Pending:Wait"),
+					asgInstancesInState("i-spot", "Terminating"),
+				},
+				dasiCalls: new(int),
+			},
+			wantErr: true,
+		},
+		{
+			name: "enter standby failed",
+			asSvc: mockASG{
+				dasio:  asgInstancesInState("i-spot", "InService"),
+				esberr: errors.New("error"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "exit standby failed",
+			asSvc: mockASG{
+				dasios: []*autoscaling.DescribeAutoScalingInstancesOutput{
+					asgInstancesInState("i-spot", "InService"),
+					asgInstancesInState("i-spot", "Standby"),
+				},
+				dasiCalls: new(int),
+				exsberr:   errors.New("error"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name: "test-asg",
+				region: &region{
+					conf:     &Config{},
+					services: connections{autoScaling: tt.asSvc},
+				},
+			}
+			err := a.runLaunchLifecycleHooks(context.Background(), aws.String("i-spot"), hooks)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("runLaunchLifecycleHooks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
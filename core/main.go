@@ -4,6 +4,7 @@
 package autospotting
 
 import (
+	"context"
 	"io/ioutil"
 	"log"
 	"os"
@@ -23,28 +24,34 @@ var savingsMutex = &sync.RWMutex{}
 
 // Run starts processing all AWS regions looking for AutoScaling groups
 // enabled and taking action by replacing more pricy on-demand instances with
-// compatible and cheaper spot instances.
-func Run(cfg *Config) {
+// compatible and cheaper spot instances. No new replacements are started once
+// the deadline of the context gets closer than the configured safety margin.
+func Run(ctx context.Context, cfg *Config) {
 
 	setupLogging(cfg)
 
 	debug.Println(*cfg)
 
-	// use this only to list all the other regions
-	ec2Conn := connectEC2(cfg.MainRegion)
+	if err := refreshInstanceData(cfg); err != nil {
+		logger.Println("Couldn't load the instance data:", err.Error())
+		return
+	}
 
 	addDefaultFilteringMode(cfg)
 	addDefaultFilter(cfg)
 
-	allRegions, err := getRegions(ec2Conn)
+	allRegions, err := targetRegions(cfg)
 
 	if err != nil {
 		logger.Println(err.Error())
 		return
 	}
 
-	processRegions(allRegions, cfg)
-
+	resetAPIStats()
+	resetUnfinished()
+	processRegions(ctx, allRegions, cfg)
+	logAPIStats()
+	logUnfinished()
 }
 
 func addDefaultFilteringMode(cfg *Config) {
@@ -82,23 +89,26 @@ func setupLogging(cfg *Config) {
 // processAllRegions iterates all regions in parallel, and replaces instances
 // for each of the ASGs tagged with tags as specified by slice represented by cfg.FilterByTags
 // by default this is all asg with the tag 'spot-enabled=true'.
-func processRegions(regions []string, cfg *Config) {
+func processRegions(ctx context.Context, regions []string, cfg *Config) {
 
 	var wg sync.WaitGroup
+	pool := newWorkerPool(cfg.MaxConcurrentRegions)
 
 	for _, r := range regions {
 
 		wg.Add(1)
+		pool.acquire()
 		r := region{name: r, conf: cfg}
 
 		go func() {
+			defer pool.release()
 
-			if r.enabled() {
-				logger.Printf("Enabled to run in %s, processing region.\n", r.name)
-				r.processRegion()
-			} else {
+			if !r.enabled() {
 				debug.Println("Not enabled to run in", r.name)
 				debug.Println("List of enabled regions:", cfg.Regions)
+			} else if enoughTimeLeft(ctx, cfg.DeadlineSafetyMargin, "processing region "+r.name) {
+				logger.Printf("Enabled to run in %s, processing region.\n", r.name)
+				r.processRegion(ctx)
 			}
 
 			wg.Done()
@@ -118,6 +128,18 @@ func connectEC2(region string) *ec2.EC2 {
 		aws.NewConfig().WithRegion(region))
 }
 
+// targetRegions returns the regions targeted by the configuration, or all the
+// AWS regions if none was targeted.
+func targetRegions(cfg *Config) ([]string, error) {
+	if regions := splitTargetList(cfg.TargetRegions); len(regions) > 0 {
+		logger.Println("Only processing the targeted regions", regions)
+		return regions, nil
+	}
+
+	// use this only to list all the other regions
+	return getRegions(connectEC2(cfg.MainRegion))
+}
+
 // getRegions generates a list of AWS regions.
 func getRegions(ec2conn ec2iface.EC2API) ([]string, error) {
 	var output []string
@@ -6,6 +6,8 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
@@ -57,6 +59,34 @@ type mockEC2 struct {
 	// DescribeLaunchTemplateVersionsOutput
 	dltvo   *ec2.DescribeLaunchTemplateVersionsOutput
 	dltverr error
+
+	// DescribeSubnets
+	dso   *ec2.DescribeSubnetsOutput
+	dserr error
+
+	// RunInstances, recording the inputs when set
+	rio      *ec2.Reservation
+	rierr    error
+	riInputs *[]*ec2.RunInstancesInput
+
+	// DescribeImages
+	dimo   *ec2.DescribeImagesOutput
+	dimerr error
+
+	// DescribeReservedInstances
+	drio   *ec2.DescribeReservedInstancesOutput
+	drierr error
+
+	// DescribeInstanceTypesPages
+	ditpo   []*ec2.DescribeInstanceTypesOutput
+	ditperr error
+
+	// DescribeInstanceCreditSpecifications
+	dicso   *ec2.DescribeInstanceCreditSpecificationsOutput
+	dicserr error
+
+	// WaitUntilInstanceRunning
+	wuirerr error
 }
 
 func (m mockEC2) DescribeSpotPriceHistoryPages(in *ec2.DescribeSpotPriceHistoryInput, f func(*ec2.DescribeSpotPriceHistoryOutput, bool) bool) error {
@@ -71,6 +101,10 @@ func (m mockEC2) DescribeInstancesPages(in *ec2.DescribeInstancesInput, f func(*
 	return m.diperr
 }
 
+func (m mockEC2) DescribeInstancesPagesWithContext(ctx aws.Context, in *ec2.DescribeInstancesInput, f func(*ec2.DescribeInstancesOutput, bool) bool, opts ...request.Option) error {
+	return m.DescribeInstancesPages(in, f)
+}
+
 func (m mockEC2) DescribeInstanceAttribute(in *ec2.DescribeInstanceAttributeInput) (*ec2.DescribeInstanceAttributeOutput, error) {
 	return m.diao, m.diaerr
 }
@@ -79,6 +113,10 @@ func (m mockEC2) TerminateInstances(*ec2.TerminateInstancesInput) (*ec2.Terminat
 	return m.tio, m.tierr
 }
 
+func (m mockEC2) TerminateInstancesWithContext(ctx aws.Context, in *ec2.TerminateInstancesInput, opts ...request.Option) (*ec2.TerminateInstancesOutput, error) {
+	return m.TerminateInstances(in)
+}
+
 func (m mockEC2) DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
 	return m.dro, m.drerr
 }
@@ -91,6 +129,64 @@ func (m mockEC2) DescribeLaunchTemplateVersions(*ec2.DescribeLaunchTemplateVersi
 	return m.dltvo, m.dltverr
 }
 
+func (m mockEC2) DescribeLaunchTemplateVersionsWithContext(ctx aws.Context, in *ec2.DescribeLaunchTemplateVersionsInput, opts ...request.Option) (*ec2.DescribeLaunchTemplateVersionsOutput, error) {
+	return m.DescribeLaunchTemplateVersions(in)
+}
+
+func (m mockEC2) DescribeSubnets(*ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	return m.dso, m.dserr
+}
+
+func (m mockEC2) DescribeSubnetsWithContext(ctx aws.Context, in *ec2.DescribeSubnetsInput, opts ...request.Option) (*ec2.DescribeSubnetsOutput, error) {
+	return m.DescribeSubnets(in)
+}
+
+func (m mockEC2) RunInstancesWithContext(ctx aws.Context, in *ec2.RunInstancesInput, opts ...request.Option) (*ec2.Reservation, error) {
+	if m.riInputs != nil {
+		*m.riInputs = append(*m.riInputs, in)
+	}
+	return m.rio, m.rierr
+}
+
+func (m mockEC2) DescribeImages(*ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	return m.dimo, m.dimerr
+}
+
+func (m mockEC2) DescribeImagesWithContext(ctx aws.Context, in *ec2.DescribeImagesInput, opts ...request.Option) (*ec2.DescribeImagesOutput, error) {
+	return m.DescribeImages(in)
+}
+
+func (m mockEC2) DescribeReservedInstances(*ec2.DescribeReservedInstancesInput) (*ec2.DescribeReservedInstancesOutput, error) {
+	return m.drio, m.drierr
+}
+
+func (m mockEC2) DescribeReservedInstancesWithContext(ctx aws.Context, in *ec2.DescribeReservedInstancesInput, opts ...request.Option) (*ec2.DescribeReservedInstancesOutput, error) {
+	return m.DescribeReservedInstances(in)
+}
+
+func (m mockEC2) DescribeInstanceTypesPages(in *ec2.DescribeInstanceTypesInput, f func(*ec2.DescribeInstanceTypesOutput, bool) bool) error {
+	for i, page := range m.ditpo {
+		f(page, i == len(m.ditpo)-1)
+	}
+	return m.ditperr
+}
+
+func (m mockEC2) DescribeInstanceTypesPagesWithContext(ctx aws.Context, in *ec2.DescribeInstanceTypesInput, f func(*ec2.DescribeInstanceTypesOutput, bool) bool, opts ...request.Option) error {
+	return m.DescribeInstanceTypesPages(in, f)
+}
+
+func (m mockEC2) DescribeInstanceCreditSpecifications(in *ec2.DescribeInstanceCreditSpecificationsInput) (*ec2.DescribeInstanceCreditSpecificationsOutput, error) {
+	return m.dicso, m.dicserr
+}
+
+func (m mockEC2) DescribeInstanceCreditSpecificationsWithContext(ctx aws.Context, in *ec2.DescribeInstanceCreditSpecificationsInput, opts ...request.Option) (*ec2.DescribeInstanceCreditSpecificationsOutput, error) {
+	return m.DescribeInstanceCreditSpecifications(in)
+}
+
+func (m mockEC2) WaitUntilInstanceRunningWithContext(ctx aws.Context, in *ec2.DescribeInstancesInput, opts ...request.WaiterOption) error {
+	return m.wuirerr
+}
+
 // All fields are composed of the abbreviation of their method
 // This is useful when methods are doing multiple calls to AWS API
 type mockASG struct {
@@ -101,6 +197,8 @@ type mockASG struct {
 	// Terminate Instances
 	tiiasgo   *autoscaling.TerminateInstanceInAutoScalingGroupOutput
 	tiiasgerr error
+	// records the inputs of the calls when set
+	tiiasgInputs *[]*autoscaling.TerminateInstanceInAutoScalingGroupInput
 	// Attach Instances
 	aio   *autoscaling.AttachInstancesOutput
 	aierr error
@@ -110,6 +208,8 @@ type mockASG struct {
 	// Update AutoScaling Group
 	uasgo   *autoscaling.UpdateAutoScalingGroupOutput
 	uasgerr error
+	// records the inputs of the calls when set
+	uasgInputs *[]*autoscaling.UpdateAutoScalingGroupInput
 	// Describe Tags
 	dto *autoscaling.DescribeTagsOutput
 
@@ -121,31 +221,75 @@ type mockASG struct {
 	dasio   *autoscaling.DescribeAutoScalingInstancesOutput
 	dasierr error
 
+	// Describe AutoScalingInstances, successive outputs returned on each call,
+	// the last one being repeated once the list is exhausted
+	dasios    []*autoscaling.DescribeAutoScalingInstancesOutput
+	dasiCalls *int
+
 	// DescribeLifecycleHooks
 	dlho   *autoscaling.DescribeLifecycleHooksOutput
 	dlherr error
+
+	// Enter Standby
+	esbo   *autoscaling.EnterStandbyOutput
+	esberr error
+
+	// Exit Standby
+	exsbo   *autoscaling.ExitStandbyOutput
+	exsberr error
+
+	// Create Or Update Tags
+	coutierr error
+	// records the inputs of the calls when set
+	coutiInputs *[]*autoscaling.CreateOrUpdateTagsInput
 }
 
 func (m mockASG) DetachInstances(*autoscaling.DetachInstancesInput) (*autoscaling.DetachInstancesOutput, error) {
 	return m.dio, m.dierr
 }
 
-func (m mockASG) TerminateInstanceInAutoScalingGroup(*autoscaling.TerminateInstanceInAutoScalingGroupInput) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+func (m mockASG) DetachInstancesWithContext(ctx aws.Context, in *autoscaling.DetachInstancesInput, opts ...request.Option) (*autoscaling.DetachInstancesOutput, error) {
+	return m.DetachInstances(in)
+}
+
+func (m mockASG) TerminateInstanceInAutoScalingGroup(in *autoscaling.TerminateInstanceInAutoScalingGroupInput) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+	if m.tiiasgInputs != nil {
+		*m.tiiasgInputs = append(*m.tiiasgInputs, in)
+	}
 	return m.tiiasgo, m.tiiasgerr
 }
 
+func (m mockASG) TerminateInstanceInAutoScalingGroupWithContext(ctx aws.Context, in *autoscaling.TerminateInstanceInAutoScalingGroupInput, opts ...request.Option) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+	return m.TerminateInstanceInAutoScalingGroup(in)
+}
+
 func (m mockASG) AttachInstances(*autoscaling.AttachInstancesInput) (*autoscaling.AttachInstancesOutput, error) {
 	return m.aio, m.aierr
 }
 
+func (m mockASG) AttachInstancesWithContext(ctx aws.Context, in *autoscaling.AttachInstancesInput, opts ...request.Option) (*autoscaling.AttachInstancesOutput, error) {
+	return m.AttachInstances(in)
+}
+
 func (m mockASG) DescribeLaunchConfigurations(*autoscaling.DescribeLaunchConfigurationsInput) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
 	return m.dlco, m.dlcerr
 }
 
-func (m mockASG) UpdateAutoScalingGroup(*autoscaling.UpdateAutoScalingGroupInput) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+func (m mockASG) DescribeLaunchConfigurationsWithContext(ctx aws.Context, in *autoscaling.DescribeLaunchConfigurationsInput, opts ...request.Option) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
+	return m.DescribeLaunchConfigurations(in)
+}
+
+func (m mockASG) UpdateAutoScalingGroup(in *autoscaling.UpdateAutoScalingGroupInput) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+	if m.uasgInputs != nil {
+		*m.uasgInputs = append(*m.uasgInputs, in)
+	}
 	return m.uasgo, m.uasgerr
 }
 
+func (m mockASG) UpdateAutoScalingGroupWithContext(ctx aws.Context, in *autoscaling.UpdateAutoScalingGroupInput, opts ...request.Option) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+	return m.UpdateAutoScalingGroup(in)
+}
+
 func (m mockASG) DescribeTagsPages(input *autoscaling.DescribeTagsInput, function func(*autoscaling.DescribeTagsOutput, bool) bool) error {
 	function(m.dto, true)
 	return nil
@@ -155,19 +299,70 @@ func (m mockASG) DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalin
 	return m.dasgo, m.dasgerr
 }
 
+func (m mockASG) DescribeAutoScalingGroupsWithContext(ctx aws.Context, input *autoscaling.DescribeAutoScalingGroupsInput, opts ...request.Option) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	return m.DescribeAutoScalingGroups(input)
+}
+
 func (m mockASG) DescribeAutoScalingGroupsPages(input *autoscaling.DescribeAutoScalingGroupsInput, function func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool) error {
 	function(m.dasgo, true)
 	return nil
 }
 
+func (m mockASG) DescribeAutoScalingGroupsPagesWithContext(ctx aws.Context, input *autoscaling.DescribeAutoScalingGroupsInput, function func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool, opts ...request.Option) error {
+	return m.DescribeAutoScalingGroupsPages(input, function)
+}
+
 func (m mockASG) DescribeAutoScalingInstances(inout *autoscaling.DescribeAutoScalingInstancesInput) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+	if len(m.dasios) > 0 && m.dasiCalls != nil {
+		i := *m.dasiCalls
+		if i >= len(m.dasios) {
+			i = len(m.dasios) - 1
+		}
+		*m.dasiCalls++
+		return m.dasios[i], m.dasierr
+	}
 	return m.dasio, m.dasierr
 }
 
+func (m mockASG) DescribeAutoScalingInstancesWithContext(ctx aws.Context, in *autoscaling.DescribeAutoScalingInstancesInput, opts ...request.Option) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+	return m.DescribeAutoScalingInstances(in)
+}
+
 func (m mockASG) DescribeLifecycleHooks(*autoscaling.DescribeLifecycleHooksInput) (*autoscaling.DescribeLifecycleHooksOutput, error) {
 	return m.dlho, m.dlherr
 }
 
+func (m mockASG) DescribeLifecycleHooksWithContext(ctx aws.Context, in *autoscaling.DescribeLifecycleHooksInput, opts ...request.Option) (*autoscaling.DescribeLifecycleHooksOutput, error) {
+	return m.DescribeLifecycleHooks(in)
+}
+
+func (m mockASG) EnterStandby(*autoscaling.EnterStandbyInput) (*autoscaling.EnterStandbyOutput, error) {
+	return m.esbo, m.esberr
+}
+
+func (m mockASG) EnterStandbyWithContext(ctx aws.Context, in *autoscaling.EnterStandbyInput, opts ...request.Option) (*autoscaling.EnterStandbyOutput, error) {
+	return m.EnterStandby(in)
+}
+
+func (m mockASG) CreateOrUpdateTags(in *autoscaling.CreateOrUpdateTagsInput) (*autoscaling.CreateOrUpdateTagsOutput, error) {
+	if m.coutiInputs != nil {
+		*m.coutiInputs = append(*m.coutiInputs, in)
+	}
+	return &autoscaling.CreateOrUpdateTagsOutput{}, m.coutierr
+}
+
+func (m mockASG) CreateOrUpdateTagsWithContext(ctx aws.Context, in *autoscaling.CreateOrUpdateTagsInput, opts ...request.Option) (*autoscaling.CreateOrUpdateTagsOutput, error) {
+	return m.CreateOrUpdateTags(in)
+}
+
+func (m mockASG) ExitStandby(*autoscaling.ExitStandbyInput) (*autoscaling.ExitStandbyOutput, error) {
+	return m.exsbo, m.exsberr
+}
+
+func (m mockASG) ExitStandbyWithContext(ctx aws.Context, in *autoscaling.ExitStandbyInput, opts ...request.Option) (*autoscaling.ExitStandbyOutput, error) {
+	return m.ExitStandby(in)
+}
+
 // All fields are composed of the abbreviation of their method
 // This is useful when methods are doing multiple calls to AWS API
 type mockCloudFormation struct {
@@ -0,0 +1,160 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// NetworkCompatibilityAuto enforces the network capacity of the replaced
+	// instances only for network-sensitive groups, such as Kubernetes nodes
+	// using the VPC CNI plugin or instances with multiple network interfaces.
+	NetworkCompatibilityAuto = "auto"
+
+	// NetworkCompatibilityStrict always requires spot instances to have at
+	// least the network bandwidth, number of network interfaces and IP
+	// addresses per interface of the replaced instances.
+	NetworkCompatibilityStrict = "strict"
+
+	// NetworkCompatibilityOff disables the network capacity checks.
+	NetworkCompatibilityOff = "off"
+
+	// DefaultNetworkCompatibility is the default network compatibility mode
+	DefaultNetworkCompatibility = NetworkCompatibilityAuto
+)
+
+// Tags set on the groups managed by Kubernetes, whose pods get IP addresses
+// from the node's network interfaces when using the VPC CNI plugin.
+var kubernetesGroupTagPrefixes = []string{
+	"kubernetes.io/cluster/",
+	"k8s.io/cluster-autoscaler/",
+	"eks:cluster-name",
+}
+
+// The qualitative network performance values used for the older instance
+// types, converted to an approximate bandwidth in Gbit/s.
+var networkPerformanceLevels = map[string]float64{
+	"very low":        0.05,
+	"low":             0.3,
+	"low to moderate": 0.5,
+	"moderate":        0.75,
+	"high":            1,
+}
+
+var networkBandwidthRegexp = regexp.MustCompile(`^(up to )?([0-9.]+) gigabit$`)
+
+// networkBandwidth converts the network performance of an instance type, such
+// as "Moderate", "Up to 10 Gigabit" or "25 Gigabit", to a bandwidth in Gbit/s
+// that can be compared. The burstable bandwidth is only counted as half, since
+// the baseline is usually much lower. It returns zero for unknown values.
+func networkBandwidth(performance string) float64 {
+	performance = strings.ToLower(strings.TrimSpace(performance))
+
+	if level, ok := networkPerformanceLevels[performance]; ok {
+		return level
+	}
+
+	matches := networkBandwidthRegexp.FindStringSubmatch(performance)
+	if matches == nil {
+		return 0
+	}
+
+	bandwidth, err := strconv.ParseFloat(matches[2], 64)
+	if err != nil {
+		return 0
+	}
+
+	if matches[1] != "" {
+		return bandwidth / 2
+	}
+	return bandwidth
+}
+
+// isNetworkSensitive returns true for the groups whose instances may be using
+// more network interfaces than the ones they were launched with, which is the
+// case of the Kubernetes nodes using the VPC CNI plugin, or groups with
+// instances having multiple network interfaces attached.
+func (a *autoScalingGroup) isNetworkSensitive() bool {
+	for _, tag := range a.Tags {
+		for _, prefix := range kubernetesGroupTagPrefixes {
+			if tag.Key != nil && strings.HasPrefix(*tag.Key, prefix) {
+				return true
+			}
+		}
+	}
+
+	for i := range a.instances.instances() {
+		if len(i.NetworkInterfaces) > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// requiresNetworkCapacity determines if the spot instances replacing this
+// instance need to match its network capacity, according to the network
+// compatibility mode configured for the group.
+func (i *instance) requiresNetworkCapacity() bool {
+	if i.asg == nil {
+		return false
+	}
+
+	switch i.asg.config.NetworkCompatibility {
+	case NetworkCompatibilityStrict:
+		return true
+	case NetworkCompatibilityOff:
+		return false
+	default:
+		return i.asg.isNetworkSensitive()
+	}
+}
+
+// isNetworkCapacityCompatible checks that the candidate instance type can have
+// all the network interfaces currently attached to the instance. When the
+// network capacity is required, it also needs to have at least as many
+// network interfaces, IP addresses per interface and network bandwidth. The
+// checks are skipped when the data is missing for either instance type.
+func (i *instance) isNetworkCapacityCompatible(spotCandidate instanceTypeInformation, requireCapacity bool) bool {
+	if i.asg != nil && i.asg.config.NetworkCompatibility == NetworkCompatibilityOff {
+		return true
+	}
+
+	current := i.typeInfo
+
+	if spotCandidate.maxENIs > 0 && len(i.NetworkInterfaces) > spotCandidate.maxENIs {
+		debug.Println("\tNot network compatible, the instance has", len(i.NetworkInterfaces),
+			"network interfaces but", spotCandidate.instanceType, "supports only", spotCandidate.maxENIs)
+		return false
+	}
+
+	if !requireCapacity {
+		return true
+	}
+
+	debug.Println("Comparing network capacity spot/instance:")
+	debug.Println("\tSpot ENIs/IPs per ENI/performance: ", spotCandidate.maxENIs,
+		" / ", spotCandidate.ipv4PerENI, " / ", spotCandidate.networkPerformance)
+	debug.Println("\tInstance ENIs/IPs per ENI/performance: ", current.maxENIs,
+		" / ", current.ipv4PerENI, " / ", current.networkPerformance)
+
+	if spotCandidate.maxENIs > 0 && spotCandidate.maxENIs < current.maxENIs {
+		debug.Println("\tNot network compatible, fewer network interfaces")
+		return false
+	}
+
+	if spotCandidate.ipv4PerENI > 0 && spotCandidate.ipv4PerENI < current.ipv4PerENI {
+		debug.Println("\tNot network compatible, fewer IP addresses per network interface")
+		return false
+	}
+
+	candidateBandwidth := networkBandwidth(spotCandidate.networkPerformance)
+	if candidateBandwidth > 0 && candidateBandwidth < networkBandwidth(current.networkPerformance) {
+		debug.Println("\tNot network compatible, lower network performance")
+		return false
+	}
+	return true
+}
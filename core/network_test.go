@@ -0,0 +1,191 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_networkBandwidth(t *testing.T) {
+	tests := map[string]float64{
+		"":                 0,
+		"Very Low":         0.05,
+		"Moderate":         0.75,
+		"High":             1,
+		"10 Gigabit":       10,
+		"25 Gigabit":       25,
+		"Up to 10 Gigabit": 5,
+		"up to 25 gigabit": 12.5,
+		"Something else":   0,
+	}
+
+	for performance, expected := range tests {
+		if got := networkBandwidth(performance); got != expected {
+			t.Errorf("networkBandwidth(%q) = %v, expected %v", performance, got, expected)
+		}
+	}
+}
+
+func Test_autoScalingGroup_isNetworkSensitive(t *testing.T) {
+	tests := []struct {
+		name      string
+		tags      []*autoscaling.TagDescription
+		instances instanceMap
+		expected  bool
+	}{
+		{
+			name:      "regular group",
+			tags:      []*autoscaling.TagDescription{{Key: aws.String("spot-enabled"), Value: aws.String("true")}},
+			instances: instanceMap{"i-1": {Instance: &ec2.Instance{NetworkInterfaces: []*ec2.InstanceNetworkInterface{{}}}}},
+			expected:  false,
+		},
+		{
+			name:      "Kubernetes node group",
+			tags:      []*autoscaling.TagDescription{{Key: aws.String("kubernetes.io/cluster/prod"), Value: aws.String("owned")}},
+			instances: instanceMap{},
+			expected:  true,
+		},
+		{
+			name: "instances with multiple network interfaces",
+			instances: instanceMap{"i-1": {Instance: &ec2.Instance{
+				NetworkInterfaces: []*ec2.InstanceNetworkInterface{{}, {}},
+			}}},
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:     &autoscaling.Group{Tags: tt.tags},
+				instances: makeInstancesWithCatalog(tt.instances),
+			}
+			if got := a.isNetworkSensitive(); got != tt.expected {
+				t.Errorf("isNetworkSensitive() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_requiresNetworkCapacity(t *testing.T) {
+	kubernetes := []*autoscaling.TagDescription{{Key: aws.String("eks:cluster-name"), Value: aws.String("prod")}}
+
+	tests := []struct {
+		name     string
+		mode     string
+		tags     []*autoscaling.TagDescription
+		expected bool
+	}{
+		{name: "auto on a regular group", mode: NetworkCompatibilityAuto, expected: false},
+		{name: "auto on a Kubernetes group", mode: NetworkCompatibilityAuto, tags: kubernetes, expected: true},
+		{name: "strict", mode: NetworkCompatibilityStrict, expected: true},
+		{name: "off on a Kubernetes group", mode: NetworkCompatibilityOff, tags: kubernetes, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:     &autoscaling.Group{Tags: tt.tags},
+				instances: makeInstances(),
+				config:    AutoScalingConfig{NetworkCompatibility: tt.mode},
+			}
+			i := &instance{Instance: &ec2.Instance{}, asg: a}
+			if got := i.requiresNetworkCapacity(); got != tt.expected {
+				t.Errorf("requiresNetworkCapacity() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+
+	if (&instance{}).requiresNetworkCapacity() {
+		t.Errorf("expected instances without a group to not require network capacity")
+	}
+}
+
+func Test_instance_isNetworkCapacityCompatible(t *testing.T) {
+	current := instanceTypeInformation{
+		instanceType:       "m5.xlarge",
+		maxENIs:            4,
+		ipv4PerENI:         15,
+		networkPerformance: "Up to 10 Gigabit",
+	}
+
+	tests := []struct {
+		name            string
+		mode            string
+		attachedENIs    int
+		candidate       instanceTypeInformation
+		requireCapacity bool
+		expected        bool
+	}{
+		{
+			name:            "enough capacity",
+			candidate:       instanceTypeInformation{maxENIs: 4, ipv4PerENI: 15, networkPerformance: "10 Gigabit"},
+			requireCapacity: true,
+			expected:        true,
+		},
+		{
+			name:            "fewer network interfaces",
+			candidate:       instanceTypeInformation{maxENIs: 3, ipv4PerENI: 15, networkPerformance: "10 Gigabit"},
+			requireCapacity: true,
+			expected:        false,
+		},
+		{
+			name:            "fewer IP addresses per interface",
+			candidate:       instanceTypeInformation{maxENIs: 4, ipv4PerENI: 10, networkPerformance: "10 Gigabit"},
+			requireCapacity: true,
+			expected:        false,
+		},
+		{
+			name:            "lower network performance",
+			candidate:       instanceTypeInformation{maxENIs: 4, ipv4PerENI: 15, networkPerformance: "Moderate"},
+			requireCapacity: true,
+			expected:        false,
+		},
+		{
+			name:            "lower capacity allowed when not required",
+			candidate:       instanceTypeInformation{maxENIs: 3, ipv4PerENI: 10, networkPerformance: "Moderate"},
+			requireCapacity: false,
+			expected:        true,
+		},
+		{
+			name:            "missing data is ignored",
+			candidate:       instanceTypeInformation{},
+			requireCapacity: true,
+			expected:        true,
+		},
+		{
+			name:         "not enough interfaces for the attached ones",
+			attachedENIs: 3,
+			candidate:    instanceTypeInformation{maxENIs: 2, ipv4PerENI: 15},
+			expected:     false,
+		},
+		{
+			name:            "disabled checks",
+			mode:            NetworkCompatibilityOff,
+			attachedENIs:    3,
+			candidate:       instanceTypeInformation{maxENIs: 2, ipv4PerENI: 10},
+			requireCapacity: true,
+			expected:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					NetworkInterfaces: make([]*ec2.InstanceNetworkInterface, tt.attachedENIs),
+				},
+				typeInfo: current,
+				asg:      &autoScalingGroup{config: AutoScalingConfig{NetworkCompatibility: tt.mode}},
+			}
+			if got := i.isNetworkCapacityCompatible(tt.candidate, tt.requireCapacity); got != tt.expected {
+				t.Errorf("isNetworkCapacityCompatible() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
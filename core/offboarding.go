@@ -0,0 +1,159 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// Offboard starts off-boarding the AutoScaling groups given in the
+// configuration, by tagging them in all the targeted regions. The following
+// runs then gradually replace their spot instances with on-demand ones.
+func Offboard(cfg *Config) {
+
+	setupLogging(cfg)
+
+	names := splitTargetList(cfg.OffboardAutoScalingGroups)
+
+	regions, err := targetRegions(cfg)
+	if err != nil {
+		logger.Println(err.Error())
+		return
+	}
+
+	for _, name := range regions {
+		r := region{name: name, conf: cfg}
+		if !r.enabled() {
+			continue
+		}
+		r.services.connect(r.name, r.conf)
+
+		for _, asgName := range r.startOffboarding(names) {
+			logger.Println("Started off-boarding group", asgName, "in", r.name)
+		}
+	}
+}
+
+// startOffboarding tags the given groups existing in the region so they are
+// off-boarded, and returns the names of the tagged groups.
+func (r *region) startOffboarding(names []string) []string {
+
+	resp, err := r.services.autoScaling.DescribeAutoScalingGroups(
+		&autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: aws.StringSlice(names),
+		})
+	if err != nil {
+		logger.Println("Failed to describe AutoScalingGroups in", r.name, err.Error())
+		return nil
+	}
+
+	var tagged []string
+	for _, group := range resp.AutoScalingGroups {
+		_, err := r.services.autoScaling.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{
+			Tags: []*autoscaling.Tag{{
+				ResourceId:        group.AutoScalingGroupName,
+				ResourceType:      aws.String("auto-scaling-group"),
+				Key:               aws.String(OffboardingTag),
+				Value:             aws.String("true"),
+				PropagateAtLaunch: aws.Bool(false),
+			}},
+		})
+		if err != nil {
+			logger.Println("Failed to tag group", *group.AutoScalingGroupName,
+				"for off-boarding in", r.name, err.Error())
+			continue
+		}
+		tagged = append(tagged, *group.AutoScalingGroupName)
+	}
+	return tagged
+}
+
+// isOffboardingGroup checks if the group is tagged to be off-boarded.
+func isOffboardingGroup(group *autoscaling.Group) bool {
+	return getTagValueFromASGWithMatchingTag(group,
+		Tag{Key: OffboardingTag, Value: "true"}) != nil
+}
+
+// offboard replaces some of the spot instances of the group with on-demand
+// ones, by terminating them without decrementing the desired capacity so the
+// group launches on-demand instances instead. No more than the configured
+// number of instances are unavailable at the same time, the next spot
+// instances being terminated by the following runs once the group is healthy.
+func (a *autoScalingGroup) offboard(ctx context.Context) {
+
+	spotInstances := a.offboardingCandidates()
+	if len(spotInstances) == 0 {
+		logger.Println(a.region.name, a.name, "Off-boarding completed, no spot",
+			"instances are left, the tag", OffboardingTag, "can be removed")
+		return
+	}
+
+	unavailable := a.unavailableInstanceCount()
+	budget := a.config.MaxUnavailable - unavailable
+	if budget <= 0 {
+		logger.Println(a.region.name, a.name, "Off-boarding waits for", unavailable,
+			"unavailable instances to be replaced and healthy")
+		return
+	}
+
+	logger.Println(a.region.name, a.name, "Off-boarding", len(spotInstances),
+		"spot instances,", budget, "of them in this run")
+
+	for i, id := range spotInstances {
+		if int64(i) >= budget ||
+			!a.enoughTimeLeft(ctx, "off-boarding spot instance "+id+" of group "+a.name) {
+			return
+		}
+		a.terminateInstanceKeepingCapacity(ctx, aws.String(id))
+	}
+}
+
+// offboardingCandidates returns the spot instances of the group in service,
+// sorted by their ID so the runs proceed in a predictable order.
+func (a *autoScalingGroup) offboardingCandidates() []string {
+	var ids []string
+	for _, inst := range a.Instances {
+		if aws.StringValue(inst.LifecycleState) != autoscaling.LifecycleStateInService {
+			continue
+		}
+		if i := a.instances.get(aws.StringValue(inst.InstanceId)); i != nil && i.isSpot() {
+			ids = append(ids, *i.InstanceId)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// unavailableInstanceCount returns how many instances are missing from the
+// group's desired capacity or are not yet in service and healthy, or how many
+// instances are still launching or terminating if there are more of them.
+func (a *autoScalingGroup) unavailableInstanceCount() int64 {
+	var healthy, transitioning int64
+	for _, inst := range a.Instances {
+		state := aws.StringValue(inst.LifecycleState)
+
+		switch {
+		case state == autoscaling.LifecycleStateInService &&
+			strings.EqualFold(aws.StringValue(inst.HealthStatus), "Healthy"):
+			healthy++
+		case strings.HasPrefix(state, autoscaling.LifecycleStatePending),
+			strings.HasPrefix(state, autoscaling.LifecycleStateTerminating):
+			transitioning++
+		}
+	}
+
+	unavailable := aws.Int64Value(a.DesiredCapacity) - healthy
+	if transitioning > unavailable {
+		return transitioning
+	}
+	if unavailable > 0 {
+		return unavailable
+	}
+	return 0
+}
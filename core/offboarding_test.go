@@ -0,0 +1,288 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func offboardingTestGroup(desired int64, members ...*autoscaling.Instance) *autoScalingGroup {
+	catalog := instanceMap{}
+	for _, m := range members {
+		inst := &instance{Instance: &ec2.Instance{InstanceId: m.InstanceId}}
+		if aws.StringValue(m.InstanceId)[:6] == "i-spot" {
+			inst.InstanceLifecycle = aws.String("spot")
+		}
+		catalog[*m.InstanceId] = inst
+	}
+
+	return &autoScalingGroup{
+		Group: &autoscaling.Group{
+			DesiredCapacity: aws.Int64(desired),
+			Instances:       members,
+		},
+		name:      "asg-test",
+		instances: makeInstancesWithCatalog(catalog),
+	}
+}
+
+func groupMember(id, lifecycleState, healthStatus string) *autoscaling.Instance {
+	return &autoscaling.Instance{
+		InstanceId:     aws.String(id),
+		LifecycleState: aws.String(lifecycleState),
+		HealthStatus:   aws.String(healthStatus),
+	}
+}
+
+func Test_autoScalingGroup_offboardingCandidates(t *testing.T) {
+
+	a := offboardingTestGroup(4,
+		groupMember("i-spot-2", autoscaling.LifecycleStateInService, "Healthy"),
+		groupMember("i-ondemand", autoscaling.LifecycleStateInService, "Healthy"),
+		groupMember("i-spot-1", autoscaling.LifecycleStateInService, "Unhealthy"),
+		groupMember("i-spot-3", autoscaling.LifecycleStateTerminating, "Healthy"),
+	)
+
+	want := []string{"i-spot-1", "i-spot-2"}
+	if got := a.offboardingCandidates(); !reflect.DeepEqual(got, want) {
+		t.Errorf("offboardingCandidates() = %v, want %v", got, want)
+	}
+}
+
+func Test_autoScalingGroup_unavailableInstanceCount(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		desired int64
+		members []*autoscaling.Instance
+		want    int64
+	}{
+		{
+			name:    "All in service and healthy",
+			desired: 2,
+			members: []*autoscaling.Instance{
+				groupMember("i-spot-1", autoscaling.LifecycleStateInService, "Healthy"),
+				groupMember("i-ondemand", autoscaling.LifecycleStateInService, "Healthy"),
+			},
+			want: 0,
+		},
+		{
+			name:    "Replacement still pending",
+			desired: 2,
+			members: []*autoscaling.Instance{
+				groupMember("i-ondemand-1", autoscaling.LifecycleStatePending, "Healthy"),
+				groupMember("i-ondemand-2", autoscaling.LifecycleStateInService, "Healthy"),
+			},
+			want: 1,
+		},
+		{
+			name:    "Missing and unhealthy instances",
+			desired: 3,
+			members: []*autoscaling.Instance{
+				groupMember("i-spot-1", autoscaling.LifecycleStateInService, "Unhealthy"),
+			},
+			want: 3,
+		},
+		{
+			name:    "Health status in upper case",
+			desired: 2,
+			members: []*autoscaling.Instance{
+				groupMember("i-spot-1", autoscaling.LifecycleStateInService, "HEALTHY"),
+				groupMember("i-ondemand", autoscaling.LifecycleStateInService, "Healthy"),
+			},
+			want: 0,
+		},
+		{
+			name:    "Replaced instance still terminating",
+			desired: 2,
+			members: []*autoscaling.Instance{
+				groupMember("i-spot-1", autoscaling.LifecycleStateTerminatingWait, "Unhealthy"),
+				groupMember("i-ondemand-1", autoscaling.LifecycleStateInService, "Healthy"),
+				groupMember("i-ondemand-2", autoscaling.LifecycleStateInService, "Healthy"),
+			},
+			want: 1,
+		},
+		{
+			name:    "Replacement pending while the replaced instance is terminating",
+			desired: 2,
+			members: []*autoscaling.Instance{
+				groupMember("i-spot-1", autoscaling.LifecycleStateTerminating, "Unhealthy"),
+				groupMember("i-ondemand-1", autoscaling.LifecycleStatePendingWait, "Healthy"),
+				groupMember("i-ondemand-2", autoscaling.LifecycleStateInService, "Healthy"),
+			},
+			want: 2,
+		},
+		{
+			name:    "More instances than desired",
+			desired: 1,
+			members: []*autoscaling.Instance{
+				groupMember("i-spot-1", autoscaling.LifecycleStateInService, "Healthy"),
+				groupMember("i-spot-2", autoscaling.LifecycleStateInService, "Healthy"),
+			},
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := offboardingTestGroup(tt.desired, tt.members...)
+			if got := a.unavailableInstanceCount(); got != tt.want {
+				t.Errorf("unavailableInstanceCount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_offboard(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		members        []*autoscaling.Instance
+		maxUnavailable int64
+		wantTerminated []string
+	}{
+		{
+			name: "Within the budget",
+			members: []*autoscaling.Instance{
+				groupMember("i-spot-2", autoscaling.LifecycleStateInService, "Healthy"),
+				groupMember("i-spot-1", autoscaling.LifecycleStateInService, "Healthy"),
+				groupMember("i-spot-3", autoscaling.LifecycleStateInService, "Healthy"),
+			},
+			maxUnavailable: 2,
+			wantTerminated: []string{"i-spot-1", "i-spot-2"},
+		},
+		{
+			name: "Budget used by a terminating instance",
+			members: []*autoscaling.Instance{
+				groupMember("i-spot-1", autoscaling.LifecycleStateInService, "Healthy"),
+				groupMember("i-spot-2", autoscaling.LifecycleStateInService, "Healthy"),
+				groupMember("i-spot-3", autoscaling.LifecycleStateInService, "Healthy"),
+				groupMember("i-spot-4", autoscaling.LifecycleStateTerminating, "Unhealthy"),
+			},
+			maxUnavailable: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inputs []*autoscaling.TerminateInstanceInAutoScalingGroupInput
+
+			a := offboardingTestGroup(3, tt.members...)
+			a.config.MaxUnavailable = tt.maxUnavailable
+			a.region = &region{
+				name:     "us-east-1",
+				conf:     &Config{},
+				services: connections{autoScaling: mockASG{tiiasgInputs: &inputs}},
+			}
+
+			a.offboard(context.Background())
+
+			var terminated []string
+			for _, in := range inputs {
+				if aws.BoolValue(in.ShouldDecrementDesiredCapacity) {
+					t.Errorf("terminated %s decrementing the desired capacity", *in.InstanceId)
+				}
+				terminated = append(terminated, *in.InstanceId)
+			}
+			if !reflect.DeepEqual(terminated, tt.wantTerminated) {
+				t.Errorf("terminated %v, want %v", terminated, tt.wantTerminated)
+			}
+		})
+	}
+}
+
+func Test_isOffboardingGroup(t *testing.T) {
+
+	tests := []struct {
+		name string
+		tags []*autoscaling.TagDescription
+		want bool
+	}{
+		{name: "No tags", want: false},
+		{
+			name: "Off-boarding",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(OffboardingTag), Value: aws.String("true")},
+			},
+			want: true,
+		},
+		{
+			name: "Off-boarding disabled",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(OffboardingTag), Value: aws.String("false")},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isOffboardingGroup(&autoscaling.Group{Tags: tt.tags}); got != tt.want {
+				t.Errorf("isOffboardingGroup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_region_startOffboarding(t *testing.T) {
+
+	groups := &autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: []*autoscaling.Group{
+			{AutoScalingGroupName: aws.String("asg-1")},
+		},
+	}
+
+	tests := []struct {
+		name string
+		asg  mockASG
+		want []string
+	}{
+		{
+			name: "Tagging the existing groups",
+			asg:  mockASG{dasgo: groups},
+			want: []string{"asg-1"},
+		},
+		{
+			name: "Failing to tag the groups",
+			asg:  mockASG{dasgo: groups, coutierr: errors.New("error")},
+		},
+		{
+			name: "Failing to describe the groups",
+			asg:  mockASG{dasgo: groups, dasgerr: errors.New("error")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{name: "us-east-1", services: connections{autoScaling: tt.asg}}
+			if got := r.startOffboarding([]string{"asg-1", "asg-2"}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("startOffboarding() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_region_findMatchingASGsInPageOfResults_offboarding(t *testing.T) {
+
+	r := &region{name: "us-east-1", conf: &Config{TagFilteringMode: "opt-in"}}
+	groups := []*autoscaling.Group{
+		{AutoScalingGroupName: aws.String("disabled")},
+		{
+			AutoScalingGroupName: aws.String("offboarding"),
+			Tags: []*autoscaling.TagDescription{
+				{Key: aws.String(OffboardingTag), Value: aws.String("true")},
+			},
+		},
+	}
+
+	asgs := r.findMatchingASGsInPageOfResults(groups,
+		[]Tag{{Key: "spot-enabled", Value: "true"}})
+
+	if len(asgs) != 1 || asgs[0].name != "offboarding" || !asgs[0].offboarding {
+		t.Errorf("findMatchingASGsInPageOfResults() = %+v, want the off-boarding group", asgs)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// onDemandTargetSeparator separates the targets of an on-demand schedule
+	onDemandTargetSeparator = "|"
+
+	// onDemandPercentageSeparator separates the schedule of a target from its
+	// on-demand percentage
+	onDemandPercentageSeparator = "="
+)
+
+// onDemandTarget is the on-demand percentage to be kept during a schedule
+type onDemandTarget struct {
+	schedule   string
+	percentage float64
+}
+
+// parseOnDemandSchedule parses a list of targets separated by "|", each made
+// of a schedule in the format accepted by cron_schedule and the on-demand
+// percentage to be kept while inside it, separated by "=". For example
+// "* 9-17 * * 1-5=40 | * * * * 6,0=0" keeps 40% on-demand capacity during the
+// business hours and none in the weekends.
+func parseOnDemandSchedule(schedule string) ([]onDemandTarget, error) {
+	var targets []onDemandTarget
+
+	for _, item := range strings.Split(schedule, onDemandTargetSeparator) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		pos := strings.LastIndex(item, onDemandPercentageSeparator)
+		if pos < 0 {
+			return nil, fmt.Errorf("invalid on-demand target %q, expected <schedule>=<percentage>", item)
+		}
+
+		crontab := strings.TrimSpace(item[:pos])
+		if _, err := parseSchedule(crontab); err != nil {
+			return nil, err
+		}
+
+		percentage, err := strconv.ParseFloat(strings.TrimSpace(item[pos+1:]), 64)
+		if err != nil || percentage < 0 || percentage > 100 {
+			return nil, fmt.Errorf("invalid on-demand percentage in %q, expected a value between 0 and 100", item)
+		}
+
+		targets = append(targets, onDemandTarget{schedule: crontab, percentage: percentage})
+	}
+	return targets, nil
+}
+
+// scheduledOnDemandPercentage returns the on-demand percentage of the first
+// target whose schedule matches the given time in the given timezone. It
+// returns false when none of them matches or the targets can't be parsed.
+func scheduledOnDemandPercentage(t time.Time, schedule string, timezone string) (float64, bool) {
+	targets, err := parseOnDemandSchedule(schedule)
+	if err != nil {
+		logger.Println(err)
+		return 0, false
+	}
+
+	for _, target := range targets {
+		inside, err := insideSchedule(t, target.schedule, timezone)
+		if err != nil {
+			return 0, false
+		}
+		if inside {
+			return target.percentage, true
+		}
+	}
+	return 0, false
+}
+
+// loadScheduledOnDemandTarget overrides the on-demand capacity of the group
+// with the percentage scheduled for the given time, if any. When the group has
+// fewer on-demand instances than the new target, its spot instances are
+// gradually replaced by on-demand ones, by terminating them without
+// decrementing the desired capacity, no more than MaxUnavailable at a time.
+func (a *autoScalingGroup) loadScheduledOnDemandTarget(t time.Time) bool {
+	if strings.TrimSpace(a.config.OnDemandPercentageSchedule) == "" {
+		return false
+	}
+
+	percentage, found := scheduledOnDemandPercentage(t,
+		a.config.OnDemandPercentageSchedule, a.config.CronTimezone)
+	if !found {
+		debug.Println(a.name, "No scheduled on-demand target matching the current time")
+		return false
+	}
+
+	instanceNumber := float64(a.instances.count())
+	a.minOnDemand = int64(math.Floor((instanceNumber * percentage / 100.0) + .5))
+	logger.Printf("%s Loaded scheduled MinOnDemand value %d for the %.2f%% on-demand target\n",
+		a.name, a.minOnDemand, percentage)
+	return true
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseOnDemandSchedule(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		schedule string
+		want     []onDemandTarget
+		wantErr  bool
+	}{
+		{
+			name:     "Single target",
+			schedule: "* 9-17 * * 1-5=40",
+			want:     []onDemandTarget{{schedule: "* 9-17 * * 1-5", percentage: 40}},
+		},
+		{
+			name:     "Multiple targets with multiple windows",
+			schedule: "* 9-17 * * 1-5; !* 12 * * *=40 | 0-8 *=0",
+			want: []onDemandTarget{
+				{schedule: "* 9-17 * * 1-5; !* 12 * * *", percentage: 40},
+				{schedule: "0-8 *", percentage: 0},
+			},
+		},
+		{
+			name:     "Missing percentage",
+			schedule: "* 9-17 * * 1-5",
+			wantErr:  true,
+		},
+		{
+			name:     "Percentage out of range",
+			schedule: "* 9-17 * * 1-5=140",
+			wantErr:  true,
+		},
+		{
+			name:     "Invalid schedule",
+			schedule: "9-17=40",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOnDemandSchedule(tt.schedule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseOnDemandSchedule() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOnDemandSchedule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_scheduledOnDemandPercentage(t *testing.T) {
+
+	schedule := "* 9-17 * * 1-5=40 | * 9-17 * * *=20 | * * * * *=0"
+
+	tests := []struct {
+		name      string
+		schedule  string
+		t         time.Time
+		timezone  string
+		want      float64
+		wantFound bool
+	}{
+		{
+			name:      "Business hours",
+			schedule:  schedule,
+			t:         time.Date(2019, time.December, 2, 10, 0, 0, 0, time.UTC), // Monday
+			timezone:  "UTC",
+			want:      40,
+			wantFound: true,
+		},
+		{
+			name:      "Weekend during the day",
+			schedule:  schedule,
+			t:         time.Date(2019, time.December, 1, 10, 0, 0, 0, time.UTC), // Sunday
+			timezone:  "UTC",
+			want:      20,
+			wantFound: true,
+		},
+		{
+			name:      "Night",
+			schedule:  schedule,
+			t:         time.Date(2019, time.December, 2, 22, 0, 0, 0, time.UTC),
+			timezone:  "UTC",
+			want:      0,
+			wantFound: true,
+		},
+		{
+			name:      "Business hours in another timezone",
+			schedule:  schedule,
+			t:         time.Date(2019, time.December, 2, 8, 0, 0, 0, time.UTC), // 10:00 in Helsinki
+			timezone:  "Europe/Helsinki",
+			want:      40,
+			wantFound: true,
+		},
+		{
+			name:      "No matching target",
+			schedule:  "* 9-17 * * 1-5=40",
+			t:         time.Date(2019, time.December, 2, 22, 0, 0, 0, time.UTC),
+			timezone:  "UTC",
+			wantFound: false,
+		},
+		{
+			name:      "Invalid schedule",
+			schedule:  "* 9-17 * * 1-5",
+			t:         time.Date(2019, time.December, 2, 10, 0, 0, 0, time.UTC),
+			timezone:  "UTC",
+			wantFound: false,
+		},
+		{
+			name:      "Invalid timezone",
+			schedule:  schedule,
+			t:         time.Date(2019, time.December, 2, 10, 0, 0, 0, time.UTC),
+			timezone:  "Europe/Nowhere",
+			wantFound: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := scheduledOnDemandPercentage(tt.t, tt.schedule, tt.timezone)
+			if found != tt.wantFound || got != tt.want {
+				t.Errorf("scheduledOnDemandPercentage() = %v, %v, want %v, %v",
+					got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadScheduledOnDemandTarget(t *testing.T) {
+
+	monday := time.Date(2019, time.December, 2, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		schedule    string
+		minOnDemand int64
+		want        int64
+		wantLoaded  bool
+	}{
+		{
+			name:        "No schedule keeps the configured value",
+			minOnDemand: 2,
+			want:        2,
+			wantLoaded:  false,
+		},
+		{
+			name:        "Matching target overrides the configured value",
+			schedule:    "* 9-17 * * 1-5=40 | * * * * *=0",
+			minOnDemand: 0,
+			want:        2,
+			wantLoaded:  true,
+		},
+		{
+			name:        "Matching target lowering the on-demand capacity",
+			schedule:    "* 9-17 * * 6,0=40 | * * * * *=0",
+			minOnDemand: 3,
+			want:        0,
+			wantLoaded:  true,
+		},
+		{
+			name:        "No matching target keeps the configured value",
+			schedule:    "* 20-23 * * *=100",
+			minOnDemand: 1,
+			want:        1,
+			wantLoaded:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name: "asg-test",
+				instances: makeInstancesWithCatalog(
+					instanceMap{
+						"id-1": {},
+						"id-2": {},
+						"id-3": {},
+						"id-4": {},
+						"id-5": {},
+					},
+				),
+				minOnDemand: tt.minOnDemand,
+				config: AutoScalingConfig{
+					OnDemandPercentageSchedule: tt.schedule,
+					CronTimezone:               "UTC",
+				},
+			}
+			loaded := a.loadScheduledOnDemandTarget(monday)
+			if loaded != tt.wantLoaded || a.minOnDemand != tt.want {
+				t.Errorf("loadScheduledOnDemandTarget() = %v with minOnDemand %d, want %v with %d",
+					loaded, a.minOnDemand, tt.wantLoaded, tt.want)
+			}
+		})
+	}
+}
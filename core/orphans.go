@@ -0,0 +1,178 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// OrphanedInstancesCleanupTerminate terminates the spot instances launched
+	// by AutoSpotting which are no longer going to be attached to any group
+	OrphanedInstancesCleanupTerminate = "terminate"
+
+	// OrphanedInstancesCleanupDryRun only lists the orphaned spot instances
+	// that would be terminated
+	OrphanedInstancesCleanupDryRun = "dry-run"
+
+	// OrphanedInstancesCleanupOff leaves the orphaned spot instances running
+	OrphanedInstancesCleanupOff = "off"
+
+	// DefaultOrphanedInstancesCleanup is the default orphaned spot instances
+	// clean-up mode, only listing them until the termination is enabled
+	DefaultOrphanedInstancesCleanup = OrphanedInstancesCleanupDryRun
+
+	// DefaultOrphanedInstanceMaxAge is the default time after which the spot
+	// instances still not attached to their group are considered orphaned
+	DefaultOrphanedInstanceMaxAge = time.Hour
+)
+
+// orphanedInstance is a spot instance launched by AutoSpotting which is no
+// longer going to be attached to its group.
+type orphanedInstance struct {
+	id      string
+	asgName string
+	reason  string
+}
+
+// cleanUpOrphanedInstances terminates the spot instances launched by
+// AutoSpotting that were never attached to their group, because the group was
+// deleted or disabled in the meantime, or because the run attaching them
+// failed. In dry-run mode they're only listed.
+func (r *region) cleanUpOrphanedInstances(ctx context.Context, now time.Time) error {
+
+	if r.autoScalingGroups == nil {
+		return fmt.Errorf("the AutoScaling groups of %s are unknown", r.name)
+	}
+
+	orphans, err := r.findOrphanedInstances(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	if len(orphans) == 0 {
+		debug.Println("No orphaned spot instances were found in", r.name)
+		return nil
+	}
+
+	dryRun := r.conf.OrphanedInstancesCleanup == OrphanedInstancesCleanupDryRun
+
+	var ids []*string
+	for _, o := range orphans {
+		if dryRun {
+			logger.Println(r.name, "Would terminate the orphaned spot instance", o.id,
+				"launched for group", o.asgName, "because", o.reason)
+			continue
+		}
+		logger.Println(r.name, "Terminating the orphaned spot instance", o.id,
+			"launched for group", o.asgName, "because", o.reason)
+		ids = append(ids, aws.String(o.id))
+	}
+
+	if len(ids) == 0 || !enoughTimeLeft(ctx, r.conf.DeadlineSafetyMargin,
+		fmt.Sprintf("terminating %d orphaned spot instances in %s", len(ids), r.name)) {
+		return nil
+	}
+
+	_, err = r.services.ec2.TerminateInstancesWithContext(ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: ids,
+	})
+	return err
+}
+
+// findOrphanedInstances returns the running spot instances launched by
+// AutoSpotting that aren't attached to any group and aren't going to be.
+func (r *region) findOrphanedInstances(ctx context.Context, now time.Time) ([]orphanedInstance, error) {
+
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name: aws.String("instance-state-name"),
+				Values: []*string{
+					aws.String(ec2.InstanceStateNameRunning),
+					aws.String(ec2.InstanceStateNamePending),
+				},
+			},
+			{
+				Name:   aws.String("instance-lifecycle"),
+				Values: []*string{aws.String(ec2.InstanceLifecycleSpot)},
+			},
+			{
+				Name:   aws.String("tag:launched-by-autospotting"),
+				Values: []*string{aws.String("true")},
+			},
+		},
+	}
+
+	var orphans []orphanedInstance
+
+	err := r.services.ec2.DescribeInstancesPagesWithContext(ctx, input,
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, res := range page.Reservations {
+				for _, inst := range res.Instances {
+					if o := r.orphanedInstance(inst, now); o != nil {
+						orphans = append(orphans, *o)
+					}
+				}
+			}
+			return true
+		})
+
+	return orphans, err
+}
+
+// orphanedInstance returns the details of the instance if it's orphaned, or
+// nil if it's attached to a group or may still be attached to its group.
+func (r *region) orphanedInstance(inst *ec2.Instance, now time.Time) *orphanedInstance {
+
+	var asgName string
+	for _, tag := range inst.Tags {
+		switch aws.StringValue(tag.Key) {
+		case autoScalingGroupNameTag:
+			// already attached to a group
+			return nil
+		case "launched-for-asg":
+			asgName = aws.StringValue(tag.Value)
+		}
+	}
+
+	id := aws.StringValue(inst.InstanceId)
+	if r.isAttachedInstance(id) {
+		return nil
+	}
+
+	if asgName != "" {
+		if _, exists := r.autoScalingGroups[asgName]; !exists {
+			return &orphanedInstance{id: id, asgName: asgName,
+				reason: "the group no longer exists"}
+		}
+		if !r.isEnabledGroup(r.autoScalingGroups[asgName], r.tagsToFilterASGsBy) {
+			return &orphanedInstance{id: id, asgName: asgName,
+				reason: "the group is no longer enabled"}
+		}
+	}
+
+	maxAge := r.conf.OrphanedInstanceMaxAge
+	if maxAge > 0 && inst.LaunchTime != nil && now.Sub(*inst.LaunchTime) > maxAge {
+		return &orphanedInstance{id: id, asgName: asgName,
+			reason: fmt.Sprintf("it wasn't attached for more than %v", maxAge)}
+	}
+	return nil
+}
+
+func (r *region) isAttachedInstance(id string) bool {
+	for _, group := range r.autoScalingGroups {
+		for _, inst := range group.Instances {
+			if aws.StringValue(inst.InstanceId) == id {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func launchedSpotInstance(id string, launchTime time.Time, tags map[string]string) *ec2.Instance {
+	inst := &ec2.Instance{
+		InstanceId: aws.String(id),
+		LaunchTime: aws.Time(launchTime),
+		Tags: []*ec2.Tag{
+			{Key: aws.String("launched-by-autospotting"), Value: aws.String("true")},
+		},
+	}
+	for k, v := range tags {
+		inst.Tags = append(inst.Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return inst
+}
+
+func orphansTestRegion() *region {
+	return &region{
+		name: "us-east-1",
+		conf: &Config{
+			OrphanedInstanceMaxAge: time.Hour,
+		},
+		tagsToFilterASGsBy: []Tag{{Key: "spot-enabled", Value: "true"}},
+		autoScalingGroups: map[string]*autoscaling.Group{
+			"enabled": {
+				AutoScalingGroupName: aws.String("enabled"),
+				Instances: []*autoscaling.Instance{
+					{InstanceId: aws.String("i-attached")},
+				},
+				Tags: []*autoscaling.TagDescription{
+					{Key: aws.String("spot-enabled"), Value: aws.String("true")},
+				},
+			},
+			"disabled": {
+				AutoScalingGroupName: aws.String("disabled"),
+			},
+		},
+	}
+}
+
+func Test_region_orphanedInstance(t *testing.T) {
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	recently, long := now.Add(-10*time.Minute), now.Add(-2*time.Hour)
+
+	tests := []struct {
+		name       string
+		inst       *ec2.Instance
+		wantReason string
+	}{
+		{
+			name: "Attached to its group",
+			inst: launchedSpotInstance("i-attached", long, map[string]string{"launched-for-asg": "enabled"}),
+		},
+		{
+			name: "Attached to another group",
+			inst: launchedSpotInstance("i-other", long, map[string]string{
+				"launched-for-asg": "enabled", autoScalingGroupNameTag: "other"}),
+		},
+		{
+			name: "Recently launched for an enabled group",
+			inst: launchedSpotInstance("i-new", recently, map[string]string{"launched-for-asg": "enabled"}),
+		},
+		{
+			name:       "Unattached for too long",
+			inst:       launchedSpotInstance("i-old", long, map[string]string{"launched-for-asg": "enabled"}),
+			wantReason: "it wasn't attached for more than 1h0m0s",
+		},
+		{
+			name:       "Launched for a deleted group",
+			inst:       launchedSpotInstance("i-new", recently, map[string]string{"launched-for-asg": "deleted"}),
+			wantReason: "the group no longer exists",
+		},
+		{
+			name:       "Launched for a disabled group",
+			inst:       launchedSpotInstance("i-new", recently, map[string]string{"launched-for-asg": "disabled"}),
+			wantReason: "the group is no longer enabled",
+		},
+		{
+			name: "Recently launched without a group",
+			inst: launchedSpotInstance("i-new", recently, nil),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := orphansTestRegion()
+			got := r.orphanedInstance(tt.inst, now)
+			if tt.wantReason == "" {
+				if got != nil {
+					t.Errorf("orphanedInstance() = %+v, want nil", *got)
+				}
+				return
+			}
+			if got == nil || got.reason != tt.wantReason {
+				t.Errorf("orphanedInstance() = %+v, want reason %q", got, tt.wantReason)
+			}
+		})
+	}
+}
+
+func Test_region_cleanUpOrphanedInstances(t *testing.T) {
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	instances := &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{
+			Instances: []*ec2.Instance{
+				launchedSpotInstance("i-attached", now.Add(-2*time.Hour),
+					map[string]string{"launched-for-asg": "enabled"}),
+				launchedSpotInstance("i-orphan", now.Add(-time.Minute),
+					map[string]string{"launched-for-asg": "deleted"}),
+			},
+		}},
+	}
+
+	tests := []struct {
+		name        string
+		groups      bool
+		cleanup     string
+		ec2         mockEC2
+		wantOrphans []orphanedInstance
+		wantErr     bool
+	}{
+		{
+			name:    "Terminating the orphans",
+			groups:  true,
+			cleanup: OrphanedInstancesCleanupTerminate,
+			ec2:     mockEC2{dio: instances},
+			wantOrphans: []orphanedInstance{
+				{id: "i-orphan", asgName: "deleted", reason: "the group no longer exists"},
+			},
+		},
+		{
+			name:    "Failing to terminate the orphans",
+			groups:  true,
+			cleanup: OrphanedInstancesCleanupTerminate,
+			ec2:     mockEC2{dio: instances, tierr: errors.New("error")},
+			wantErr: true,
+		},
+		{
+			name:    "Only listing the orphans",
+			groups:  true,
+			cleanup: OrphanedInstancesCleanupDryRun,
+			ec2:     mockEC2{dio: instances, tierr: errors.New("error")},
+		},
+		{
+			name:    "Unknown groups",
+			cleanup: OrphanedInstancesCleanupTerminate,
+			ec2:     mockEC2{dio: instances},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := orphansTestRegion()
+			r.conf.OrphanedInstancesCleanup = tt.cleanup
+			r.services.ec2 = tt.ec2
+			if !tt.groups {
+				r.autoScalingGroups = nil
+			}
+
+			err := r.cleanUpOrphanedInstances(context.Background(), now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("cleanUpOrphanedInstances() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantOrphans != nil {
+				orphans, _ := r.findOrphanedInstances(context.Background(), now)
+				if !reflect.DeepEqual(orphans, tt.wantOrphans) {
+					t.Errorf("findOrphanedInstances() = %+v, want %+v", orphans, tt.wantOrphans)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	ec2instancesinfo "github.com/vkhodor/ec2-instances-info"
+)
+
+// Platform details as reported by EC2 for the running instances, based on the
+// billing information of the AMI they were launched from.
+const (
+	platformLinux                = "Linux/UNIX"
+	platformRHELBYOL             = "Red Hat BYOL Linux"
+	platformRHEL                 = "Red Hat Enterprise Linux"
+	platformSUSE                 = "SUSE Linux"
+	platformWindows              = "Windows"
+	platformWindowsSQLStandard   = "Windows with SQL Server Standard"
+	platformWindowsSQLWeb        = "Windows with SQL Server Web"
+	platformWindowsSQLEnterprise = "Windows with SQL Server Enterprise"
+	platformLinuxSQLStandard     = "Linux with SQL Server Standard"
+	platformLinuxSQLWeb          = "Linux with SQL Server Web"
+	platformLinuxSQLEnterprise   = "Linux with SQL Server Enterprise"
+)
+
+// Spot product descriptions used when querying the spot price history.
+const (
+	spotProductLinux   = "Linux/UNIX (Amazon VPC)"
+	spotProductRHEL    = "Red Hat Enterprise Linux (Amazon VPC)"
+	spotProductSUSE    = "SUSE Linux (Amazon VPC)"
+	spotProductWindows = "Windows (Amazon VPC)"
+)
+
+const (
+	usageOperationPrefix = "RunInstances"
+
+	// keeps the DescribeImages requests reasonably small
+	maxImagesPerDescribe = 100
+)
+
+// platform describes how an operating system is priced. The spot market only
+// has prices for the base operating systems, the SQL Server licenses are billed
+// on top of them, so for those platforms we also point to the base platform in
+// order to compute the license cost.
+type platform struct {
+	spotProduct string
+	base        string
+	onDemand    func(ec2instancesinfo.RegionPrices) float64
+}
+
+var platforms = map[string]platform{
+	platformLinux: {
+		spotProduct: spotProductLinux,
+		onDemand:    func(p ec2instancesinfo.RegionPrices) float64 { return p.Linux.OnDemand },
+	},
+	// the RHEL license is brought by the customer, so it's billed as Linux
+	platformRHELBYOL: {
+		spotProduct: spotProductLinux,
+		onDemand:    func(p ec2instancesinfo.RegionPrices) float64 { return p.Linux.OnDemand },
+	},
+	platformRHEL: {
+		spotProduct: spotProductRHEL,
+		onDemand:    func(p ec2instancesinfo.RegionPrices) float64 { return p.RHEL.OnDemand },
+	},
+	platformSUSE: {
+		spotProduct: spotProductSUSE,
+		onDemand:    func(p ec2instancesinfo.RegionPrices) float64 { return p.SLES.OnDemand },
+	},
+	platformWindows: {
+		spotProduct: spotProductWindows,
+		onDemand:    func(p ec2instancesinfo.RegionPrices) float64 { return p.MSWin.OnDemand },
+	},
+	platformWindowsSQLStandard: {
+		spotProduct: spotProductWindows,
+		base:        platformWindows,
+		onDemand:    func(p ec2instancesinfo.RegionPrices) float64 { return p.MSWinSQL.OnDemand },
+	},
+	platformWindowsSQLWeb: {
+		spotProduct: spotProductWindows,
+		base:        platformWindows,
+		onDemand:    func(p ec2instancesinfo.RegionPrices) float64 { return p.MSWinSQLWeb.OnDemand },
+	},
+	platformWindowsSQLEnterprise: {
+		spotProduct: spotProductWindows,
+		base:        platformWindows,
+		onDemand:    func(p ec2instancesinfo.RegionPrices) float64 { return p.MSWinSQLEnterprise.OnDemand },
+	},
+	platformLinuxSQLStandard: {
+		spotProduct: spotProductLinux,
+		base:        platformLinux,
+		onDemand:    func(p ec2instancesinfo.RegionPrices) float64 { return p.LinuxSQL.OnDemand },
+	},
+	platformLinuxSQLWeb: {
+		spotProduct: spotProductLinux,
+		base:        platformLinux,
+		onDemand:    func(p ec2instancesinfo.RegionPrices) float64 { return p.LinuxSQLWeb.OnDemand },
+	},
+	platformLinuxSQLEnterprise: {
+		spotProduct: spotProductLinux,
+		base:        platformLinux,
+		onDemand:    func(p ec2instancesinfo.RegionPrices) float64 { return p.LinuxSQLEnterprise.OnDemand },
+	},
+}
+
+// The usage operation is used as a fallback when the platform details are
+// missing, the key is the suffix following the RunInstances prefix.
+var usageOperationPlatforms = map[string]string{
+	"":      platformLinux,
+	":00g0": platformRHELBYOL,
+	":0010": platformRHEL,
+	":000g": platformSUSE,
+	":0002": platformWindows,
+	":0006": platformWindowsSQLStandard,
+	":0202": platformWindowsSQLWeb,
+	":0102": platformWindowsSQLEnterprise,
+	":0004": platformLinuxSQLStandard,
+	":0200": platformLinuxSQLWeb,
+	":0100": platformLinuxSQLEnterprise,
+}
+
+// getImagePlatform detects the operating system from the billing information
+// of an AMI. It returns an empty string for unknown platforms.
+func getImagePlatform(img *ec2.Image) string {
+	if img == nil {
+		return ""
+	}
+
+	if _, ok := platforms[aws.StringValue(img.PlatformDetails)]; ok {
+		return *img.PlatformDetails
+	}
+
+	if op := aws.StringValue(img.UsageOperation); strings.HasPrefix(op, usageOperationPrefix) {
+		if p, ok := usageOperationPlatforms[strings.TrimPrefix(op, usageOperationPrefix)]; ok {
+			return p
+		}
+	}
+	return ""
+}
+
+// platform returns the operating system of the instance, detected from the
+// AMI it was launched from. For unknown platforms it returns an empty string,
+// in which case we keep using the globally configured spot product and
+// premium.
+func (i *instance) platform() string {
+	if i.region != nil {
+		if p := i.region.imagePlatforms[aws.StringValue(i.ImageId)]; p != "" {
+			return p
+		}
+	}
+
+	if strings.EqualFold(aws.StringValue(i.Platform), ec2.PlatformValuesWindows) {
+		return platformWindows
+	}
+	return ""
+}
+
+// launchPlatform returns the operating system of the spot instances launched
+// to replace the instance, detected from the AMI of its group's launch
+// template or launch configuration, which may differ from the AMI of the
+// running instance once the group was updated. It falls back to the platform
+// of the running instance when the launch AMI is unknown.
+func (i *instance) launchPlatform() string {
+	if i.region != nil && i.asg != nil {
+		if p := i.region.imagePlatforms[i.region.launchImages[i.asg.name]]; p != "" {
+			return p
+		}
+	}
+	return i.platform()
+}
+
+// launchImageID returns the AMI of the launch configuration or launch template
+// of the group, or an empty string if it can't be determined, such as when
+// the launch template resolves the AMI from a Systems Manager parameter.
+func (r *region) launchImageID(ctx context.Context, group *autoscaling.Group) string {
+	name := aws.StringValue(group.AutoScalingGroupName)
+
+	if group.LaunchConfigurationName != nil {
+		resp, err := r.services.autoScaling.DescribeLaunchConfigurationsWithContext(ctx,
+			&autoscaling.DescribeLaunchConfigurationsInput{
+				LaunchConfigurationNames: []*string{group.LaunchConfigurationName},
+			})
+		if err != nil {
+			logger.Println(r.name, "Failed to describe the launch configuration of", name, err.Error())
+			return ""
+		}
+		if len(resp.LaunchConfigurations) == 0 {
+			return ""
+		}
+		return aws.StringValue(resp.LaunchConfigurations[0].ImageId)
+	}
+
+	lt := group.LaunchTemplate
+	if lt == nil && group.MixedInstancesPolicy != nil && group.MixedInstancesPolicy.LaunchTemplate != nil {
+		lt = group.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification
+	}
+	if lt == nil {
+		return ""
+	}
+
+	version := lt.Version
+	if version == nil {
+		version = aws.String("$Default")
+	}
+
+	resp, err := r.services.ec2.DescribeLaunchTemplateVersionsWithContext(ctx,
+		&ec2.DescribeLaunchTemplateVersionsInput{
+			LaunchTemplateId:   lt.LaunchTemplateId,
+			LaunchTemplateName: lt.LaunchTemplateName,
+			Versions:           []*string{version},
+		})
+	if err != nil {
+		logger.Println(r.name, "Failed to describe the launch template of", name, err.Error())
+		return ""
+	}
+	if len(resp.LaunchTemplateVersions) == 0 || resp.LaunchTemplateVersions[0].LaunchTemplateData == nil {
+		return ""
+	}
+
+	if id := aws.StringValue(resp.LaunchTemplateVersions[0].LaunchTemplateData.ImageId); strings.HasPrefix(id, "ami-") {
+		return id
+	}
+	return ""
+}
+
+// detectPlatforms describes the AMIs of all the running instances and those
+// used for launching the instances of the enabled groups in order to determine
+// their operating systems, and prices the instances accordingly.
+func (r *region) detectPlatforms(ctx context.Context) {
+	r.imagePlatforms = make(map[string]string)
+	r.launchImages = make(map[string]string)
+
+	imageIDs := make(map[string]bool)
+	for inst := range r.instances.instances() {
+		if inst.ImageId != nil {
+			imageIDs[*inst.ImageId] = true
+		}
+	}
+
+	for _, asg := range r.enabledASGs {
+		if id := r.launchImageID(ctx, asg.Group); id != "" {
+			r.launchImages[asg.name] = id
+			imageIDs[id] = true
+		}
+	}
+
+	var ids []*string
+	for id := range imageIDs {
+		ids = append(ids, aws.String(id))
+	}
+
+	for start := 0; start < len(ids); start += maxImagesPerDescribe {
+		end := start + maxImagesPerDescribe
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		resp, err := r.services.ec2.DescribeImagesWithContext(ctx, &ec2.DescribeImagesInput{
+			ImageIds: ids[start:end],
+		})
+		if err != nil {
+			logger.Println(r.name, "Failed to describe the AMIs of the instances,",
+				"using the default spot product for them:", err.Error())
+			continue
+		}
+
+		for _, img := range resp.Images {
+			if p := getImagePlatform(img); p != "" {
+				r.imagePlatforms[*img.ImageId] = p
+			}
+		}
+	}
+
+	for inst := range r.instances.instances() {
+		if p := inst.platform(); p != "" && inst.InstanceType != nil {
+			debug.Println(r.name, "Detected platform", p, "for", *inst.InstanceId)
+			inst.typeInfo = r.instanceTypeInformation[*inst.InstanceType].forPlatform(p)
+		}
+	}
+}
+
+// platformPrices computes the prices of an instance type for each of the known
+// platforms, sharing the spot price maps between the platforms using the same
+// spot product.
+func platformPrices(regionPrices ec2instancesinfo.RegionPrices, multiplier float64) map[string]prices {
+	result := make(map[string]prices)
+	spot := make(map[string]spotPriceMap)
+
+	for name, p := range platforms {
+		onDemand := p.onDemand(regionPrices)
+		if onDemand <= 0 {
+			continue
+		}
+
+		if spot[p.spotProduct] == nil {
+			spot[p.spotProduct] = make(spotPriceMap)
+		}
+
+		price := prices{
+			onDemand:     onDemand * multiplier,
+			spot:         spot[p.spotProduct],
+			ebsSurcharge: regionPrices.EBSSurcharge,
+			spotProduct:  p.spotProduct,
+		}
+
+		if p.base != "" {
+			if base := platforms[p.base].onDemand(regionPrices); base > 0 && base < onDemand {
+				price.licenseSurcharge = (onDemand - base) * multiplier
+			}
+		}
+		result[name] = price
+	}
+	return result
+}
+
+// forPlatform returns the instance type information priced for the given
+// platform, or the default pricing if we have no data about the platform.
+func (t instanceTypeInformation) forPlatform(name string) instanceTypeInformation {
+	price, ok := t.pricing.platforms[name]
+	if name == "" || !ok {
+		return t
+	}
+	price.platforms = t.pricing.platforms
+	t.pricing = price
+	return t
+}
@@ -0,0 +1,361 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	ec2instancesinfo "github.com/vkhodor/ec2-instances-info"
+)
+
+func Test_getImagePlatform(t *testing.T) {
+	tests := []struct {
+		name     string
+		image    *ec2.Image
+		expected string
+	}{
+		{
+			name:     "nil image",
+			image:    nil,
+			expected: "",
+		},
+		{
+			name:     "platform details",
+			image:    &ec2.Image{PlatformDetails: aws.String("Red Hat Enterprise Linux")},
+			expected: platformRHEL,
+		},
+		{
+			name:     "usage operation fallback",
+			image:    &ec2.Image{UsageOperation: aws.String("RunInstances:0006")},
+			expected: platformWindowsSQLStandard,
+		},
+		{
+			name:     "plain RunInstances usage operation",
+			image:    &ec2.Image{UsageOperation: aws.String("RunInstances")},
+			expected: platformLinux,
+		},
+		{
+			name: "unknown platform",
+			image: &ec2.Image{
+				PlatformDetails: aws.String("Windows BYOL"),
+				UsageOperation:  aws.String("RunInstances:0800"),
+			},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getImagePlatform(tt.image); got != tt.expected {
+				t.Errorf("getImagePlatform() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_instance_platform(t *testing.T) {
+	r := &region{imagePlatforms: map[string]string{"ami-rhel": platformRHEL}}
+
+	tests := []struct {
+		name     string
+		inst     *instance
+		expected string
+	}{
+		{
+			name: "detected from the AMI",
+			inst: &instance{
+				Instance: &ec2.Instance{ImageId: aws.String("ami-rhel")},
+				region:   r,
+			},
+			expected: platformRHEL,
+		},
+		{
+			name: "windows platform field",
+			inst: &instance{
+				Instance: &ec2.Instance{
+					ImageId:  aws.String("ami-unknown"),
+					Platform: aws.String("windows"),
+				},
+				region: r,
+			},
+			expected: platformWindows,
+		},
+		{
+			name: "unknown platform",
+			inst: &instance{
+				Instance: &ec2.Instance{ImageId: aws.String("ami-unknown")},
+			},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.inst.platform(); got != tt.expected {
+				t.Errorf("platform() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_platformPrices(t *testing.T) {
+	got := platformPrices(ec2instancesinfo.RegionPrices{
+		Linux:        ec2instancesinfo.Pricing{OnDemand: 0.1},
+		MSWin:        ec2instancesinfo.Pricing{OnDemand: 0.2},
+		MSWinSQL:     ec2instancesinfo.Pricing{OnDemand: 0.5},
+		EBSSurcharge: 0.01,
+	}, 2.0)
+
+	if _, ok := got[platformRHEL]; ok {
+		t.Errorf("expected no pricing for RHEL, which has no on-demand price")
+	}
+
+	windows, sql := got[platformWindows], got[platformWindowsSQLStandard]
+	if windows.spotProduct != spotProductWindows || sql.spotProduct != spotProductWindows {
+		t.Errorf("unexpected spot products %v and %v", windows.spotProduct, sql.spotProduct)
+	}
+	if math.Abs(windows.onDemand-0.4) > 0.000001 || math.Abs(sql.onDemand-1.0) > 0.000001 {
+		t.Errorf("unexpected on-demand prices %v and %v", windows.onDemand, sql.onDemand)
+	}
+	if windows.licenseSurcharge != 0 || math.Abs(sql.licenseSurcharge-0.6) > 0.000001 {
+		t.Errorf("unexpected license surcharges %v and %v",
+			windows.licenseSurcharge, sql.licenseSurcharge)
+	}
+	if sql.ebsSurcharge != 0.01 {
+		t.Errorf("unexpected EBS surcharge %v", sql.ebsSurcharge)
+	}
+
+	windows.spot["1a"] = 0.05
+	if sql.spot["1a"] != 0.05 {
+		t.Errorf("expected the platforms using the same spot product to share prices")
+	}
+	if got[platformLinux].spot["1a"] != 0 {
+		t.Errorf("expected the Linux spot prices to be separate")
+	}
+}
+
+func Test_instanceTypeInformation_forPlatform(t *testing.T) {
+	info := instanceTypeInformation{
+		instanceType: "m5.large",
+		pricing: prices{
+			onDemand: 0.1,
+			premium:  0.05,
+			platforms: map[string]prices{
+				platformSUSE: {onDemand: 0.2, spotProduct: spotProductSUSE},
+			},
+		},
+	}
+
+	if got := info.forPlatform(""); got.pricing.onDemand != 0.1 || got.pricing.premium != 0.05 {
+		t.Errorf("expected the default pricing for an unknown platform, got %v", got.pricing)
+	}
+	if got := info.forPlatform(platformRHEL); got.pricing.onDemand != 0.1 {
+		t.Errorf("expected the default pricing without platform data, got %v", got.pricing)
+	}
+
+	got := info.forPlatform(platformSUSE)
+	if got.instanceType != "m5.large" || got.pricing.onDemand != 0.2 || got.pricing.premium != 0 {
+		t.Errorf("expected the SUSE pricing, got %v", got.pricing)
+	}
+	if got.forPlatform(platformSUSE).pricing.onDemand != 0.2 {
+		t.Errorf("expected forPlatform to be idempotent")
+	}
+}
+
+func Test_instance_launchPlatform(t *testing.T) {
+	r := &region{
+		imagePlatforms: map[string]string{"ami-rhel": platformRHEL, "ami-win": platformWindows},
+		launchImages:   map[string]string{"updated": "ami-win", "unknown": "ami-unknown"},
+	}
+
+	tests := []struct {
+		name     string
+		asg      string
+		expected string
+	}{
+		{name: "detected from the launch AMI", asg: "updated", expected: platformWindows},
+		{name: "unknown launch AMI", asg: "unknown", expected: platformRHEL},
+		{name: "group without launch AMI", asg: "other", expected: platformRHEL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{ImageId: aws.String("ami-rhel")},
+				region:   r,
+				asg:      &autoScalingGroup{name: tt.asg},
+			}
+			if got := i.launchPlatform(); got != tt.expected {
+				t.Errorf("launchPlatform() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_region_launchImageID(t *testing.T) {
+	templateVersion := func(imageID string) *ec2.DescribeLaunchTemplateVersionsOutput {
+		return &ec2.DescribeLaunchTemplateVersionsOutput{
+			LaunchTemplateVersions: []*ec2.LaunchTemplateVersion{{
+				LaunchTemplateData: &ec2.ResponseLaunchTemplateData{ImageId: aws.String(imageID)},
+			}},
+		}
+	}
+	launchTemplate := &autoscaling.LaunchTemplateSpecification{
+		LaunchTemplateId: aws.String("lt-123"),
+		Version:          aws.String("$Latest"),
+	}
+
+	tests := []struct {
+		name     string
+		group    *autoscaling.Group
+		asg      mockASG
+		ec2      mockEC2
+		expected string
+	}{
+		{
+			name:  "launch configuration",
+			group: &autoscaling.Group{LaunchConfigurationName: aws.String("lc")},
+			asg: mockASG{dlco: &autoscaling.DescribeLaunchConfigurationsOutput{
+				LaunchConfigurations: []*autoscaling.LaunchConfiguration{{ImageId: aws.String("ami-lc")}},
+			}},
+			expected: "ami-lc",
+		},
+		{
+			name:     "launch template",
+			group:    &autoscaling.Group{LaunchTemplate: launchTemplate},
+			ec2:      mockEC2{dltvo: templateVersion("ami-lt")},
+			expected: "ami-lt",
+		},
+		{
+			name: "mixed instances policy",
+			group: &autoscaling.Group{MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{
+				LaunchTemplate: &autoscaling.LaunchTemplate{LaunchTemplateSpecification: launchTemplate},
+			}},
+			ec2:      mockEC2{dltvo: templateVersion("ami-mixed")},
+			expected: "ami-mixed",
+		},
+		{
+			name:  "AMI resolved from a parameter",
+			group: &autoscaling.Group{LaunchTemplate: launchTemplate},
+			ec2:   mockEC2{dltvo: templateVersion("resolve:ssm:/aws/service/ami")},
+		},
+		{
+			name:  "failed to describe the launch template",
+			group: &autoscaling.Group{LaunchTemplate: launchTemplate},
+			ec2:   mockEC2{dltverr: errors.New("denied")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name:     "us-east-1",
+				services: connections{autoScaling: tt.asg, ec2: tt.ec2},
+			}
+			if got := r.launchImageID(context.Background(), tt.group); got != tt.expected {
+				t.Errorf("launchImageID() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_region_detectPlatforms(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		instanceTypeInformation: map[string]instanceTypeInformation{
+			"m5.large": {
+				instanceType: "m5.large",
+				pricing: prices{
+					onDemand: 0.1,
+					platforms: map[string]prices{
+						platformWindows: {onDemand: 0.2, spotProduct: spotProductWindows},
+					},
+				},
+			},
+		},
+		services: connections{
+			ec2: mockEC2{
+				dimo: &ec2.DescribeImagesOutput{
+					Images: []*ec2.Image{
+						{ImageId: aws.String("ami-win"), PlatformDetails: aws.String("Windows")},
+						{ImageId: aws.String("ami-linux"), PlatformDetails: aws.String("Linux/UNIX")},
+					},
+				},
+			},
+		},
+	}
+
+	r.instances = makeInstances()
+	for id, ami := range map[string]string{"i-win": "ami-win", "i-linux": "ami-linux"} {
+		r.addInstance(&ec2.Instance{
+			InstanceId:   aws.String(id),
+			ImageId:      aws.String(ami),
+			InstanceType: aws.String("m5.large"),
+		})
+	}
+
+	r.detectPlatforms(context.Background())
+
+	if got := r.instances.get("i-win").typeInfo.pricing.onDemand; got != 0.2 {
+		t.Errorf("expected the Windows on-demand price, got %v", got)
+	}
+	// no Linux pricing data, so the default pricing is kept
+	if got := r.instances.get("i-linux").typeInfo.pricing.onDemand; got != 0.1 {
+		t.Errorf("expected the default on-demand price, got %v", got)
+	}
+}
+
+func Test_region_requestSpotPrices(t *testing.T) {
+	windowsSpot, defaultSpot := make(spotPriceMap), make(spotPriceMap)
+
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{
+			AutoScalingConfig: AutoScalingConfig{
+				SpotProductDescription: spotProductLinux,
+			},
+		},
+		instanceTypeInformation: map[string]instanceTypeInformation{
+			"m5.large": {
+				pricing: prices{
+					spot: defaultSpot,
+					platforms: map[string]prices{
+						platformWindows: {spot: windowsSpot, spotProduct: spotProductWindows},
+					},
+				},
+			},
+		},
+		services: connections{
+			ec2: mockEC2{
+				dsphpo: []*ec2.DescribeSpotPriceHistoryOutput{{
+					SpotPriceHistory: []*ec2.SpotPrice{{
+						InstanceType:     aws.String("m5.large"),
+						AvailabilityZone: aws.String("us-east-1a"),
+						SpotPrice:        aws.String("0.07"),
+					}},
+				}},
+			},
+		},
+	}
+
+	if err := r.requestSpotPrices(spotProductWindows); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if windowsSpot["us-east-1a"] != 0.07 {
+		t.Errorf("expected the Windows spot price to be set, got %v", windowsSpot)
+	}
+	if len(defaultSpot) != 0 {
+		t.Errorf("expected the default spot prices to be untouched, got %v", defaultSpot)
+	}
+	if !r.spotProducts[spotProductWindows] {
+		t.Errorf("expected the Windows spot product to be marked as fetched")
+	}
+}
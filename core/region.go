@@ -4,11 +4,13 @@
 package autospotting
 
 import (
+	"context"
 	"errors"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
@@ -31,13 +33,34 @@ type region struct {
 	// The key in this map is the instance type.
 	instanceTypeInformation map[string]instanceTypeInformation
 
+	// The spot product descriptions for which we already fetched the prices
+	spotProducts map[string]bool
+
+	// The key in this map is the AMI ID, the value is its platform
+	imagePlatforms map[string]string
+
+	// The key in this map is the name of the enabled group, the value is the
+	// AMI of its launch template or launch configuration
+	launchImages map[string]string
+
 	instances instances
 
+	// The number of recently interrupted spot instances of each group
+	spotInterruptions map[string]int
+
+	// All the groups of the region by name, enabled or not, nil when they
+	// couldn't be described
+	autoScalingGroups map[string]*autoscaling.Group
+
 	enabledASGs []autoScalingGroup
 	services    connections
 
 	tagsToFilterASGsBy []Tag
 
+	// The instance launched according to the event being processed, the only
+	// one replaced in this case
+	launchedInstanceID string
+
 	wg sync.WaitGroup
 }
 
@@ -46,6 +69,17 @@ type prices struct {
 	spot         spotPriceMap
 	ebsSurcharge float64
 	premium      float64
+
+	// licenseSurcharge is the cost of the licenses billed on top of the spot
+	// price, such as SQL Server.
+	licenseSurcharge float64
+
+	// spotProduct is the product description the spot prices were fetched
+	// for, empty for the globally configured one.
+	spotProduct string
+
+	// The key in this map is the platform detected from the instances' AMIs
+	platforms map[string]prices
 }
 
 // The key in this map is the availavility zone
@@ -74,35 +108,63 @@ func (r *region) enabled() bool {
 	return false
 }
 
-func (r *region) processRegion() {
+func (r *region) processRegion(ctx context.Context) {
+	r.processAutoScalingGroups(ctx, splitTargetList(r.conf.TargetAutoScalingGroups)...)
+}
+
+// processAutoScalingGroups processes the enabled AutoScaling groups of the
+// region, or only those given by name, if any.
+func (r *region) processAutoScalingGroups(ctx context.Context, names ...string) {
 
 	logger.Println("Creating connections to the required AWS services in", r.name)
-	r.services.connect(r.name)
+	r.services.connect(r.name, r.conf)
 	// only process the regions where we have AutoScaling groups set to be handled
 
 	// setup the filters for asg matching
 	r.setupAsgFilters()
 
 	logger.Println("Scanning for enabled AutoScaling groups in ", r.name)
-	r.scanForEnabledAutoScalingGroups()
+	r.scanForEnabledAutoScalingGroups(ctx, names...)
+
+	// only knowing all the groups when none was targeted
+	if len(names) == 0 && r.conf.OrphanedInstancesCleanup != OrphanedInstancesCleanupOff {
+		logger.Println("Cleaning up the orphaned spot instances in", r.name)
+		if err := r.cleanUpOrphanedInstances(ctx, time.Now()); err != nil {
+			logger.Printf("Failed to clean up the orphaned spot instances in %s error: %s\n", r.name, err)
+		}
+	}
 
 	// only process further the region if there are any enabled autoscaling groups
 	// within it
 	if r.hasEnabledAutoScalingGroups() {
 
 		logger.Println("Scanning full instance information in", r.name)
-		r.determineInstanceTypeInformation(r.conf)
+		r.determineInstanceTypeInformation(ctx, r.conf)
 
 		debug.Println(spew.Sdump(r.instanceTypeInformation))
 
 		logger.Println("Scanning instances in", r.name)
-		err := r.scanInstances()
+		err := r.scanInstances(ctx)
 		if err != nil {
 			logger.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
 		}
 
+		logger.Println("Detecting the operating systems of the instances in", r.name)
+		r.detectPlatforms(ctx)
+		r.requestPlatformSpotPrices()
+
+		logger.Println("Determining the reserved capacity coverage in", r.name)
+		r.determineReservationCoverage(ctx)
+
+		if r.reverseOnInterruptionsEnabled() {
+			logger.Println("Counting the recent spot interruptions in", r.name)
+			if err := r.scanSpotInterruptions(ctx); err != nil {
+				logger.Printf("Failed to count the spot interruptions in %s error: %s\n", r.name, err)
+			}
+		}
+
 		logger.Println("Processing enabled AutoScaling groups in", r.name)
-		r.processEnabledAutoScalingGroups()
+		r.processEnabledAutoScalingGroups(ctx)
 	} else {
 		logger.Println(r.name, "has no enabled AutoScaling groups")
 	}
@@ -157,7 +219,7 @@ func (r *region) processDescribeInstancesPage(page *ec2.DescribeInstancesOutput,
 	return true
 }
 
-func (r *region) scanInstances() error {
+func (r *region) scanInstances(ctx context.Context) error {
 	svc := r.services.ec2
 	input := &ec2.DescribeInstancesInput{
 		Filters: []*ec2.Filter{
@@ -173,7 +235,8 @@ func (r *region) scanInstances() error {
 
 	r.instances = makeInstances()
 
-	err := svc.DescribeInstancesPages(
+	err := svc.DescribeInstancesPagesWithContext(
+		ctx,
 		input,
 		r.processDescribeInstancesPage)
 
@@ -194,7 +257,7 @@ func (r *region) addInstance(inst *ec2.Instance) {
 	})
 }
 
-func (r *region) determineInstanceTypeInformation(cfg *Config) {
+func (r *region) determineInstanceTypeInformation(ctx context.Context, cfg *Config) {
 
 	r.instanceTypeInformation = make(map[string]instanceTypeInformation)
 
@@ -211,6 +274,7 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 		price.spot = make(spotPriceMap)
 		price.ebsSurcharge = it.Pricing[r.name].EBSSurcharge
 		price.premium = r.conf.SpotProductPremium
+		price.platforms = platformPrices(it.Pricing[r.name], cfg.OnDemandPriceMultiplier)
 
 		// if at this point the instance price is still zero, then that
 		// particular instance type doesn't even exist in the current
@@ -222,12 +286,15 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 				instanceType:        it.InstanceType,
 				vCPU:                it.VCPU,
 				PhysicalProcessor:   it.PhysicalProcessor,
+				architectures:       it.Arch,
+				burstable:           isBurstableType(it.InstanceType),
 				memory:              it.Memory,
 				GPU:                 it.GPU,
 				pricing:             price,
 				virtualizationTypes: it.LinuxVirtualizationTypes,
 				hasEBSOptimization:  it.EBSOptimized,
 				EBSThroughput:       it.EBSThroughput,
+				networkPerformance:  it.NetworkPerformance,
 			}
 
 			if it.Storage != nil {
@@ -240,24 +307,55 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 			r.instanceTypeInformation[it.InstanceType] = info
 		}
 	}
+	r.mergeInstanceTypeSpecs(ctx)
+
 	// this is safe to do once outside of the loop because the call will only
 	// return entries about the available instance types, so no invalid instance
 	// types would be returned
 
-	if err := r.requestSpotPrices(); err != nil {
+	r.spotProducts = make(map[string]bool)
+	if err := r.requestSpotPrices(r.conf.SpotProductDescription); err != nil {
 		logger.Println(err.Error())
 	}
 
 	debug.Println(spew.Sdump(r.instanceTypeInformation))
 }
 
-func (r *region) requestSpotPrices() error {
+// requestPlatformSpotPrices fetches the spot prices for the operating systems
+// detected on the running instances, which may differ from the globally
+// configured spot product in case of mixed-OS fleets.
+func (r *region) requestPlatformSpotPrices() {
+	for inst := range r.instances.instances() {
+		r.requestSpotPricesForPlatform(inst.platform(), "on "+*inst.InstanceId)
+	}
+	for asgName, id := range r.launchImages {
+		r.requestSpotPricesForPlatform(r.imagePlatforms[id], "in the launch AMI of "+asgName)
+	}
+}
+
+func (r *region) requestSpotPricesForPlatform(platform string, source string) {
+	p, ok := platforms[platform]
+	if !ok || r.spotProducts[p.spotProduct] {
+		return
+	}
+
+	logger.Println(r.name, "Detected platform", platform, source,
+		"fetching spot prices for", p.spotProduct)
+	if err := r.requestSpotPrices(p.spotProduct); err != nil {
+		logger.Println(err.Error())
+	}
+}
+
+func (r *region) requestSpotPrices(product string) error {
 
 	s := spotPrices{conn: r.services}
 
 	// Retrieve all current spot prices from the current region.
-	// TODO: add support for other OSes
-	err := s.fetch(r.conf.SpotProductDescription, 0, nil, nil)
+	if r.spotProducts == nil {
+		r.spotProducts = make(map[string]bool)
+	}
+	r.spotProducts[product] = true
+	err := s.fetch(product, 0, nil, nil)
 
 	if err != nil {
 		return errors.New("Couldn't fetch spot prices in " + r.name)
@@ -278,13 +376,23 @@ func (r *region) requestSpotPrices() error {
 			continue
 		}
 
-		if r.instanceTypeInformation[instType].pricing.spot == nil {
+		pricing := r.instanceTypeInformation[instType].pricing
+		if pricing.spot == nil {
 			debug.Println(r.name, "Instance data missing for", instType, "in", az,
 				"skipping because this region is currently not supported")
 			continue
 		}
 
-		r.instanceTypeInformation[instType].pricing.spot[az] = price
+		if product == r.conf.SpotProductDescription {
+			pricing.spot[az] = price
+		}
+
+		// the platforms sharing the same spot product also share the map
+		for _, platformPricing := range pricing.platforms {
+			if platformPricing.spotProduct == product {
+				platformPricing.spot[az] = price
+			}
+		}
 
 	}
 
@@ -361,7 +469,6 @@ func (r *region) findMatchingASGsInPageOfResults(groups []*autoscaling.Group,
 	tagsToMatch []Tag) []autoScalingGroup {
 
 	var asgs []autoScalingGroup
-	var optInFilterMode = (r.conf.TagFilteringMode != "opt-out")
 
 	tagCloudFormationStackName := Tag{Key: "aws:cloudformation:stack-name", Value: "*"}
 
@@ -374,11 +481,19 @@ func (r *region) findMatchingASGsInPageOfResults(groups []*autoscaling.Group,
 			continue
 		}
 
-		groupMatchesExpectedTags := isASGWithMatchingTags(group, tagsToMatch)
-		// Go lacks a logical XOR operator, this is the equivalent to that logical
-		// expression. The goal is to add the matching ASGs when running in opt-in
-		// mode and the other way round.
-		if optInFilterMode != groupMatchesExpectedTags {
+		if isOffboardingGroup(group) {
+			logger.Printf("Enabling group %s for off-boarding because of its %s tag\n",
+				asgName, OffboardingTag)
+			asgs = append(asgs, autoScalingGroup{
+				Group:       group,
+				name:        asgName,
+				region:      r,
+				offboarding: true,
+			})
+			continue
+		}
+
+		if !r.isEnabledGroup(group, tagsToMatch) {
 			debug.Printf("Skipping group %s because its tags, the currently "+
 				"configured filtering mode (%s) and tag filters do not align\n",
 				asgName, r.conf.TagFilteringMode)
@@ -406,16 +521,34 @@ func (r *region) findMatchingASGsInPageOfResults(groups []*autoscaling.Group,
 	return asgs
 }
 
-func (r *region) scanForEnabledAutoScalingGroups() {
+// isEnabledGroup checks if the group is enabled by the selector, or by its tags
+// in the configured filtering mode, regardless of the other reasons for
+// skipping it.
+func (r *region) isEnabledGroup(group *autoscaling.Group, tagsToMatch []Tag) bool {
+	return isGroupEnabled(group, r.conf.GroupSelector, r.conf.TagFilteringMode, tagsToMatch)
+}
+
+func (r *region) scanForEnabledAutoScalingGroups(ctx context.Context, names ...string) {
 
 	svc := r.services.autoScaling
 
+	input := &autoscaling.DescribeAutoScalingGroupsInput{}
+	if len(names) > 0 {
+		input.AutoScalingGroupNames = aws.StringSlice(names)
+	}
+
+	r.autoScalingGroups = make(map[string]*autoscaling.Group)
+
 	pageNum := 0
-	err := svc.DescribeAutoScalingGroupsPages(
-		&autoscaling.DescribeAutoScalingGroupsInput{},
+	err := svc.DescribeAutoScalingGroupsPagesWithContext(
+		ctx,
+		input,
 		func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
 			pageNum++
 			debug.Println("Processing page", pageNum, "of DescribeAutoScalingGroupsPages for", r.name)
+			for _, group := range page.AutoScalingGroups {
+				r.autoScalingGroups[aws.StringValue(group.AutoScalingGroupName)] = group
+			}
 			matchingAsgs := r.findMatchingASGsInPageOfResults(page.AutoScalingGroups, r.tagsToFilterASGsBy)
 			r.enabledASGs = append(r.enabledASGs, matchingAsgs...)
 			return true
@@ -424,6 +557,7 @@ func (r *region) scanForEnabledAutoScalingGroups() {
 
 	if err != nil {
 		logger.Println("Failed to describe AutoScalingGroups in", r.name, err.Error())
+		r.autoScalingGroups = nil
 	}
 
 }
@@ -434,15 +568,19 @@ func (r *region) hasEnabledAutoScalingGroups() bool {
 
 }
 
-func (r *region) processEnabledAutoScalingGroups() {
+func (r *region) processEnabledAutoScalingGroups(ctx context.Context) {
+	pool := newWorkerPool(r.conf.MaxConcurrentGroups)
+
 	for _, asg := range r.enabledASGs {
 
 		// Pass default configs to the group
 		asg.config = r.conf.AutoScalingConfig
 
 		r.wg.Add(1)
+		pool.acquire()
 		go func(a autoScalingGroup) {
-			a.process()
+			a.process(ctx)
+			pool.release()
 			r.wg.Done()
 		}(asg)
 	}
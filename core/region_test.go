@@ -4,6 +4,7 @@
 package autospotting
 
 import (
+	"context"
 	"math"
 	"reflect"
 	"testing"
@@ -181,7 +182,7 @@ func TestOnDemandPriceMultiplier(t *testing.T) {
 					InstanceType: "m1.small",
 					Pricing: map[string]ec2instancesinfo.RegionPrices{
 						"us-east-1": {
-							Linux: ec2instancesinfo.LinuxPricing{
+							Linux: ec2instancesinfo.Pricing{
 								OnDemand: 0.044,
 							},
 						},
@@ -204,7 +205,7 @@ func TestOnDemandPriceMultiplier(t *testing.T) {
 					},
 				},
 			}}
-		r.determineInstanceTypeInformation(cfg)
+		r.determineInstanceTypeInformation(context.Background(), cfg)
 
 		actualPrice := r.instanceTypeInformation["m1.small"].pricing.onDemand
 		if math.Abs(actualPrice-tt.want) > 0.000001 {
@@ -636,7 +637,7 @@ func TestFilterAsgs(t *testing.T) {
 					},
 				},
 			}
-			r.scanForEnabledAutoScalingGroups()
+			r.scanForEnabledAutoScalingGroups(context.Background())
 			var asgNames []string
 			for _, name := range r.enabledASGs {
 				asgNames = append(asgNames, name.name)
@@ -753,7 +754,7 @@ func Test_region_scanInstances(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			r := tt.regionInfo
-			err := r.scanInstances()
+			err := r.scanInstances(context.Background())
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("region.scanInstances() error = %v, wantErr %v", err, tt.wantErr)
@@ -0,0 +1,248 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// DefaultReportTopCandidates is the number of spot instance types listed for
+// each Availability Zone in the candidates report
+const DefaultReportTopCandidates = 5
+
+// Report prints, without taking any action, the cheapest compatible spot
+// instance types for each of the enabled AutoScaling groups, in each of their
+// Availability Zones, together with the reasons for which all the other
+// instance types were rejected. It's meant for capacity planning and for
+// troubleshooting the instance type selection.
+func Report(ctx context.Context, cfg *Config, w io.Writer) {
+	setupLogging(cfg)
+
+	if err := refreshInstanceData(cfg); err != nil {
+		logger.Println("Couldn't load the instance data:", err.Error())
+		return
+	}
+
+	addDefaultFilteringMode(cfg)
+	addDefaultFilter(cfg)
+
+	allRegions, err := targetRegions(cfg)
+	if err != nil {
+		logger.Println(err.Error())
+		return
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	pool := newWorkerPool(cfg.MaxConcurrentRegions)
+
+	for _, name := range allRegions {
+		r := region{name: name, conf: cfg}
+		if !r.enabled() {
+			continue
+		}
+
+		wg.Add(1)
+		pool.acquire()
+		go func() {
+			defer wg.Done()
+			defer pool.release()
+
+			var buf bytes.Buffer
+			r.report(ctx, &buf)
+
+			mu.Lock()
+			defer mu.Unlock()
+			w.Write(buf.Bytes())
+		}()
+	}
+	wg.Wait()
+}
+
+// report scans the region the same way as when processing it, writing the
+// candidates report of all its enabled AutoScaling groups.
+func (r *region) report(ctx context.Context, w io.Writer) {
+	r.services.connect(r.name, r.conf)
+	r.setupAsgFilters()
+	r.scanForEnabledAutoScalingGroups(ctx, splitTargetList(r.conf.TargetAutoScalingGroups)...)
+
+	if !r.hasEnabledAutoScalingGroups() {
+		return
+	}
+
+	r.determineInstanceTypeInformation(ctx, r.conf)
+
+	if err := r.scanInstances(ctx); err != nil {
+		logger.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
+	}
+
+	r.detectPlatforms(ctx)
+	r.requestPlatformSpotPrices()
+
+	for _, asg := range r.enabledASGs {
+		a := asg
+		a.config = r.conf.AutoScalingConfig
+		a.scanInstances()
+		a.loadDefaultConfig()
+		a.loadConfigFromTags()
+		if _, err := a.loadLaunchConfiguration(); err != nil {
+			debug.Println("Couldn't load the launch configuration of", a.name, err.Error())
+		}
+
+		a.report(w, r.conf.ReportTopCandidates)
+	}
+}
+
+// reportCandidate is a compatible spot instance type listed in the report
+type reportCandidate struct {
+	instanceType string
+	price        float64
+}
+
+// report writes the cheapest compatible spot instance types for the group,
+// compared to one of its on-demand instances, or to a spot instance when all
+// of them were already replaced.
+func (a *autoScalingGroup) report(w io.Writer, topCandidates int) {
+	fmt.Fprintf(w, "%s %s\n", a.region.name, a.name)
+
+	reference := a.getAnyOnDemandInstance()
+	if reference == nil {
+		reference = a.getAnySpotInstance()
+	}
+	if reference == nil || reference.Placement == nil {
+		fmt.Fprintf(w, "  no running instances, nothing to compare\n\n")
+		return
+	}
+
+	if topCandidates <= 0 {
+		topCandidates = DefaultReportTopCandidates
+	}
+
+	// compare the spot prices with the on-demand price, even for spot instances
+	current := *reference
+	current.price = current.typeInfo.pricing.onDemand + current.typeInfo.pricing.premium
+
+	fmt.Fprintf(w, "  compared to %s %s with the on-demand price %.4f\n",
+		aws.StringValue(current.InstanceId), current.typeInfo.instanceType, current.price)
+
+	candidates, rejections := current.reportCandidates(a.availabilityZones())
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, az := range a.availabilityZones() {
+		fmt.Fprintf(tw, "  %s\tinstance type\tspot price\tsavings\n", az)
+
+		if len(candidates[az]) == 0 {
+			fmt.Fprintf(tw, "  \tno compatible spot instance types\t\t\n")
+		}
+
+		for n, c := range candidates[az] {
+			if n == topCandidates {
+				break
+			}
+			fmt.Fprintf(tw, "  \t%s\t%.4f\t%.1f%%\n",
+				c.instanceType, c.price, (current.price-c.price)/current.price*100)
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprintf(w, "  rejected instance types:\n")
+	types := make([]string, 0, len(rejections))
+	for t := range rejections {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	for _, t := range types {
+		fmt.Fprintf(w, "    %s: %s\n", t, formatRejections(rejections[t], len(a.availabilityZones())))
+	}
+	fmt.Fprintln(w)
+}
+
+// availabilityZones returns the sorted Availability Zones of the group
+func (a *autoScalingGroup) availabilityZones() []string {
+	azs := aws.StringValueSlice(a.AvailabilityZones)
+	sort.Strings(azs)
+	return azs
+}
+
+// reportCandidates compares all the instance types with the current instance
+// in each of the Availability Zones, returning the compatible ones sorted by
+// price, and for the others the Availability Zones in which they were
+// rejected for each reason.
+func (i *instance) reportCandidates(azs []string) (map[string][]reportCandidate, map[string]map[string][]string) {
+	candidates := make(map[string][]reportCandidate)
+	rejections := make(map[string]map[string][]string)
+
+	allowedList := i.asg.getAllowedInstanceTypes(i)
+	disallowedList := i.asg.getDisallowedInstanceTypes(i)
+	attachedVolumes := i.attachedInstanceStoreVolumes()
+	requireNetworkCapacity := i.requiresNetworkCapacity()
+
+	reject := func(instanceType, reason, az string) {
+		if rejections[instanceType] == nil {
+			rejections[instanceType] = make(map[string][]string)
+		}
+		rejections[instanceType][reason] = append(rejections[instanceType][reason], az)
+	}
+
+	for name, info := range i.region.instanceTypeInformation {
+		candidate := info.forPlatform(i.launchPlatform())
+
+		if !i.isAllowed(candidate.instanceType, allowedList, disallowedList) {
+			for _, az := range azs {
+				reject(name, "not allowed for the group", az)
+			}
+			continue
+		}
+
+		hardwareReason := i.incompatibilityReason(candidate, attachedVolumes, requireNetworkCapacity)
+
+		for _, az := range azs {
+			price := i.calculatePriceInAZ(candidate, az)
+
+			switch priceReason := i.priceIncompatibilityReason(price); {
+			case hardwareReason != "":
+				reject(name, hardwareReason, az)
+			case priceReason != "":
+				reject(name, "spot price "+priceReason, az)
+			default:
+				candidates[az] = append(candidates[az], reportCandidate{name, price})
+			}
+		}
+	}
+
+	for _, list := range candidates {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].price == list[j].price {
+				return list[i].instanceType < list[j].instanceType
+			}
+			return list[i].price < list[j].price
+		})
+	}
+	return candidates, rejections
+}
+
+// formatRejections lists the rejection reasons of an instance type, followed
+// by the Availability Zones they apply to unless they apply to all of them.
+func formatRejections(reasons map[string][]string, azCount int) string {
+	var out []string
+	for reason, azs := range reasons {
+		if len(azs) == azCount {
+			out = append(out, reason)
+			continue
+		}
+		out = append(out, fmt.Sprintf("%s (%s)", reason, strings.Join(azs, ", ")))
+	}
+	sort.Strings(out)
+	return strings.Join(out, "; ")
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func testReportGroup() *autoScalingGroup {
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{AutoScalingConfig: AutoScalingConfig{DisallowedInstanceTypes: "t2.*"}},
+		instanceTypeInformation: map[string]instanceTypeInformation{
+			"m5.large": {
+				instanceType: "m5.large", vCPU: 2, memory: 8, architectures: []string{"x86_64"},
+				pricing: prices{onDemand: 0.096, spot: map[string]float64{"us-east-1a": 0.035, "us-east-1b": 0.04}},
+			},
+			"m5a.large": {
+				instanceType: "m5a.large", vCPU: 2, memory: 8, architectures: []string{"x86_64"},
+				pricing: prices{onDemand: 0.086, spot: map[string]float64{"us-east-1a": 0.03, "us-east-1b": 0.2}},
+			},
+			"c5.large": {
+				instanceType: "c5.large", vCPU: 2, memory: 4, architectures: []string{"x86_64"},
+				pricing: prices{onDemand: 0.085, spot: map[string]float64{"us-east-1a": 0.02, "us-east-1b": 0.02}},
+			},
+			"t2.large": {
+				instanceType: "t2.large", vCPU: 2, memory: 8, architectures: []string{"x86_64"},
+				pricing: prices{onDemand: 0.0928, spot: map[string]float64{"us-east-1a": 0.01, "us-east-1b": 0.01}},
+			},
+		},
+	}
+
+	a := &autoScalingGroup{
+		name:   "mygroup",
+		region: r,
+		Group: &autoscaling.Group{
+			AvailabilityZones: aws.StringSlice([]string{"us-east-1b", "us-east-1a"}),
+		},
+		instances: makeInstancesWithCatalog(instanceMap{
+			"i-123": {
+				Instance: &ec2.Instance{
+					InstanceId:         aws.String("i-123"),
+					InstanceType:       aws.String("m5.large"),
+					VirtualizationType: aws.String("hvm"),
+					Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+					State:              &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+				},
+				typeInfo: r.instanceTypeInformation["m5.large"],
+				price:    0.096,
+				region:   r,
+			},
+		}),
+	}
+
+	for i := range a.instances.instances() {
+		i.asg = a
+	}
+	return a
+}
+
+func Test_instance_reportCandidates(t *testing.T) {
+	a := testReportGroup()
+	i := a.getAnyOnDemandInstance()
+
+	candidates, rejections := i.reportCandidates(a.availabilityZones())
+
+	expectedCandidates := map[string][]reportCandidate{
+		"us-east-1a": {{"m5a.large", 0.03}, {"m5.large", 0.035}},
+		"us-east-1b": {{"m5.large", 0.04}},
+	}
+	if !reflect.DeepEqual(candidates, expectedCandidates) {
+		t.Errorf("reportCandidates() candidates = %v, expected %v", candidates, expectedCandidates)
+	}
+
+	expectedRejections := map[string]map[string][]string{
+		"c5.large": {
+			"different CPU architecture or fewer vCPUs, memory or GPUs": {"us-east-1a", "us-east-1b"},
+		},
+		"m5a.large": {
+			"spot price more expensive than the on-demand price": {"us-east-1b"},
+		},
+		"t2.large": {
+			"not allowed for the group": {"us-east-1a", "us-east-1b"},
+		},
+	}
+	if !reflect.DeepEqual(rejections, expectedRejections) {
+		t.Errorf("reportCandidates() rejections = %v, expected %v", rejections, expectedRejections)
+	}
+}
+
+func Test_autoScalingGroup_report(t *testing.T) {
+	var buf bytes.Buffer
+	testReportGroup().report(&buf, 1)
+	out := buf.String()
+
+	for _, expected := range []string{
+		"us-east-1 mygroup",
+		"compared to i-123 m5.large with the on-demand price 0.0960",
+		"m5a.large      0.0300      68.8%",
+		"c5.large: different CPU architecture or fewer vCPUs, memory or GPUs\n",
+		"m5a.large: spot price more expensive than the on-demand price (us-east-1b)\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("report() = %q, expected it to contain %q", out, expected)
+		}
+	}
+
+	// only the top candidate is listed
+	if strings.Contains(out, "0.0350") {
+		t.Errorf("report() = %q, expected only one candidate per Availability Zone", out)
+	}
+
+	buf.Reset()
+	empty := testReportGroup()
+	empty.instances = makeInstances()
+	empty.report(&buf, 1)
+	if !strings.Contains(buf.String(), "no running instances") {
+		t.Errorf("report() = %q, expected groups without instances to be skipped", buf.String())
+	}
+}
+
+func Test_formatRejections(t *testing.T) {
+	reasons := map[string][]string{
+		"spot price unavailable in this Availability Zone": {"us-east-1c"},
+		"insufficient instance storage":                    {"us-east-1a", "us-east-1b", "us-east-1c"},
+	}
+	expected := "insufficient instance storage; spot price unavailable in this Availability Zone (us-east-1c)"
+	if got := formatRejections(reasons, 3); got != expected {
+		t.Errorf("formatRejections() = %q, expected %q", got, expected)
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The reasons for which on-demand instances are kept out of the replacement
+const (
+	reservedInstanceCoverage = "reserved instance"
+	savingsPlanCoverage      = "savings plan"
+)
+
+const (
+	reservedInstanceScopeZonal = "Availability Zone"
+	reservedInstanceVPCSuffix  = " (Amazon VPC)"
+	defaultTenancy             = "default"
+	autoScalingGroupNameTag    = "aws:autoscaling:groupName"
+)
+
+// reservationKey identifies the on-demand instances which can be covered by a
+// given Reserved Instance. The availability zone is only set for zonal
+// reservations.
+type reservationKey struct {
+	instanceType     string
+	availabilityZone string
+	platform         string
+	tenancy          string
+}
+
+// savingsPlansCommitment is the Savings Plans commitment of a region, as given
+// in the Savings Plans coverage file.
+type savingsPlansCommitment struct {
+	// the hourly commitment, in dollars spent at the Savings Plans rates
+	Commitment float64 `json:"commitment"`
+
+	// the average discount of the Savings Plans rates from the on-demand ones
+	DiscountPercentage float64 `json:"discount_percentage"`
+}
+
+// loadSavingsPlansCoverage reads the Savings Plans coverage file, a JSON object
+// mapping each region to its hourly Savings Plans commitment and the average
+// discount of its rates, for example
+// {"us-east-1": {"commitment": 2.5, "discount_percentage": 28}}, and returns
+// the hourly on-demand spend covered by the commitment in each region.
+func loadSavingsPlansCoverage(path string) (map[string]float64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var commitments map[string]savingsPlansCommitment
+	if err := json.Unmarshal(data, &commitments); err != nil {
+		return nil, err
+	}
+
+	coverage := make(map[string]float64)
+	for region, c := range commitments {
+		if c.Commitment < 0 || c.DiscountPercentage < 0 || c.DiscountPercentage >= 100 {
+			return nil, fmt.Errorf("invalid Savings Plans commitment %+v for %s", c, region)
+		}
+		coverage[region] = c.Commitment / (1 - c.DiscountPercentage/100)
+	}
+	return coverage, nil
+}
+
+// reservationPlatform converts the Reserved Instance product description or the
+// platform detected on an instance to the same format, so they can be matched.
+func reservationPlatform(platform string) string {
+	platform = strings.TrimSuffix(platform, reservedInstanceVPCSuffix)
+	if platform == "" || platform == platformRHELBYOL {
+		return platformLinux
+	}
+	return platform
+}
+
+func instanceTenancy(i *instance) string {
+	if i.Placement != nil && i.Placement.Tenancy != nil {
+		return *i.Placement.Tenancy
+	}
+	return defaultTenancy
+}
+
+// onDemandPrice returns the hourly on-demand price of the instance, including
+// the configured premium for the operating systems we couldn't detect.
+func (i *instance) onDemandPrice() float64 {
+	return i.typeInfo.pricing.onDemand + i.typeInfo.pricing.premium
+}
+
+// reservedCapacity holds the active Reserved Instances not yet applied to any
+// of the running on-demand instances.
+type reservedCapacity struct {
+	// the number of zonal and regional reservations of each type
+	zonal    map[reservationKey]int64
+	regional map[reservationKey]int64
+
+	// the size flexible regional reservations, keyed by instance family
+	// instead of type, in normalized units
+	flexible map[reservationKey]float64
+}
+
+// sizeNormalizationFactors are the normalized units of the instance sizes,
+// which allow the regional Linux Reserved Instances to cover the instances of
+// any size from the same family.
+var sizeNormalizationFactors = map[string]float64{
+	"nano":   0.25,
+	"micro":  0.5,
+	"small":  1,
+	"medium": 2,
+	"large":  4,
+	"xlarge": 8,
+}
+
+// normalizedSize returns the family of the instance type and the normalized
+// units of its size, which are zero when unknown, such as for the metal sizes.
+func normalizedSize(instanceType string) (string, float64) {
+	parts := strings.SplitN(instanceType, ".", 2)
+	if len(parts) != 2 {
+		return "", 0
+	}
+	family, size := parts[0], parts[1]
+
+	if units, ok := sizeNormalizationFactors[size]; ok {
+		return family, units
+	}
+	if n, err := strconv.ParseFloat(strings.TrimSuffix(size, "xlarge"), 64); err == nil &&
+		strings.HasSuffix(size, "xlarge") && n > 0 {
+		return family, n * sizeNormalizationFactors["xlarge"]
+	}
+	return family, 0
+}
+
+// sizeFlexibleKey returns the key of the size flexible reservations which can
+// cover the given instance type, and its size in normalized units. Only the
+// Linux reservations with default tenancy are size flexible.
+func sizeFlexibleKey(key reservationKey) (reservationKey, float64) {
+	family, units := normalizedSize(key.instanceType)
+	if units == 0 || key.platform != platformLinux || key.tenancy != defaultTenancy {
+		return reservationKey{}, 0
+	}
+	key.instanceType = family
+	return key, units
+}
+
+// getReservedInstances returns the active Reserved Instances of the region.
+func (r *region) getReservedInstances(ctx context.Context) (*reservedCapacity, error) {
+	capacity := &reservedCapacity{
+		zonal:    make(map[reservationKey]int64),
+		regional: make(map[reservationKey]int64),
+		flexible: make(map[reservationKey]float64),
+	}
+
+	resp, err := r.services.ec2.DescribeReservedInstancesWithContext(ctx, &ec2.DescribeReservedInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("state"),
+				Values: []*string{aws.String(ec2.ReservedInstanceStateActive)},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ri := range resp.ReservedInstances {
+		key := reservationKey{
+			instanceType: aws.StringValue(ri.InstanceType),
+			platform:     reservationPlatform(aws.StringValue(ri.ProductDescription)),
+			tenancy:      aws.StringValue(ri.InstanceTenancy),
+		}
+		if key.tenancy == "" {
+			key.tenancy = defaultTenancy
+		}
+		count := aws.Int64Value(ri.InstanceCount)
+
+		if aws.StringValue(ri.Scope) == reservedInstanceScopeZonal {
+			key.availabilityZone = aws.StringValue(ri.AvailabilityZone)
+			capacity.zonal[key] += count
+			continue
+		}
+
+		if flexibleKey, units := sizeFlexibleKey(key); units > 0 {
+			capacity.flexible[flexibleKey] += float64(count) * units
+			continue
+		}
+		capacity.regional[key] += count
+	}
+	return capacity, nil
+}
+
+// cover applies one of the reservations to the instance with the given key if
+// any is left, preferring the zonal ones. The size flexible reservations only
+// cover the instances entirely, since the partially covered ones still cost
+// more than their spot replacements.
+func (c *reservedCapacity) cover(key reservationKey) bool {
+	regionalKey := key
+	regionalKey.availabilityZone = ""
+
+	if c.zonal[key] > 0 {
+		c.zonal[key]--
+		return true
+	}
+	if c.regional[regionalKey] > 0 {
+		c.regional[regionalKey]--
+		return true
+	}
+	if flexibleKey, units := sizeFlexibleKey(regionalKey); units > 0 &&
+		c.flexible[flexibleKey] >= units {
+		c.flexible[flexibleKey] -= units
+		return true
+	}
+	return false
+}
+
+// getCoverageCandidates returns the running on-demand instances from the
+// region in the order in which we consider them covered by reservations. The
+// instances that aren't part of any enabled group come first, since they're
+// not going to be replaced anyway, so the reservations should be considered
+// applied to them.
+func (r *region) getCoverageCandidates() []*instance {
+	enabled := make(map[string]bool)
+	for _, asg := range r.enabledASGs {
+		enabled[asg.name] = true
+	}
+
+	var candidates []*instance
+	for i := range r.instances.instances() {
+		if i.isSpot() || i.State == nil ||
+			aws.StringValue(i.State.Name) != ec2.InstanceStateNameRunning ||
+			i.InstanceType == nil || i.Placement == nil {
+			continue
+		}
+		candidates = append(candidates, i)
+	}
+
+	managed := func(i *instance) bool {
+		for _, tag := range i.Tags {
+			if aws.StringValue(tag.Key) == autoScalingGroupNameTag {
+				return enabled[aws.StringValue(tag.Value)]
+			}
+		}
+		return false
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		mi, mj := managed(candidates[i]), managed(candidates[j])
+		if mi != mj {
+			return !mi
+		}
+		return aws.StringValue(candidates[i].InstanceId) < aws.StringValue(candidates[j].InstanceId)
+	})
+	return candidates
+}
+
+// determineReservationCoverage marks the on-demand instances covered by
+// Reserved Instances or Savings Plans, so they're not replaced with spot
+// instances. The zonal Reserved Instances are applied first, then the regional
+// ones and finally the Savings Plans commitment configured for the region.
+func (r *region) determineReservationCoverage(ctx context.Context) {
+	if r.conf.ConsiderReservedCapacity != "true" {
+		debug.Println(r.name, "Not considering the reserved capacity")
+		return
+	}
+
+	reserved, err := r.getReservedInstances(ctx)
+	if err != nil {
+		logger.Println(r.name, "Failed to describe the Reserved Instances,",
+			"considering all on-demand instances uncovered:", err.Error())
+		return
+	}
+
+	savingsPlanCommitment := r.conf.SavingsPlansCoverage[r.name]
+	covered := make(map[string]int)
+
+	for _, i := range r.getCoverageCandidates() {
+		key := reservationKey{
+			instanceType:     *i.InstanceType,
+			availabilityZone: aws.StringValue(i.Placement.AvailabilityZone),
+			platform:         reservationPlatform(i.platform()),
+			tenancy:          instanceTenancy(i),
+		}
+
+		switch {
+		case reserved.cover(key):
+			i.coveredBy = reservedInstanceCoverage
+		case i.onDemandPrice() > 0 && i.onDemandPrice() <= savingsPlanCommitment:
+			savingsPlanCommitment -= i.onDemandPrice()
+			i.coveredBy = savingsPlanCoverage
+		default:
+			continue
+		}
+		covered[i.coveredBy]++
+		debug.Println(r.name, "On-demand instance", *i.InstanceId, "is covered by a", i.coveredBy)
+	}
+
+	logger.Println(r.name, "Found", covered[reservedInstanceCoverage],
+		"on-demand instances covered by Reserved Instances and",
+		covered[savingsPlanCoverage], "covered by Savings Plans")
+}
+
+// reportOnDemandCoverage logs the on-demand spend of the group which isn't
+// covered by any reservations, and could be reduced using spot instances.
+func (a *autoScalingGroup) reportOnDemandCoverage() {
+	var covered, uncovered int
+	var uncoveredSpend float64
+
+	for i := range a.instances.instances() {
+		if i.isSpot() || i.State == nil ||
+			aws.StringValue(i.State.Name) != ec2.InstanceStateNameRunning {
+			continue
+		}
+		if i.coveredBy != "" {
+			covered++
+			continue
+		}
+		uncovered++
+		uncoveredSpend += i.onDemandPrice()
+	}
+
+	if covered == 0 && uncovered == 0 {
+		return
+	}
+
+	logger.Printf("%s has %d on-demand instances covered by reservations and %d uncovered ones, "+
+		"costing $%.4f/hour\n", a.name, covered, uncovered, uncoveredSpend)
+}
@@ -0,0 +1,314 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func testOnDemandInstance(id string, instanceType string, az string, asg string, price float64) *instance {
+	return &instance{
+		Instance: &ec2.Instance{
+			InstanceId:   aws.String(id),
+			InstanceType: aws.String(instanceType),
+			Placement:    &ec2.Placement{AvailabilityZone: aws.String(az)},
+			State:        &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			Tags: []*ec2.Tag{
+				{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String(asg)},
+			},
+		},
+		typeInfo: instanceTypeInformation{
+			instanceType: instanceType,
+			pricing:      prices{onDemand: price},
+		},
+		price: price,
+	}
+}
+
+func Test_loadSavingsPlansCoverage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autospotting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "valid.json")
+	invalid := filepath.Join(dir, "invalid.json")
+	outOfRange := filepath.Join(dir, "out_of_range.json")
+	ioutil.WriteFile(valid, []byte(`{"us-east-1": {"commitment": 1.5, "discount_percentage": 25},
+		"eu-west-1": {"commitment": 0.8}}`), 0600)
+	ioutil.WriteFile(invalid, []byte(`{"us-east-1": "a lot"}`), 0600)
+	ioutil.WriteFile(outOfRange, []byte(`{"us-east-1": {"commitment": 1.5, "discount_percentage": 100}}`), 0600)
+
+	tests := []struct {
+		name     string
+		path     string
+		expected map[string]float64
+		wantErr  bool
+	}{
+		{
+			name:     "valid file",
+			path:     valid,
+			expected: map[string]float64{"us-east-1": 2, "eu-west-1": 0.8},
+		},
+		{
+			name:    "invalid file",
+			path:    invalid,
+			wantErr: true,
+		},
+		{
+			name:    "discount out of range",
+			path:    outOfRange,
+			wantErr: true,
+		},
+		{
+			name:    "missing file",
+			path:    filepath.Join(dir, "missing.json"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadSavingsPlansCoverage(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadSavingsPlansCoverage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("loadSavingsPlansCoverage() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_reservationPlatform(t *testing.T) {
+	tests := map[string]string{
+		"":                         platformLinux,
+		"Linux/UNIX (Amazon VPC)":  platformLinux,
+		"Red Hat BYOL Linux":       platformLinux,
+		"Windows (Amazon VPC)":     platformWindows,
+		"Red Hat Enterprise Linux": platformRHEL,
+	}
+
+	for in, expected := range tests {
+		if got := reservationPlatform(in); got != expected {
+			t.Errorf("reservationPlatform(%q) = %v, expected %v", in, got, expected)
+		}
+	}
+}
+
+func Test_normalizedSize(t *testing.T) {
+	tests := []struct {
+		instanceType string
+		family       string
+		units        float64
+	}{
+		{instanceType: "t3.nano", family: "t3", units: 0.25},
+		{instanceType: "m5.large", family: "m5", units: 4},
+		{instanceType: "m5.xlarge", family: "m5", units: 8},
+		{instanceType: "c5.18xlarge", family: "c5", units: 144},
+		{instanceType: "m5.metal", family: "m5", units: 0},
+		{instanceType: "invalid", family: "", units: 0},
+	}
+
+	for _, tt := range tests {
+		family, units := normalizedSize(tt.instanceType)
+		if family != tt.family || units != tt.units {
+			t.Errorf("normalizedSize(%q) = %v, %v, expected %v, %v",
+				tt.instanceType, family, units, tt.family, tt.units)
+		}
+	}
+}
+
+func Test_region_determineReservationCoverage(t *testing.T) {
+	tests := []struct {
+		name     string
+		consider string
+		drio     *ec2.DescribeReservedInstancesOutput
+		drierr   error
+		savings  map[string]float64
+		expected map[string]string
+	}{
+		{
+			name:     "disabled",
+			consider: "false",
+			drio: &ec2.DescribeReservedInstancesOutput{
+				ReservedInstances: []*ec2.ReservedInstances{{
+					InstanceType:       aws.String("m5.large"),
+					InstanceCount:      aws.Int64(5),
+					ProductDescription: aws.String("Linux/UNIX"),
+					Scope:              aws.String("Region"),
+				}},
+			},
+			expected: map[string]string{},
+		},
+		{
+			name:     "error describing the reservations",
+			consider: "true",
+			drierr:   errors.New("denied"),
+			expected: map[string]string{},
+		},
+		{
+			name:     "unmanaged instances are covered first",
+			consider: "true",
+			drio: &ec2.DescribeReservedInstancesOutput{
+				ReservedInstances: []*ec2.ReservedInstances{{
+					InstanceType:       aws.String("m5.large"),
+					InstanceCount:      aws.Int64(2),
+					ProductDescription: aws.String("Linux/UNIX (Amazon VPC)"),
+					Scope:              aws.String("Region"),
+				}},
+			},
+			expected: map[string]string{
+				"i-other":   reservedInstanceCoverage,
+				"i-asg-m5a": reservedInstanceCoverage,
+			},
+		},
+		{
+			name:     "zonal reservations only cover their zone",
+			consider: "true",
+			drio: &ec2.DescribeReservedInstancesOutput{
+				ReservedInstances: []*ec2.ReservedInstances{{
+					InstanceType:       aws.String("m5.large"),
+					InstanceCount:      aws.Int64(5),
+					ProductDescription: aws.String("Linux/UNIX"),
+					Scope:              aws.String("Availability Zone"),
+					AvailabilityZone:   aws.String("1b"),
+				}},
+			},
+			expected: map[string]string{
+				"i-asg-m5b": reservedInstanceCoverage,
+			},
+		},
+		{
+			name:     "regional reservations are size flexible",
+			consider: "true",
+			drio: &ec2.DescribeReservedInstancesOutput{
+				ReservedInstances: []*ec2.ReservedInstances{{
+					InstanceType:       aws.String("m5.xlarge"),
+					InstanceCount:      aws.Int64(1),
+					ProductDescription: aws.String("Linux/UNIX"),
+					Scope:              aws.String("Region"),
+				}},
+			},
+			expected: map[string]string{
+				"i-other":   reservedInstanceCoverage,
+				"i-asg-m5a": reservedInstanceCoverage,
+			},
+		},
+		{
+			name:     "size flexible reservations only cover entire instances",
+			consider: "true",
+			drio: &ec2.DescribeReservedInstancesOutput{
+				ReservedInstances: []*ec2.ReservedInstances{{
+					InstanceType:       aws.String("m5.medium"),
+					InstanceCount:      aws.Int64(3),
+					ProductDescription: aws.String("Linux/UNIX"),
+					Scope:              aws.String("Region"),
+				}},
+			},
+			expected: map[string]string{
+				"i-other": reservedInstanceCoverage,
+			},
+		},
+		{
+			name:     "zonal reservations aren't size flexible",
+			consider: "true",
+			drio: &ec2.DescribeReservedInstancesOutput{
+				ReservedInstances: []*ec2.ReservedInstances{{
+					InstanceType:       aws.String("m5.xlarge"),
+					InstanceCount:      aws.Int64(1),
+					ProductDescription: aws.String("Linux/UNIX"),
+					Scope:              aws.String("Availability Zone"),
+					AvailabilityZone:   aws.String("1a"),
+				}},
+			},
+			expected: map[string]string{},
+		},
+		{
+			name:     "reservations for other platforms are ignored",
+			consider: "true",
+			drio: &ec2.DescribeReservedInstancesOutput{
+				ReservedInstances: []*ec2.ReservedInstances{{
+					InstanceType:       aws.String("m5.large"),
+					InstanceCount:      aws.Int64(5),
+					ProductDescription: aws.String("Windows"),
+					Scope:              aws.String("Region"),
+				}},
+			},
+			expected: map[string]string{},
+		},
+		{
+			name:     "savings plans cover the remaining instances",
+			consider: "true",
+			drio:     &ec2.DescribeReservedInstancesOutput{},
+			savings:  map[string]float64{"us-east-1": 0.25},
+			expected: map[string]string{
+				"i-other":   savingsPlanCoverage,
+				"i-asg-m5a": savingsPlanCoverage,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name: "us-east-1",
+				conf: &Config{
+					ConsiderReservedCapacity: tt.consider,
+					SavingsPlansCoverage:     tt.savings,
+				},
+				enabledASGs: []autoScalingGroup{{name: "asg"}},
+				services: connections{
+					ec2: mockEC2{drio: tt.drio, drierr: tt.drierr},
+				},
+				instances: makeInstancesWithCatalog(instanceMap{
+					"i-asg-m5a": testOnDemandInstance("i-asg-m5a", "m5.large", "1a", "asg", 0.1),
+					"i-asg-m5b": testOnDemandInstance("i-asg-m5b", "m5.large", "1b", "asg", 0.1),
+					"i-asg-c5":  testOnDemandInstance("i-asg-c5", "c5.large", "1a", "asg", 0.2),
+					"i-other":   testOnDemandInstance("i-other", "m5.large", "1a", "other", 0.1),
+				}),
+			}
+
+			r.determineReservationCoverage(context.Background())
+
+			got := make(map[string]string)
+			for i := range r.instances.instances() {
+				if i.coveredBy != "" {
+					got[*i.InstanceId] = i.coveredBy
+				}
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("determineReservationCoverage() covered %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_getUnprotectedOnDemandInstanceInAZ_skipsCovered(t *testing.T) {
+	covered := testOnDemandInstance("i-covered", "m5.large", "1a", "asg", 0.1)
+	covered.coveredBy = reservedInstanceCoverage
+	covered.region = &region{services: connections{ec2: mockEC2{}}}
+
+	a := &autoScalingGroup{
+		name:      "asg",
+		instances: makeInstancesWithCatalog(instanceMap{"i-covered": covered}),
+	}
+
+	if got := a.getUnprotectedOnDemandInstanceInAZ(aws.String("1a")); got != nil {
+		t.Errorf("expected the covered instance to be skipped, got %v", *got.InstanceId)
+	}
+	if got := a.getAnyOnDemandInstance(); got == nil {
+		t.Errorf("expected the covered instance to still be counted as on-demand")
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// spotInterruptionStateReason is the state reason code of the spot instances
+// terminated by EC2
+const spotInterruptionStateReason = "Server.SpotInstanceTermination"
+
+// reverseOnInterruptionsEnabled returns true if any of the enabled groups of
+// the region can be switched back to on-demand because of spot interruptions.
+func (r *region) reverseOnInterruptionsEnabled() bool {
+	if r.conf.ReverseInterruptionThreshold > 0 {
+		return true
+	}
+
+	for _, asg := range r.enabledASGs {
+		if asg.getTagValue(ReverseInterruptionThresholdTag) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// scanSpotInterruptions counts the spot instances recently terminated by EC2
+// for each group of the region. The terminated instances are only visible for
+// about an hour after their termination, so these are the interruptions from
+// the last hour.
+func (r *region) scanSpotInterruptions(ctx context.Context) error {
+	r.spotInterruptions = make(map[string]int)
+
+	input := &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name: aws.String("instance-state-name"),
+				Values: []*string{
+					aws.String(ec2.InstanceStateNameShuttingDown),
+					aws.String(ec2.InstanceStateNameTerminated),
+				},
+			},
+			{
+				Name:   aws.String("instance-lifecycle"),
+				Values: []*string{aws.String(ec2.InstanceLifecycleSpot)},
+			},
+			{
+				Name:   aws.String("state-reason-code"),
+				Values: []*string{aws.String(spotInterruptionStateReason)},
+			},
+		},
+	}
+
+	return r.services.ec2.DescribeInstancesPagesWithContext(ctx, input,
+		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, res := range page.Reservations {
+				for _, inst := range res.Instances {
+					if asgName := interruptedInstanceGroup(inst); asgName != "" {
+						r.spotInterruptions[asgName]++
+					}
+				}
+			}
+			return true
+		})
+}
+
+// interruptedInstanceGroup returns the name of the group an interrupted spot
+// instance belonged to, based on either the tag set by AutoScaling or the one
+// we set when launching it, since the latter is removed when detaching it.
+func interruptedInstanceGroup(inst *ec2.Instance) string {
+	if inst.StateReason != nil &&
+		aws.StringValue(inst.StateReason.Code) != spotInterruptionStateReason {
+		return ""
+	}
+
+	var launchedFor string
+	for _, tag := range inst.Tags {
+		switch aws.StringValue(tag.Key) {
+		case autoScalingGroupNameTag:
+			return aws.StringValue(tag.Value)
+		case "launched-for-asg":
+			launchedFor = aws.StringValue(tag.Value)
+		}
+	}
+	return launchedFor
+}
+
+// spotPriceRatio returns the ratio between the prices of the running spot
+// instances of the group and the on-demand prices of their instance types.
+func (a *autoScalingGroup) spotPriceRatio() (float64, bool) {
+	var spotPrices, onDemandPrices float64
+
+	for i := range a.instances.instances() {
+		if !i.isSpot() || i.State == nil ||
+			aws.StringValue(i.State.Name) != ec2.InstanceStateNameRunning {
+			continue
+		}
+		spotPrices += i.price
+		onDemandPrices += i.typeInfo.pricing.onDemand + i.typeInfo.pricing.premium
+	}
+
+	if onDemandPrices == 0 {
+		return 0, false
+	}
+	return spotPrices / onDemandPrices, true
+}
+
+// reverseReason explains why the group should go back to on-demand instances,
+// or returns an empty string if spot instances are still attractive.
+func (a *autoScalingGroup) reverseReason() string {
+	if maxRatio := a.config.ReverseSpotPriceRatio; maxRatio > 0 {
+		if ratio, ok := a.spotPriceRatio(); ok && ratio > maxRatio {
+			return fmt.Sprintf("the spot instances cost %.2f of their on-demand price, above the ratio of %v",
+				ratio, maxRatio)
+		}
+	}
+
+	if threshold := a.config.ReverseInterruptionThreshold; threshold > 0 {
+		if count := a.region.spotInterruptions[a.name]; int64(count) >= threshold {
+			return fmt.Sprintf("%d spot instances were interrupted in the last hour, reaching the threshold of %d",
+				count, threshold)
+		}
+	}
+	return ""
+}
+
+// loadReverseTarget requires all the instances of the group to be on-demand
+// when spot instances became unattractive, and for at least ReverseMinDuration
+// after they were last found unattractive, so the group doesn't flip back and
+// forth, such as when the interruptions of the last hour drop below the
+// threshold. The spot instances are then gradually replaced with on-demand
+// ones, by terminating them without decrementing the desired capacity, while
+// no new spot instances are launched.
+func (a *autoScalingGroup) loadReverseTarget(ctx context.Context, now time.Time) bool {
+	reason := a.reverseReason()
+
+	if reason != "" {
+		a.recordReverseTrigger(ctx, now)
+	} else {
+		triggeredAt, ok := a.lastReverseTrigger()
+		if !ok || now.Sub(triggeredAt) >= a.config.ReverseMinDuration {
+			return false
+		}
+		reason = fmt.Sprintf("the spot instances were found unattractive at %s, less than %v ago",
+			triggeredAt.Format(time.RFC3339), a.config.ReverseMinDuration)
+	}
+
+	a.minOnDemand = a.instances.count64()
+	logger.Println(a.region.name, a.name, "Going back to on-demand instances because", reason)
+	return true
+}
+
+// lastReverseTrigger returns the last time the spot instances of the group
+// were found unattractive, as recorded in its tag.
+func (a *autoScalingGroup) lastReverseTrigger() (time.Time, bool) {
+	tagValue := a.getTagValue(ReverseTriggeredAtTag)
+	if tagValue == nil {
+		return time.Time{}, false
+	}
+
+	triggeredAt, err := time.Parse(time.RFC3339, *tagValue)
+	if err != nil {
+		logger.Printf("Ignoring invalid value %s of tag %s\n", *tagValue, ReverseTriggeredAtTag)
+		return time.Time{}, false
+	}
+	return triggeredAt, true
+}
+
+// recordReverseTrigger tags the group with the time its spot instances were
+// found unattractive, which is kept across runs.
+func (a *autoScalingGroup) recordReverseTrigger(ctx context.Context, now time.Time) {
+	_, err := a.region.services.autoScaling.CreateOrUpdateTagsWithContext(ctx,
+		&autoscaling.CreateOrUpdateTagsInput{
+			Tags: []*autoscaling.Tag{{
+				ResourceId:        aws.String(a.name),
+				ResourceType:      aws.String("auto-scaling-group"),
+				Key:               aws.String(ReverseTriggeredAtTag),
+				Value:             aws.String(now.UTC().Format(time.RFC3339)),
+				PropagateAtLaunch: aws.Bool(false),
+			}},
+		})
+	if err != nil {
+		logger.Println(a.region.name, a.name, "Failed to tag the group with",
+			ReverseTriggeredAtTag, err.Error())
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func interruptedInstance(id string, tags map[string]string) *ec2.Instance {
+	inst := &ec2.Instance{
+		InstanceId:        aws.String(id),
+		InstanceLifecycle: aws.String(ec2.InstanceLifecycleSpot),
+		StateReason:       &ec2.StateReason{Code: aws.String(spotInterruptionStateReason)},
+	}
+	for k, v := range tags {
+		inst.Tags = append(inst.Tags, &ec2.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return inst
+}
+
+func Test_interruptedInstanceGroup(t *testing.T) {
+
+	tests := []struct {
+		name string
+		inst *ec2.Instance
+		want string
+	}{
+		{
+			name: "Instance of a group",
+			inst: interruptedInstance("i-1", map[string]string{autoScalingGroupNameTag: "asg-1"}),
+			want: "asg-1",
+		},
+		{
+			name: "Detached instance launched for a group",
+			inst: interruptedInstance("i-2", map[string]string{"launched-for-asg": "asg-2"}),
+			want: "asg-2",
+		},
+		{
+			name: "Instance not belonging to any group",
+			inst: interruptedInstance("i-3", map[string]string{"Name": "standalone"}),
+			want: "",
+		},
+		{
+			name: "Instance terminated for another reason",
+			inst: &ec2.Instance{
+				InstanceId:  aws.String("i-4"),
+				StateReason: &ec2.StateReason{Code: aws.String("Client.UserInitiatedShutdown")},
+				Tags: []*ec2.Tag{
+					{Key: aws.String(autoScalingGroupNameTag), Value: aws.String("asg-1")},
+				},
+			},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := interruptedInstanceGroup(tt.inst); got != tt.want {
+				t.Errorf("interruptedInstanceGroup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_region_scanSpotInterruptions(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		ec2     mockEC2
+		want    map[string]int
+		wantErr bool
+	}{
+		{
+			name: "Interruptions of multiple groups",
+			ec2: mockEC2{
+				dio: &ec2.DescribeInstancesOutput{
+					Reservations: []*ec2.Reservation{
+						{
+							Instances: []*ec2.Instance{
+								interruptedInstance("i-1", map[string]string{autoScalingGroupNameTag: "asg-1"}),
+								interruptedInstance("i-2", map[string]string{autoScalingGroupNameTag: "asg-1"}),
+								interruptedInstance("i-3", map[string]string{"launched-for-asg": "asg-2"}),
+								interruptedInstance("i-4", nil),
+							},
+						},
+					},
+				},
+			},
+			want: map[string]int{"asg-1": 2, "asg-2": 1},
+		},
+		{
+			name: "Error describing the instances",
+			ec2: mockEC2{
+				dio:    &ec2.DescribeInstancesOutput{},
+				diperr: errors.New("error"),
+			},
+			want:    map[string]int{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{services: connections{ec2: tt.ec2}}
+			err := r.scanSpotInterruptions(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("scanSpotInterruptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(r.spotInterruptions, tt.want) {
+				t.Errorf("scanSpotInterruptions() = %v, want %v", r.spotInterruptions, tt.want)
+			}
+		})
+	}
+}
+
+func Test_region_reverseOnInterruptionsEnabled(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		threshold int64
+		asgs      []autoScalingGroup
+		want      bool
+	}{
+		{
+			name: "Disabled",
+			asgs: []autoScalingGroup{{Group: &autoscaling.Group{}}},
+			want: false,
+		},
+		{
+			name:      "Enabled globally",
+			threshold: 3,
+			asgs:      []autoScalingGroup{{Group: &autoscaling.Group{}}},
+			want:      true,
+		},
+		{
+			name: "Enabled on a group",
+			asgs: []autoScalingGroup{
+				{Group: &autoscaling.Group{}},
+				{Group: &autoscaling.Group{
+					Tags: []*autoscaling.TagDescription{
+						{Key: aws.String(ReverseInterruptionThresholdTag), Value: aws.String("2")},
+					},
+				}},
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{ReverseInterruptionThreshold: tt.threshold},
+				},
+				enabledASGs: tt.asgs,
+			}
+			if got := r.reverseOnInterruptionsEnabled(); got != tt.want {
+				t.Errorf("reverseOnInterruptionsEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadReverseTarget(t *testing.T) {
+
+	running := &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)}
+	pricing := prices{onDemand: 1}
+
+	groupInstances := func() instances {
+		return makeInstancesWithCatalog(
+			instanceMap{
+				"od-1": {
+					Instance: &ec2.Instance{InstanceId: aws.String("od-1"), State: running},
+					typeInfo: instanceTypeInformation{pricing: pricing},
+					price:    1,
+				},
+				"spot-1": {
+					Instance: &ec2.Instance{
+						InstanceId:        aws.String("spot-1"),
+						InstanceLifecycle: aws.String(ec2.InstanceLifecycleSpot),
+						State:             running,
+					},
+					typeInfo: instanceTypeInformation{pricing: pricing},
+					price:    0.7,
+				},
+				"spot-2": {
+					Instance: &ec2.Instance{
+						InstanceId:        aws.String("spot-2"),
+						InstanceLifecycle: aws.String(ec2.InstanceLifecycleSpot),
+						State:             running,
+					},
+					typeInfo: instanceTypeInformation{pricing: pricing},
+					price:    0.9,
+				},
+			},
+		)
+	}
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		config        AutoScalingConfig
+		interruptions map[string]int
+		triggeredAt   string
+		want          bool
+		wantOnDemand  int64
+		wantTagged    bool
+	}{
+		{
+			name:         "Disabled",
+			want:         false,
+			wantOnDemand: 1,
+		},
+		{
+			name:         "Spot prices below the ratio",
+			config:       AutoScalingConfig{ReverseSpotPriceRatio: 0.9},
+			want:         false,
+			wantOnDemand: 1,
+		},
+		{
+			name:         "Spot prices above the ratio",
+			config:       AutoScalingConfig{ReverseSpotPriceRatio: 0.75},
+			want:         true,
+			wantOnDemand: 3,
+			wantTagged:   true,
+		},
+		{
+			name:          "Interruptions below the threshold",
+			config:        AutoScalingConfig{ReverseInterruptionThreshold: 3},
+			interruptions: map[string]int{"asg-test": 2, "other": 5},
+			want:          false,
+			wantOnDemand:  1,
+		},
+		{
+			name:          "Interruptions reaching the threshold",
+			config:        AutoScalingConfig{ReverseInterruptionThreshold: 3},
+			interruptions: map[string]int{"asg-test": 3},
+			want:          true,
+			wantOnDemand:  3,
+			wantTagged:    true,
+		},
+		{
+			name: "Interruptions dropped within the minimum duration",
+			config: AutoScalingConfig{ReverseInterruptionThreshold: 3,
+				ReverseMinDuration: 3 * time.Hour},
+			interruptions: map[string]int{"asg-test": 1},
+			triggeredAt:   "2020-01-01T10:00:00Z",
+			want:          true,
+			wantOnDemand:  3,
+		},
+		{
+			name: "Interruptions dropped after the minimum duration",
+			config: AutoScalingConfig{ReverseInterruptionThreshold: 3,
+				ReverseMinDuration: 3 * time.Hour},
+			interruptions: map[string]int{"asg-test": 1},
+			triggeredAt:   "2020-01-01T08:00:00Z",
+			want:          false,
+			wantOnDemand:  1,
+		},
+		{
+			name: "Invalid trigger time",
+			config: AutoScalingConfig{ReverseInterruptionThreshold: 3,
+				ReverseMinDuration: 3 * time.Hour},
+			triggeredAt:  "yesterday",
+			want:         false,
+			wantOnDemand: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tagged []*autoscaling.CreateOrUpdateTagsInput
+
+			group := &autoscaling.Group{}
+			if tt.triggeredAt != "" {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(ReverseTriggeredAtTag), Value: aws.String(tt.triggeredAt)},
+				}
+			}
+
+			a := &autoScalingGroup{
+				Group: group,
+				name:  "asg-test",
+				region: &region{
+					name:              "us-east-1",
+					spotInterruptions: tt.interruptions,
+					services:          connections{autoScaling: mockASG{coutiInputs: &tagged}},
+				},
+				instances:   groupInstances(),
+				minOnDemand: 1,
+				config:      tt.config,
+			}
+			got := a.loadReverseTarget(context.Background(), now)
+			if got != tt.want || a.minOnDemand != tt.wantOnDemand {
+				t.Errorf("loadReverseTarget() = %v with minOnDemand %d, want %v with %d",
+					got, a.minOnDemand, tt.want, tt.wantOnDemand)
+			}
+
+			if tt.wantTagged != (len(tagged) == 1) {
+				t.Fatalf("tagged the group %d times, want tagged %v", len(tagged), tt.wantTagged)
+			}
+			if tt.wantTagged && aws.StringValue(tagged[0].Tags[0].Value) != "2020-01-01T12:00:00Z" {
+				t.Errorf("tagged the group with %s", aws.StringValue(tagged[0].Tags[0].Value))
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_reverse_keepsCapacity(t *testing.T) {
+
+	running := &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)}
+	member := func(id string) *autoscaling.Instance {
+		return &autoscaling.Instance{InstanceId: aws.String(id),
+			LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+			HealthStatus:   aws.String("Healthy")}
+	}
+
+	var terminated []*autoscaling.TerminateInstanceInAutoScalingGroupInput
+
+	a := &autoScalingGroup{
+		Group: &autoscaling.Group{
+			DesiredCapacity: aws.Int64(3),
+			Instances:       []*autoscaling.Instance{member("od-1"), member("spot-1"), member("spot-2")},
+		},
+		name: "asg-test",
+		region: &region{
+			name:              "us-east-1",
+			spotInterruptions: map[string]int{"asg-test": 3},
+			services:          connections{autoScaling: mockASG{tiiasgInputs: &terminated}},
+		},
+		instances: makeInstancesWithCatalog(instanceMap{
+			"od-1": {Instance: &ec2.Instance{InstanceId: aws.String("od-1"), State: running}},
+			"spot-1": {Instance: &ec2.Instance{InstanceId: aws.String("spot-1"), State: running,
+				InstanceLifecycle: aws.String(ec2.InstanceLifecycleSpot)}},
+			"spot-2": {Instance: &ec2.Instance{InstanceId: aws.String("spot-2"), State: running,
+				InstanceLifecycle: aws.String(ec2.InstanceLifecycleSpot)}},
+		}),
+		minOnDemand: 1,
+		config:      AutoScalingConfig{ReverseInterruptionThreshold: 3, MaxUnavailable: 1},
+	}
+
+	if !a.loadReverseTarget(context.Background(), time.Now()) {
+		t.Fatalf("loadReverseTarget() = false, want true")
+	}
+	a.needReplaceOnDemandInstances(context.Background())
+
+	if len(terminated) != 1 {
+		t.Fatalf("terminated %d instances, want 1", len(terminated))
+	}
+	if id := aws.StringValue(terminated[0].InstanceId); id != "spot-1" {
+		t.Errorf("terminated %s, want spot-1", id)
+	}
+	if aws.BoolValue(terminated[0].ShouldDecrementDesiredCapacity) {
+		t.Errorf("terminated the spot instance decrementing the desired capacity")
+	}
+	if *a.DesiredCapacity != 3 {
+		t.Errorf("desired capacity = %d, want 3", *a.DesiredCapacity)
+	}
+}
+
+func Test_instance_priceIncompatibilityReasonReverseRatio(t *testing.T) {
+
+	i := &instance{
+		price: 1,
+		asg: &autoScalingGroup{
+			config: AutoScalingConfig{ReverseSpotPriceRatio: 0.6},
+		},
+	}
+
+	if reason := i.priceIncompatibilityReason(0.5); reason != "" {
+		t.Errorf("priceIncompatibilityReason() = %q, want no reason", reason)
+	}
+	if reason := i.priceIncompatibilityReason(0.7); reason == "" {
+		t.Errorf("priceIncompatibilityReason() accepted a spot price above the reverse ratio")
+	}
+}
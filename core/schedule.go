@@ -4,17 +4,164 @@
 package autospotting
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/robfig/cron/v3"
 )
 
+const (
+	// scheduleWindowSeparator separates the multiple windows of a schedule
+	scheduleWindowSeparator = ";"
+
+	// scheduleExcludePrefix marks the windows excluded from the schedule
+	scheduleExcludePrefix = "!"
+
+	// the bit set by the cron parser for the fields given as "*"
+	cronStarBit = 1 << 63
+
+	blackoutDateLayout     = "2006-01-02"
+	blackoutDateTimeLayout = "2006-01-02T15:04"
+)
+
+// Parsers for the simplified hour and day of week rules we initially supported,
+// and for the standard five fields cron expressions
+var (
+	hourDowParser  = cron.NewParser(cron.Hour | cron.Dow)
+	fullCronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+)
+
+// scheduleWindow is one of the cron expressions of a schedule
+type scheduleWindow struct {
+	exclude bool
+
+	// only set for the simplified hour and day of week rules
+	hourDow cron.Schedule
+
+	// only set for the standard cron expressions
+	spec *cron.SpecSchedule
+
+	// the day of month and day of week fields both need to match, which is
+	// the case for the nth day of week of the month, such as "6#1"
+	matchDomAndDow bool
+}
+
+// parseSchedule parses a schedule made of one or more cron expressions
+// separated by semicolons. Each of them can be either a simplified rule made
+// of hours and days of week, such as "9-18 1-5", or a standard cron rule with
+// minutes, hours, days of month, months and days of week fields, such as
+// "30-59 8 * * 1-5". The day of week field of the standard rules also accepts
+// the nth day of week of the month, like "6#1" for the first Saturday. The
+// expressions prefixed with "!" are excluded from the schedule.
+func parseSchedule(crontab string) ([]scheduleWindow, error) {
+	var windows []scheduleWindow
+
+	for _, expr := range strings.Split(crontab, scheduleWindowSeparator) {
+		expr = strings.TrimSpace(expr)
+		if expr == "" {
+			continue
+		}
+
+		var w scheduleWindow
+		if strings.HasPrefix(expr, scheduleExcludePrefix) {
+			w.exclude = true
+			expr = strings.TrimSpace(strings.TrimPrefix(expr, scheduleExcludePrefix))
+		}
+
+		fields := strings.Fields(expr)
+		switch len(fields) {
+		case 2:
+			sched, err := hourDowParser.Parse(expr)
+			if err != nil {
+				return nil, err
+			}
+			w.hourDow = sched
+
+		case 5:
+			fields, nth, err := expandNthWeekday(fields)
+			if err != nil {
+				return nil, err
+			}
+			sched, err := fullCronParser.Parse(strings.Join(fields, " "))
+			if err != nil {
+				return nil, err
+			}
+			w.spec, w.matchDomAndDow = sched.(*cron.SpecSchedule), nth
+
+		default:
+			return nil, fmt.Errorf("invalid schedule %q, expected either 2 or 5 fields", expr)
+		}
+
+		windows = append(windows, w)
+	}
+
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("empty schedule %q", crontab)
+	}
+	return windows, nil
+}
+
+// expandNthWeekday converts the nth day of week of the month given as "6#1" in
+// the day of week field into the equivalent day of week and days of month
+// range, which need to match at the same time.
+func expandNthWeekday(fields []string) ([]string, bool, error) {
+	dow := fields[4]
+	if !strings.Contains(dow, "#") {
+		return fields, false, nil
+	}
+
+	parts := strings.Split(dow, "#")
+	n, err := strconv.Atoi(parts[len(parts)-1])
+	if len(parts) != 2 || err != nil || n < 1 || n > 5 {
+		return nil, false, fmt.Errorf("invalid nth day of week %q, expected a day of week followed by #1 to #5", dow)
+	}
+
+	if fields[2] != "*" {
+		return nil, false, fmt.Errorf("the nth day of week %q can't be combined with days of month", dow)
+	}
+
+	expanded := append([]string{}, fields...)
+	expanded[2] = fmt.Sprintf("%d-%d", (n-1)*7+1, n*7)
+	expanded[4] = parts[0]
+	return expanded, true, nil
+}
+
+// matches checks if the time, already converted to the schedule's timezone,
+// is inside the window.
+func (w scheduleWindow) matches(t time.Time) bool {
+	if w.hourDow != nil {
+		// When inside the cron interval, the next event from exactly an hour ago
+		// and the next event from now are exactly one hour apart
+		prev := w.hourDow.Next(t.Add(-1 * time.Hour))
+		next := w.hourDow.Next(t)
+		return next == prev.Add(1*time.Hour)
+	}
+
+	s := w.spec
+	if 1<<uint(t.Minute())&s.Minute == 0 ||
+		1<<uint(t.Hour())&s.Hour == 0 ||
+		1<<uint(t.Month())&s.Month == 0 {
+		return false
+	}
+
+	domMatch := 1<<uint(t.Day())&s.Dom > 0
+	dowMatch := 1<<uint(t.Weekday())&s.Dow > 0
+
+	// same as cron, the days match if either of the restricted fields matches
+	if w.matchDomAndDow || s.Dom&cronStarBit > 0 || s.Dow&cronStarBit > 0 {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
 // insideSchedule returns true if the time given in the t parameter is matching
-// the implified cronrab-like interval restricted to only hours and days of the
-// week. Because the cron library be use only supports the local time, the
-// crontab entry will have to be created accoringly. When executed in Lambda the
-// runtime's local time will always be UTC, so users have to be made aware of
-// this through the documentation.
+// any of the windows of the schedule, evaluated in the given timezone, without
+// matching any of its excluded windows. When the schedule only has excluded
+// windows it matches at any other time. When executed in Lambda the runtime's
+// local time will always be UTC, so the timezone needs to be configured for
+// the schedule to follow the local time, including the DST changes.
 func insideSchedule(t time.Time, crontab string, timezone string) (bool, error) {
 	// Get the timezone, will cause an error if timezone is incorrect
 	tz, err := time.LoadLocation(timezone)
@@ -24,28 +171,121 @@ func insideSchedule(t time.Time, crontab string, timezone string) (bool, error)
 		return false, err
 	}
 
-	// Create a new cron job runner using the location details and a custom parser
-	c := cron.New(cron.WithLocation(tz), cron.WithParser(cron.NewParser(cron.Hour|cron.Dow)))
-	// Schedule an empty job based on out crontab
-	entry, err := c.AddFunc(crontab, nil)
-
+	windows, err := parseSchedule(crontab)
 	if err != nil {
 		logger.Println(err)
 		return false, err
 	}
 
-	// Grab the schedule for the job created above to use for calculations below.
-	sched := c.Entry(entry).Schedule
+	local := t.In(tz)
+	included, hasIncludes := false, false
+
+	for _, w := range windows {
+		if w.exclude {
+			if w.matches(local) {
+				return false, nil
+			}
+			continue
+		}
+
+		hasIncludes = true
+		if !included && w.matches(local) {
+			included = true
+		}
+	}
+
+	return included || !hasIncludes, nil
+}
+
+// blackoutWindow is a time interval in which no actions are taken
+type blackoutWindow struct {
+	start, end time.Time
+}
+
+// parseBlackoutWindows parses a comma separated list of dates, such as
+// "2020-12-25", or intervals made of dates or dates and times separated by a
+// slash, such as "2020-12-24/2020-12-26" or "2020-12-18T18:00/2021-01-04T09:00".
+// The dates are interpreted in the given timezone and the end dates are
+// inclusive, while the end times are exclusive.
+func parseBlackoutWindows(blackouts string, tz *time.Location) ([]blackoutWindow, error) {
+	var windows []blackoutWindow
 
-	// When inside the cron interval, the next event from exactly an hour ago and the
-	// next event from now are exactly one hour apart
-	prev := sched.Next(t.In(tz).Add(-1 * time.Hour))
-	next := sched.Next(t.In(tz))
+	for _, item := range strings.Split(blackouts, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
 
-	if next == prev.Add(1*time.Hour) {
-		return true, nil
+		bounds := strings.Split(item, "/")
+		if len(bounds) > 2 {
+			return nil, fmt.Errorf("invalid blackout window %q", item)
+		}
+
+		start, _, err := parseBlackoutTime(bounds[0], tz)
+		if err != nil {
+			return nil, err
+		}
+
+		end, endIsDate, err := parseBlackoutTime(bounds[len(bounds)-1], tz)
+		if err != nil {
+			return nil, err
+		}
+
+		if endIsDate {
+			end = end.AddDate(0, 0, 1)
+		}
+
+		if !end.After(start) {
+			return nil, fmt.Errorf("invalid blackout window %q, it ends before it starts", item)
+		}
+		windows = append(windows, blackoutWindow{start: start, end: end})
+	}
+	return windows, nil
+}
+
+// parseBlackoutTime parses either a date or a date and time, returning true
+// for dates.
+func parseBlackoutTime(value string, tz *time.Location) (time.Time, bool, error) {
+	value = strings.TrimSpace(value)
+
+	if t, err := time.ParseInLocation(blackoutDateLayout, value, tz); err == nil {
+		return t, true, nil
 	}
-	return false, nil
+
+	t, err := time.ParseInLocation(blackoutDateTimeLayout, value, tz)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid blackout date %q, expected %s or %s",
+			value, blackoutDateLayout, blackoutDateTimeLayout)
+	}
+	return t, false, nil
+}
+
+// insideBlackout returns true if the time is inside any of the blackout
+// windows, such as holidays or release freezes, and also when they can't be
+// parsed, so we don't take any actions in case of configuration mistakes.
+func insideBlackout(t time.Time, blackouts string, timezone string) bool {
+	if strings.TrimSpace(blackouts) == "" {
+		return false
+	}
+
+	tz, err := time.LoadLocation(timezone)
+	if err != nil {
+		logger.Println(err)
+		return true
+	}
+
+	windows, err := parseBlackoutWindows(blackouts, tz)
+	if err != nil {
+		logger.Println(err)
+		return true
+	}
+
+	for _, w := range windows {
+		if !t.Before(w.start) && t.Before(w.end) {
+			return true
+		}
+	}
+	return false
 }
 
 // returns true if the schedule is "on" and we're inside the interval also
@@ -202,3 +202,310 @@ func Test_runAction(t *testing.T) {
 		})
 	}
 }
+
+func Test_insideScheduleWindows(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		crontab string
+		t       time.Time
+		want    bool
+	}{
+		{
+			name:    "Weekday before the 8:30 start",
+			crontab: "30-59 8 * * 1-5; * 9-17 * * 1-5",
+			t:       time.Date(2019, time.May, 9, 8, 29, 0, 0, time.UTC),
+			want:    false,
+		},
+		{
+			name:    "Weekday at the 8:30 start",
+			crontab: "30-59 8 * * 1-5; * 9-17 * * 1-5",
+			t:       time.Date(2019, time.May, 9, 8, 30, 0, 0, time.UTC),
+			want:    true,
+		},
+		{
+			name:    "Weekday just before the 18:00 end",
+			crontab: "30-59 8 * * 1-5; * 9-17 * * 1-5",
+			t:       time.Date(2019, time.May, 9, 17, 59, 0, 0, time.UTC),
+			want:    true,
+		},
+		{
+			name:    "Weekday at the 18:00 end",
+			crontab: "30-59 8 * * 1-5; * 9-17 * * 1-5",
+			t:       time.Date(2019, time.May, 9, 18, 0, 0, 0, time.UTC),
+			want:    false,
+		},
+		{
+			name:    "First Saturday of the month",
+			crontab: "30-59 8 * * 1-5; * 9-17 * * 1-5; * 9-17 * * 6#1",
+			t:       time.Date(2019, time.May, 4, 10, 0, 0, 0, time.UTC),
+			want:    true,
+		},
+		{
+			name:    "Second Saturday of the month",
+			crontab: "30-59 8 * * 1-5; * 9-17 * * 1-5; * 9-17 * * 6#1",
+			t:       time.Date(2019, time.May, 11, 10, 0, 0, 0, time.UTC),
+			want:    false,
+		},
+		{
+			name:    "First day of the month, but not a Saturday",
+			crontab: "* 9-17 * * SAT#1",
+			t:       time.Date(2019, time.May, 1, 10, 0, 0, 0, time.UTC),
+			want:    false,
+		},
+		{
+			name:    "Days of month or days of week, like in cron",
+			crontab: "* * 1 * 1",
+			t:       time.Date(2019, time.May, 6, 10, 0, 0, 0, time.UTC),
+			want:    true,
+		},
+		{
+			name:    "Neither the day of month nor the day of week",
+			crontab: "* * 1 * 1",
+			t:       time.Date(2019, time.May, 7, 10, 0, 0, 0, time.UTC),
+			want:    false,
+		},
+		{
+			name:    "Inside the month",
+			crontab: "* * * 12 *",
+			t:       time.Date(2019, time.December, 7, 10, 0, 0, 0, time.UTC),
+			want:    true,
+		},
+		{
+			name:    "Outside the month",
+			crontab: "* * * 12 *",
+			t:       time.Date(2019, time.November, 7, 10, 0, 0, 0, time.UTC),
+			want:    false,
+		},
+		{
+			name:    "Inside an excluded window",
+			crontab: "* 9-17 * * 1-5; !* 12 * * *",
+			t:       time.Date(2019, time.May, 9, 12, 30, 0, 0, time.UTC),
+			want:    false,
+		},
+		{
+			name:    "Outside the excluded window",
+			crontab: "* 9-17 * * 1-5; !* 12 * * *",
+			t:       time.Date(2019, time.May, 9, 11, 30, 0, 0, time.UTC),
+			want:    true,
+		},
+		{
+			name:    "Only excluded windows, inside",
+			crontab: "!* * 25 12 *",
+			t:       time.Date(2019, time.December, 25, 10, 0, 0, 0, time.UTC),
+			want:    false,
+		},
+		{
+			name:    "Only excluded windows, outside",
+			crontab: "!* * 25 12 *",
+			t:       time.Date(2019, time.December, 24, 10, 0, 0, 0, time.UTC),
+			want:    true,
+		},
+		{
+			name:    "Simplified and standard rules combined",
+			crontab: "9-18 1-5; * 10 * * 6",
+			t:       time.Date(2019, time.May, 11, 10, 15, 0, 0, time.UTC),
+			want:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := insideSchedule(tt.t, tt.crontab, "UTC")
+			if err != nil || got != tt.want {
+				t.Errorf("insideSchedule() = %v, %v want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_insideScheduleDST(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		crontab  string
+		t        time.Time
+		timezone string
+		want     bool
+	}{
+		{
+			name:     "Before the spring DST change, 8:30 GMT",
+			crontab:  "* 9-17 * * *",
+			t:        time.Date(2019, time.March, 30, 8, 30, 0, 0, time.UTC),
+			timezone: "Europe/London",
+			want:     false,
+		},
+		{
+			name:     "After the spring DST change, 9:30 BST",
+			crontab:  "* 9-17 * * *",
+			t:        time.Date(2019, time.March, 31, 8, 30, 0, 0, time.UTC),
+			timezone: "Europe/London",
+			want:     true,
+		},
+		{
+			name:     "Simplified rule after the spring DST change, 9:30 BST",
+			crontab:  "9-18 *",
+			t:        time.Date(2019, time.March, 31, 8, 30, 0, 0, time.UTC),
+			timezone: "Europe/London",
+			want:     true,
+		},
+		{
+			name:     "Before the autumn DST change, 9:30 BST",
+			crontab:  "* 9-17 * * *",
+			t:        time.Date(2019, time.October, 26, 8, 30, 0, 0, time.UTC),
+			timezone: "Europe/London",
+			want:     true,
+		},
+		{
+			name:     "After the autumn DST change, 8:30 GMT",
+			crontab:  "* 9-17 * * *",
+			t:        time.Date(2019, time.October, 27, 8, 30, 0, 0, time.UTC),
+			timezone: "Europe/London",
+			want:     false,
+		},
+		{
+			name:     "Hour skipped by the spring DST change, 1:30 EST",
+			crontab:  "* 2 * * *",
+			t:        time.Date(2019, time.March, 10, 6, 30, 0, 0, time.UTC),
+			timezone: "America/New_York",
+			want:     false,
+		},
+		{
+			name:     "Hour skipped by the spring DST change, 3:30 EDT",
+			crontab:  "* 2 * * *",
+			t:        time.Date(2019, time.March, 10, 7, 30, 0, 0, time.UTC),
+			timezone: "America/New_York",
+			want:     false,
+		},
+		{
+			name:     "Hour repeated by the autumn DST change, first 1:30 EDT",
+			crontab:  "* 1 * * *",
+			t:        time.Date(2019, time.November, 3, 5, 30, 0, 0, time.UTC),
+			timezone: "America/New_York",
+			want:     true,
+		},
+		{
+			name:     "Hour repeated by the autumn DST change, second 1:30 EST",
+			crontab:  "* 1 * * *",
+			t:        time.Date(2019, time.November, 3, 6, 30, 0, 0, time.UTC),
+			timezone: "America/New_York",
+			want:     true,
+		},
+		{
+			name:     "After the hour repeated by the autumn DST change, 2:30 EST",
+			crontab:  "* 1 * * *",
+			t:        time.Date(2019, time.November, 3, 7, 30, 0, 0, time.UTC),
+			timezone: "America/New_York",
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := insideSchedule(tt.t, tt.crontab, tt.timezone)
+			if err != nil || got != tt.want {
+				t.Errorf("insideSchedule() = %v, %v want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseScheduleErrors(t *testing.T) {
+	for _, crontab := range []string{
+		"",
+		" ; ",
+		"* * * *",
+		"* 25 * * *",
+		"* * 1 * 6#1",
+		"* * * * 6#6",
+		"* * * * 6#1#2",
+		"9-18 1-5; 9- 1-5",
+	} {
+		t.Run(crontab, func(t *testing.T) {
+			if _, err := parseSchedule(crontab); err == nil {
+				t.Errorf("parseSchedule(%q) expected an error", crontab)
+			}
+		})
+	}
+}
+
+func Test_insideBlackout(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		blackouts string
+		t         time.Time
+		timezone  string
+		want      bool
+	}{
+		{
+			name: "No blackout windows",
+			t:    time.Date(2019, time.December, 25, 10, 0, 0, 0, time.UTC),
+			want: false,
+		},
+		{
+			name:      "Inside a blackout date",
+			blackouts: "2019-12-25",
+			t:         time.Date(2019, time.December, 25, 23, 59, 0, 0, time.UTC),
+			want:      true,
+		},
+		{
+			name:      "After a blackout date",
+			blackouts: "2019-12-25",
+			t:         time.Date(2019, time.December, 26, 0, 0, 0, 0, time.UTC),
+			want:      false,
+		},
+		{
+			name:      "Inside the last day of a dates interval",
+			blackouts: "2019-12-24/2019-12-26, 2020-01-01",
+			t:         time.Date(2019, time.December, 26, 12, 0, 0, 0, time.UTC),
+			want:      true,
+		},
+		{
+			name:      "Inside the second blackout window",
+			blackouts: "2019-12-24/2019-12-26, 2020-01-01",
+			t:         time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC),
+			want:      true,
+		},
+		{
+			name:      "Just before the end of a release freeze",
+			blackouts: "2019-12-20T18:00/2020-01-02T09:00",
+			t:         time.Date(2020, time.January, 2, 8, 59, 0, 0, time.UTC),
+			want:      true,
+		},
+		{
+			name:      "At the end of a release freeze",
+			blackouts: "2019-12-20T18:00/2020-01-02T09:00",
+			t:         time.Date(2020, time.January, 2, 9, 0, 0, 0, time.UTC),
+			want:      false,
+		},
+		{
+			name:      "Blackout date in the timezone",
+			blackouts: "2019-12-25",
+			t:         time.Date(2019, time.December, 26, 3, 0, 0, 0, time.UTC),
+			timezone:  "America/New_York",
+			want:      true,
+		},
+		{
+			name:      "Invalid blackout date",
+			blackouts: "2019-13-01",
+			t:         time.Date(2019, time.December, 25, 10, 0, 0, 0, time.UTC),
+			want:      true,
+		},
+		{
+			name:      "Blackout window ending before it starts",
+			blackouts: "2019-12-26/2019-12-24",
+			t:         time.Date(2019, time.May, 9, 10, 0, 0, 0, time.UTC),
+			want:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timezone := tt.timezone
+			if timezone == "" {
+				timezone = "UTC"
+			}
+			if got := insideBlackout(tt.t, tt.blackouts, timezone); got != tt.want {
+				t.Errorf("insideBlackout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// selectorNameKey is the key matching the name of the AutoScaling group in the
+// selector expressions, instead of the value of a tag
+const selectorNameKey = "asg:name"
+
+// groupSelector decides if an AutoScaling group is enabled
+type groupSelector func(group *autoscaling.Group) bool
+
+// isGroupEnabled checks if the group is handled by AutoSpotting, according to
+// the selector if set, otherwise according to the tag filters in the given
+// filtering mode. It's shared by the regional scans and the spot termination
+// events, so they always agree on the enabled groups.
+func isGroupEnabled(group *autoscaling.Group, selector groupSelector,
+	tagFilteringMode string, tagsToMatch []Tag) bool {
+
+	if selector != nil {
+		return selector(group)
+	}
+
+	optInFilterMode := (tagFilteringMode != "opt-out")
+	return optInFilterMode == isASGWithMatchingTags(group, tagsToMatch)
+}
+
+// loadGroupSelector parses the selector expression, returning a nil selector
+// when it's empty.
+func loadGroupSelector(expr string) (groupSelector, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	return parseGroupSelector(expr)
+}
+
+// parseGroupSelector parses a selector expression such as
+// `env in (dev,staging) and not team=payments`, made of the following terms
+// combined with "and", "or", "not" and parentheses:
+//
+//	key              the group has the tag
+//	key=glob         the group has the tag, with a value matching the glob
+//	key!=glob        the group doesn't have the tag with a matching value
+//	key~regex        the group has the tag, with a value matching the regex
+//	key in (a,b*)    the group has the tag, with a value matching any glob
+//
+// The asg:name key matches the name of the group instead of a tag. The keys
+// and values containing spaces or special characters can be double quoted.
+func parseGroupSelector(expr string) (groupSelector, error) {
+	tokens, err := tokenizeSelector(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &selectorParser{tokens: tokens}
+	selector, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok != nil {
+		return nil, fmt.Errorf("unexpected %q", tok.text)
+	}
+	return selector, nil
+}
+
+type selectorTokenKind int
+
+const (
+	selectorWord selectorTokenKind = iota
+	selectorString
+	selectorSymbol
+)
+
+type selectorToken struct {
+	kind selectorTokenKind
+	text string
+}
+
+// the symbols separating the words, the longest ones first
+var selectorSymbols = []string{"!=", "=", "~", "(", ")", ","}
+
+func tokenizeSelector(expr string) ([]selectorToken, error) {
+	var tokens []selectorToken
+
+	for i := 0; i < len(expr); {
+		c := expr[i]
+
+		if isSelectorSpace(c) {
+			i++
+			continue
+		}
+
+		if c == '"' {
+			quoted, err := strconv.QuotedPrefix(expr[i:])
+			if err != nil {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			text, _ := strconv.Unquote(quoted)
+			tokens = append(tokens, selectorToken{kind: selectorString, text: text})
+			i += len(quoted)
+			continue
+		}
+
+		if symbol := selectorSymbolAt(expr[i:]); symbol != "" {
+			tokens = append(tokens, selectorToken{kind: selectorSymbol, text: symbol})
+			i += len(symbol)
+			continue
+		}
+
+		start := i
+		for i < len(expr) && !isSelectorSpace(expr[i]) &&
+			expr[i] != '"' && selectorSymbolAt(expr[i:]) == "" {
+			i++
+		}
+		if start == i {
+			return nil, fmt.Errorf("unexpected %q at position %d", expr[i], i)
+		}
+		tokens = append(tokens, selectorToken{kind: selectorWord, text: expr[start:i]})
+	}
+	return tokens, nil
+}
+
+func isSelectorSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func selectorSymbolAt(s string) string {
+	for _, symbol := range selectorSymbols {
+		if strings.HasPrefix(s, symbol) {
+			return symbol
+		}
+	}
+	return ""
+}
+
+// selectorParser is a recursive descent parser of the selector expressions,
+// "not" taking precedence over "and", which takes precedence over "or"
+type selectorParser struct {
+	tokens []selectorToken
+	pos    int
+}
+
+func (p *selectorParser) peek() *selectorToken {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.pos]
+}
+
+func (p *selectorParser) next() *selectorToken {
+	tok := p.peek()
+	if tok != nil {
+		p.pos++
+	}
+	return tok
+}
+
+// acceptKeyword consumes the next token if it's the given unquoted keyword
+func (p *selectorParser) acceptKeyword(keyword string) bool {
+	if tok := p.peek(); tok != nil && tok.kind == selectorWord &&
+		strings.EqualFold(tok.text, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// acceptSymbol consumes the next token if it's the given symbol
+func (p *selectorParser) acceptSymbol(symbol string) bool {
+	if tok := p.peek(); tok != nil && tok.kind == selectorSymbol && tok.text == symbol {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *selectorParser) parseOr() (groupSelector, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orSelector(left, right)
+	}
+	return left, nil
+}
+
+func (p *selectorParser) parseAnd() (groupSelector, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andSelector(left, right)
+	}
+	return left, nil
+}
+
+func (p *selectorParser) parseNot() (groupSelector, error) {
+	if p.acceptKeyword("not") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(group *autoscaling.Group) bool { return !operand(group) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *selectorParser) parsePrimary() (groupSelector, error) {
+	if p.acceptSymbol("(") {
+		selector, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptSymbol(")") {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return selector, nil
+	}
+
+	key, err := p.parseValue("tag key")
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.acceptSymbol("="):
+		glob, err := p.parseGlob()
+		if err != nil {
+			return nil, err
+		}
+		return valueSelector(key, glob), nil
+
+	case p.acceptSymbol("!="):
+		glob, err := p.parseGlob()
+		if err != nil {
+			return nil, err
+		}
+		matches := valueSelector(key, glob)
+		return func(group *autoscaling.Group) bool { return !matches(group) }, nil
+
+	case p.acceptSymbol("~"):
+		expr, err := p.parseValue("regular expression")
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		return valueSelector(key, re.MatchString), nil
+
+	case p.acceptKeyword("in"):
+		globs, err := p.parseGlobList()
+		if err != nil {
+			return nil, err
+		}
+		return valueSelector(key, func(value string) bool {
+			for _, glob := range globs {
+				if glob(value) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	}
+
+	return valueSelector(key, func(string) bool { return true }), nil
+}
+
+// parseValue parses a tag key or value, which can't be a keyword unless it's
+// quoted
+func (p *selectorParser) parseValue(what string) (string, error) {
+	tok := p.next()
+	if tok == nil {
+		return "", fmt.Errorf("missing %s at the end", what)
+	}
+
+	switch tok.kind {
+	case selectorString:
+		return tok.text, nil
+	case selectorWord:
+		switch strings.ToLower(tok.text) {
+		case "and", "or", "not", "in":
+			return "", fmt.Errorf("expected a %s instead of %q", what, tok.text)
+		}
+		return tok.text, nil
+	}
+	return "", fmt.Errorf("expected a %s instead of %q", what, tok.text)
+}
+
+func (p *selectorParser) parseGlob() (func(string) bool, error) {
+	pattern, err := p.parseValue("value")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob %q", pattern)
+	}
+	return func(value string) bool {
+		matched, _ := filepath.Match(pattern, value)
+		return matched
+	}, nil
+}
+
+func (p *selectorParser) parseGlobList() ([]func(string) bool, error) {
+	if !p.acceptSymbol("(") {
+		return nil, fmt.Errorf("expected a parenthesized list of values after in")
+	}
+
+	var globs []func(string) bool
+	for {
+		glob, err := p.parseGlob()
+		if err != nil {
+			return nil, err
+		}
+		globs = append(globs, glob)
+
+		if p.acceptSymbol(")") {
+			return globs, nil
+		}
+		if !p.acceptSymbol(",") {
+			return nil, fmt.Errorf("expected a comma or a closing parenthesis in the list of values")
+		}
+	}
+}
+
+// valueSelector selects the groups having the tag, or the name when the key is
+// asg:name, with a value accepted by the match function
+func valueSelector(key string, match func(string) bool) groupSelector {
+	if key == selectorNameKey {
+		return func(group *autoscaling.Group) bool {
+			return match(aws.StringValue(group.AutoScalingGroupName))
+		}
+	}
+
+	return func(group *autoscaling.Group) bool {
+		for _, tag := range group.Tags {
+			if aws.StringValue(tag.Key) == key && match(aws.StringValue(tag.Value)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func andSelector(left, right groupSelector) groupSelector {
+	return func(group *autoscaling.Group) bool { return left(group) && right(group) }
+}
+
+func orSelector(left, right groupSelector) groupSelector {
+	return func(group *autoscaling.Group) bool { return left(group) || right(group) }
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func selectorTestGroup(name string, tags map[string]string) *autoscaling.Group {
+	group := &autoscaling.Group{AutoScalingGroupName: aws.String(name)}
+	for k, v := range tags {
+		group.Tags = append(group.Tags, &autoscaling.TagDescription{
+			Key: aws.String(k), Value: aws.String(v),
+		})
+	}
+	return group
+}
+
+func Test_parseGroupSelector(t *testing.T) {
+
+	dev := selectorTestGroup("web-dev", map[string]string{"env": "dev", "team": "search"})
+	staging := selectorTestGroup("web-staging", map[string]string{"env": "staging", "team": "payments"})
+	prod := selectorTestGroup("api-prod", map[string]string{"env": "prod", "cost center": "R&D 1"})
+
+	tests := []struct {
+		expr string
+		want map[*autoscaling.Group]bool
+	}{
+		{
+			expr: "env=dev",
+			want: map[*autoscaling.Group]bool{dev: true, staging: false, prod: false},
+		},
+		{
+			expr: "env!=dev",
+			want: map[*autoscaling.Group]bool{dev: false, staging: true, prod: true},
+		},
+		{
+			expr: "env=*",
+			want: map[*autoscaling.Group]bool{dev: true, staging: true, prod: true},
+		},
+		{
+			expr: "env in (dev,staging) and not team=payments",
+			want: map[*autoscaling.Group]bool{dev: true, staging: false, prod: false},
+		},
+		{
+			expr: "env=prod or team=payments",
+			want: map[*autoscaling.Group]bool{dev: false, staging: true, prod: true},
+		},
+		{
+			expr: "env=dev or env=staging and team=payments",
+			want: map[*autoscaling.Group]bool{dev: true, staging: true, prod: false},
+		},
+		{
+			expr: "(env=dev or env=staging) and team=payments",
+			want: map[*autoscaling.Group]bool{dev: false, staging: true, prod: false},
+		},
+		{
+			expr: "team",
+			want: map[*autoscaling.Group]bool{dev: true, staging: true, prod: false},
+		},
+		{
+			expr: "NOT team",
+			want: map[*autoscaling.Group]bool{dev: false, staging: false, prod: true},
+		},
+		{
+			expr: "env~^dev$|^stag",
+			want: map[*autoscaling.Group]bool{dev: true, staging: true, prod: false},
+		},
+		{
+			expr: `env~"^(dev|prod)$"`,
+			want: map[*autoscaling.Group]bool{dev: true, staging: false, prod: true},
+		},
+		{
+			expr: "asg:name=web-*",
+			want: map[*autoscaling.Group]bool{dev: true, staging: true, prod: false},
+		},
+		{
+			expr: "asg:name~prod$ or asg:name in (web-dev)",
+			want: map[*autoscaling.Group]bool{dev: true, staging: false, prod: true},
+		},
+		{
+			expr: `"cost center"="R&D *"`,
+			want: map[*autoscaling.Group]bool{dev: false, staging: false, prod: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			selector, err := parseGroupSelector(tt.expr)
+			if err != nil {
+				t.Fatalf("parseGroupSelector() error = %v", err)
+			}
+			for group, want := range tt.want {
+				if got := selector(group); got != want {
+					t.Errorf("selector(%s) = %v, want %v", *group.AutoScalingGroupName, got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseGroupSelector_invalid(t *testing.T) {
+
+	for _, expr := range []string{
+		"env=",
+		"env=dev and",
+		"(env=dev",
+		"env=dev)",
+		"env in dev",
+		"env in (dev staging)",
+		"env~(",
+		"env~^(dev|prod)$",
+		"env=[",
+		`env="dev`,
+		"and=dev",
+		"env=dev env=prod",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := parseGroupSelector(expr); err == nil {
+				t.Errorf("parseGroupSelector() expected an error")
+			}
+		})
+	}
+}
+
+func Test_loadGroupSelector(t *testing.T) {
+
+	if selector, err := loadGroupSelector(" "); selector != nil || err != nil {
+		t.Errorf("loadGroupSelector() of an empty expression = %v, %v, want nil, nil", selector, err)
+	}
+	if _, err := loadGroupSelector("env in"); err == nil {
+		t.Errorf("loadGroupSelector() of an invalid expression expected an error")
+	}
+}
+
+func Test_isGroupEnabled(t *testing.T) {
+
+	enabled := selectorTestGroup("asg", map[string]string{"spot-enabled": "true", "env": "dev"})
+	tags := []Tag{{Key: "spot-enabled", Value: "true"}}
+
+	tests := []struct {
+		name     string
+		selector string
+		mode     string
+		want     bool
+	}{
+		{name: "Tag filters in opt-in mode", mode: "opt-in", want: true},
+		{name: "Tag filters in opt-out mode", mode: "opt-out", want: false},
+		{name: "Selector replacing the tag filters", selector: "env=prod", mode: "opt-in", want: false},
+		{name: "Empty selector", selector: " ", mode: "opt-in", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := loadGroupSelector(tt.selector)
+			if err != nil {
+				t.Fatalf("loadGroupSelector() error = %v", err)
+			}
+			if got := isGroupEnabled(enabled, selector, tt.mode, tags); got != tt.want {
+				t.Errorf("isGroupEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -211,10 +211,11 @@ func (s *SpotTermination) asgHasTerminationLifecycleHook(autoScalingGroupName *s
 	return hasHook
 }
 
-// IsInAutoSpottingASG checks to see whether an instance is in an AutoSpotting ASG as defined by its tags.
+// IsInAutoSpottingASG checks to see whether an instance is in an AutoSpotting ASG as defined by its tags,
+// or by the selector expression when set, the same way as when processing the regions.
 // If the ASG does not have the required tags, it is not an AutoSpotting ASG and should be left alone.
-func (s *SpotTermination) IsInAutoSpottingASG(instanceID *string, tagFilteringMode string, filterByTags string) bool {
-	var optInFilterMode = (tagFilteringMode != "opt-out")
+func (s *SpotTermination) IsInAutoSpottingASG(instanceID *string, tagFilteringMode string, filterByTags string,
+	selector groupSelector) bool {
 
 	asgName, err := s.getAsgName(instanceID)
 
@@ -248,7 +249,8 @@ func (s *SpotTermination) IsInAutoSpottingASG(instanceID *string, tagFilteringMo
 		}
 	}
 
-	isInASG := optInFilterMode == isASGWithMatchingTags(asgGroupsOutput.AutoScalingGroups[0], tagsToMatch)
+	isInASG := isGroupEnabled(asgGroupsOutput.AutoScalingGroups[0], selector,
+		tagFilteringMode, tagsToMatch)
 
 	if !isInASG {
 		logger.Printf("Skipping group %s because its tags, the currently "+
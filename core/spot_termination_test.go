@@ -382,6 +382,7 @@ func TestIsInAutoSpottingASG(t *testing.T) {
 		spotTermination  *SpotTermination
 		tagFilteringMode string
 		filterByTags     string
+		selector         string
 		expected         bool
 	}{
 		{
@@ -536,11 +537,73 @@ func TestIsInAutoSpottingASG(t *testing.T) {
 			filterByTags:     "spot-enabled=false",
 			expected:         true,
 		},
+		{
+			name: "When the selector matches the ASG despite the tag filters",
+			spotTermination: &SpotTermination{
+				asSvc: mockASG{
+					dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+						AutoScalingGroups: []*autoscaling.Group{
+							{
+								AutoScalingGroupName: aws.String("asg1"),
+								Tags: []*autoscaling.TagDescription{
+									{Key: aws.String("env"), Value: aws.String("staging")},
+								},
+							},
+						},
+					},
+					dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+						AutoScalingInstances: []*autoscaling.InstanceDetails{
+							{
+								AutoScalingGroupName: aws.String("asg1"),
+							},
+						},
+					},
+				},
+			},
+			tagFilteringMode: "opt-in",
+			filterByTags:     "spot-enabled=true",
+			selector:         "env in (dev,staging) and not team=payments",
+			expected:         true,
+		},
+		{
+			name: "When the selector doesn't match the ASG",
+			spotTermination: &SpotTermination{
+				asSvc: mockASG{
+					dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+						AutoScalingGroups: []*autoscaling.Group{
+							{
+								AutoScalingGroupName: aws.String("asg1"),
+								Tags: []*autoscaling.TagDescription{
+									{Key: aws.String("spot-enabled"), Value: aws.String("true")},
+									{Key: aws.String("team"), Value: aws.String("payments")},
+								},
+							},
+						},
+					},
+					dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+						AutoScalingInstances: []*autoscaling.InstanceDetails{
+							{
+								AutoScalingGroupName: aws.String("asg1"),
+							},
+						},
+					},
+				},
+			},
+			tagFilteringMode: "opt-in",
+			filterByTags:     "spot-enabled=true",
+			selector:         "spot-enabled=true and not team=payments",
+			expected:         false,
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 
-			actual := tc.spotTermination.IsInAutoSpottingASG(&instanceID, tc.tagFilteringMode, tc.filterByTags)
+			selector, err := loadGroupSelector(tc.selector)
+			if err != nil {
+				t.Fatalf("loadGroupSelector() error = %v", err)
+			}
+
+			actual := tc.spotTermination.IsInAutoSpottingASG(&instanceID, tc.tagFilteringMode, tc.filterByTags, selector)
 
 			if tc.expected != actual {
 				t.Errorf("isInAutoSpottingASG received for %s: %v expected %v", tc.name, actual, tc.expected)
@@ -0,0 +1,71 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Target restricts a run to some regions and AutoScaling groups, skipping
+// the scan of all the other ones. The groups are still subject to the same
+// tag filters and checks as in the full runs.
+type Target struct {
+	// The AWS account the target is meant for, if set it needs to match the
+	// account where we run
+	Account string `json:"account"`
+
+	// The names of the regions to process instead of all the regions
+	Regions []string `json:"regions"`
+
+	// The names of the AutoScaling groups to process instead of all the
+	// enabled ones
+	AutoScalingGroups []string `json:"autoscaling_groups"`
+}
+
+// ParseTarget parses the target of a run from an invocation payload such as
+// {"regions": ["us-east-1"], "autoscaling_groups": ["my-group"]}. It returns
+// nil when the payload doesn't contain any target.
+func ParseTarget(payload []byte) (*Target, error) {
+	var t Target
+	if err := json.Unmarshal(payload, &t); err != nil {
+		return nil, err
+	}
+
+	if len(t.Regions) == 0 && len(t.AutoScalingGroups) == 0 {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+// Validate checks if the target is meant for the account of the given Lambda
+// function ARN.
+func (t *Target) Validate(functionARN string) error {
+	if t.Account == "" {
+		return nil
+	}
+
+	// arn:aws:lambda:region:account-id:function:name
+	parts := strings.Split(functionARN, ":")
+	if len(parts) < 5 || parts[4] != t.Account {
+		return fmt.Errorf("the target is meant for the account %s, not for the function %s",
+			t.Account, functionARN)
+	}
+	return nil
+}
+
+// Apply returns a copy of the configuration restricted to the target.
+func (t *Target) Apply(cfg Config) Config {
+	cfg.TargetRegions = strings.Join(t.Regions, ",")
+	cfg.TargetAutoScalingGroups = strings.Join(t.AutoScalingGroups, ",")
+	return cfg
+}
+
+// splitTargetList splits a comma or whitespace separated list of names
+func splitTargetList(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
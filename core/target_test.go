@@ -0,0 +1,133 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		payload string
+		want    *Target
+		wantErr bool
+	}{
+		{
+			name:    "Region and group target",
+			payload: `{"account": "123456789012", "regions": ["us-east-1"], "autoscaling_groups": ["asg-1", "asg-2"]}`,
+			want: &Target{
+				Account:           "123456789012",
+				Regions:           []string{"us-east-1"},
+				AutoScalingGroups: []string{"asg-1", "asg-2"},
+			},
+		},
+		{
+			name:    "Regions only",
+			payload: `{"regions": ["us-east-1", "eu-west-1"]}`,
+			want:    &Target{Regions: []string{"us-east-1", "eu-west-1"}},
+		},
+		{
+			name:    "Scheduled event",
+			payload: `{"detail-type": "Scheduled Event", "source": "aws.events", "region": "us-east-1"}`,
+			want:    nil,
+		},
+		{
+			name:    "Invalid payload",
+			payload: `{"regions": "us-east-1"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTarget([]byte(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTarget() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTarget_Validate(t *testing.T) {
+
+	functionARN := "arn:aws:lambda:us-east-1:123456789012:function:AutoSpotting"
+
+	tests := []struct {
+		name    string
+		account string
+		arn     string
+		wantErr bool
+	}{
+		{name: "No account", arn: functionARN},
+		{name: "Same account", account: "123456789012", arn: functionARN},
+		{name: "Other account", account: "210987654321", arn: functionARN, wantErr: true},
+		{name: "Invalid function ARN", account: "123456789012", arn: "AutoSpotting", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &Target{Account: tt.account, Regions: []string{"us-east-1"}}
+			if err := target.Validate(tt.arn); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTarget_Apply(t *testing.T) {
+
+	cfg := Config{Regions: "us-*"}
+	target := &Target{
+		Regions:           []string{"us-east-1", "us-west-2"},
+		AutoScalingGroups: []string{"asg-1"},
+	}
+
+	got := target.Apply(cfg)
+
+	if got.TargetRegions != "us-east-1,us-west-2" || got.TargetAutoScalingGroups != "asg-1" {
+		t.Errorf("Apply() = %q, %q", got.TargetRegions, got.TargetAutoScalingGroups)
+	}
+	if got.Regions != "us-*" {
+		t.Errorf("Apply() changed the enabled regions to %q", got.Regions)
+	}
+	if cfg.TargetRegions != "" || cfg.TargetAutoScalingGroups != "" {
+		t.Errorf("Apply() changed the original configuration")
+	}
+}
+
+func Test_splitTargetList(t *testing.T) {
+
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{list: "", want: []string{}},
+		{list: "asg-1", want: []string{"asg-1"}},
+		{list: "asg-1,asg-2 asg-3", want: []string{"asg-1", "asg-2", "asg-3"}},
+		{list: " asg-1, asg-2 ", want: []string{"asg-1", "asg-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			got := splitTargetList(tt.list)
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("splitTargetList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_targetRegions(t *testing.T) {
+
+	got, err := targetRegions(&Config{TargetRegions: "us-east-1, eu-west-1"})
+	if err != nil {
+		t.Errorf("targetRegions() error = %v", err)
+	}
+	if want := []string{"us-east-1", "eu-west-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("targetRegions() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+const (
+	// DefaultMaxConcurrentRegions is the default number of regions processed
+	// at the same time
+	DefaultMaxConcurrentRegions = 8
+
+	// DefaultMaxConcurrentGroups is the default number of AutoScaling groups
+	// processed at the same time in each region
+	DefaultMaxConcurrentGroups = 10
+
+	// DefaultAPIRequestsPerSecond is the default rate of the requests sent to
+	// each AWS API in each region
+	DefaultAPIRequestsPerSecond = 10.0
+
+	// DefaultAPIMaxRetries is the default number of retries of the failed or
+	// throttled AWS API requests
+	DefaultAPIMaxRetries = 8
+
+	// the maximum delay between the retries of the throttled requests, the
+	// SDK default of five minutes being too long for a Lambda function
+	apiMaxThrottleDelay = 20 * time.Second
+)
+
+// workerPool bounds the number of goroutines doing some work at the same
+// time, a nil pool doesn't limit them
+type workerPool chan struct{}
+
+func newWorkerPool(size int) workerPool {
+	if size <= 0 {
+		return nil
+	}
+	return make(workerPool, size)
+}
+
+func (p workerPool) acquire() {
+	if p != nil {
+		p <- struct{}{}
+	}
+}
+
+func (p workerPool) release() {
+	if p != nil {
+		<-p
+	}
+}
+
+// tokenBucket allows sending requests at the given rate, with bursts of up
+// to the given size
+type tokenBucket struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := math.Max(1, math.Ceil(rate))
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst}
+}
+
+// reserve takes a token from the bucket and returns how long to wait before
+// it becomes available
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens--
+
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// The token buckets shared by all the connections to each API and region
+var apiLimiters = struct {
+	sync.Mutex
+	buckets map[string]*tokenBucket
+}{buckets: make(map[string]*tokenBucket)}
+
+// apiLimiter returns the token bucket of the API in the region, created with
+// the given rate the first time it's needed.
+func apiLimiter(service, region string, rate float64) *tokenBucket {
+	apiLimiters.Lock()
+	defer apiLimiters.Unlock()
+
+	key := service + "/" + region
+	if b, ok := apiLimiters.buckets[key]; ok {
+		return b
+	}
+
+	b := newTokenBucket(rate)
+	apiLimiters.buckets[key] = b
+	return b
+}
+
+// apiCounters counts the requests sent to an AWS API
+type apiCounters struct {
+	requests  int64
+	throttled int64
+	retries   int64
+	waited    int64 // nanoseconds spent waiting for the rate limiter
+}
+
+var apiStats = struct {
+	sync.Mutex
+	services map[string]*apiCounters
+}{services: make(map[string]*apiCounters)}
+
+func apiCountersFor(service string) *apiCounters {
+	apiStats.Lock()
+	defer apiStats.Unlock()
+
+	c, ok := apiStats.services[service]
+	if !ok {
+		c = &apiCounters{}
+		apiStats.services[service] = c
+	}
+	return c
+}
+
+func resetAPIStats() {
+	apiStats.Lock()
+	defer apiStats.Unlock()
+	apiStats.services = make(map[string]*apiCounters)
+}
+
+// logAPIStats logs the number of requests sent to each AWS API, how many of
+// them were throttled or retried and for how long we waited for the rate
+// limiters.
+func logAPIStats() {
+	apiStats.Lock()
+	defer apiStats.Unlock()
+
+	var services []string
+	for s := range apiStats.services {
+		services = append(services, s)
+	}
+	sort.Strings(services)
+
+	for _, s := range services {
+		c := apiStats.services[s]
+		logger.Printf("API %s: %d requests, %d throttled, %d retries, %v waiting for the rate limiter\n",
+			s, atomic.LoadInt64(&c.requests), atomic.LoadInt64(&c.throttled),
+			atomic.LoadInt64(&c.retries), time.Duration(atomic.LoadInt64(&c.waited)))
+	}
+}
+
+// newSession creates a session retrying the failed requests with a jittered
+// exponential backoff, which limits the rate of its requests and counts them.
+func newSession(region string, cfg *Config) *session.Session {
+	awsConfig := &aws.Config{Region: aws.String(region)}
+
+	if cfg != nil && cfg.APIMaxRetries > 0 {
+		awsConfig.Retryer = client.DefaultRetryer{
+			NumMaxRetries:    cfg.APIMaxRetries,
+			MaxThrottleDelay: apiMaxThrottleDelay,
+		}
+	}
+
+	sess := session.Must(session.NewSession(awsConfig))
+
+	var rate float64
+	if cfg != nil {
+		rate = cfg.APIRequestsPerSecond
+	}
+
+	// the request isn't sent when its context is done while waiting for the
+	// rate limiter
+	sess.Handlers.Send.AfterEachFn = request.HandlerListStopOnError
+	sess.Handlers.Send.PushFront(func(r *request.Request) {
+		counters := apiCountersFor(r.ClientInfo.ServiceName)
+		atomic.AddInt64(&counters.requests, 1)
+
+		if rate <= 0 {
+			return
+		}
+		if wait := apiLimiter(r.ClientInfo.ServiceName, region, rate).reserve(time.Now()); wait > 0 {
+			atomic.AddInt64(&counters.waited, int64(wait))
+			if err := sleepContext(r.Context(), wait); err != nil {
+				r.Error = awserr.New(request.CanceledErrorCode,
+					"request context canceled while waiting for the rate limiter", err)
+			}
+		}
+	})
+
+	sess.Handlers.CompleteAttempt.PushBack(func(r *request.Request) {
+		if r.Error != nil && r.IsErrorThrottle() {
+			atomic.AddInt64(&apiCountersFor(r.ClientInfo.ServiceName).throttled, 1)
+		}
+	})
+
+	// the error is cleared when the request is going to be retried
+	sess.Handlers.AfterRetry.PushBack(func(r *request.Request) {
+		if r.Error == nil {
+			atomic.AddInt64(&apiCountersFor(r.ClientInfo.ServiceName).retries, 1)
+		}
+	})
+
+	return sess
+}
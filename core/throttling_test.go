@@ -0,0 +1,170 @@
+// Copyright (c) 2016-2019 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_workerPool(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		size    int
+		workers int
+		wantMax int64
+	}{
+		{name: "Limited pool", size: 3, workers: 20, wantMax: 3},
+		{name: "Unlimited pool", size: 0, workers: 5, wantMax: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newWorkerPool(tt.size)
+
+			var wg sync.WaitGroup
+			var running, max int64
+			start := make(chan struct{})
+
+			for i := 0; i < tt.workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					pool.acquire()
+					defer pool.release()
+
+					n := atomic.AddInt64(&running, 1)
+					for {
+						m := atomic.LoadInt64(&max)
+						if n <= m || atomic.CompareAndSwapInt64(&max, m, n) {
+							break
+						}
+					}
+					time.Sleep(10 * time.Millisecond)
+					atomic.AddInt64(&running, -1)
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			if max > tt.wantMax || (tt.size > 0 && max != tt.wantMax) {
+				t.Errorf("workerPool ran %d workers at the same time, want %d", max, tt.wantMax)
+			}
+		})
+	}
+}
+
+func Test_tokenBucket_reserve(t *testing.T) {
+
+	now := time.Date(2019, time.December, 2, 10, 0, 0, 0, time.UTC)
+	b := newTokenBucket(2)
+
+	// the burst allows the first two requests without waiting
+	for i := 0; i < 2; i++ {
+		if wait := b.reserve(now); wait != 0 {
+			t.Errorf("request %d waits %v, want no wait", i, wait)
+		}
+	}
+
+	if wait := b.reserve(now); wait != 500*time.Millisecond {
+		t.Errorf("third request waits %v, want 500ms", wait)
+	}
+
+	// the bucket refills at the configured rate
+	if wait := b.reserve(now.Add(2 * time.Second)); wait != 0 {
+		t.Errorf("request after refilling waits %v, want no wait", wait)
+	}
+}
+
+func Test_apiLimiter(t *testing.T) {
+
+	b := apiLimiter("ec2", "test-region", 5)
+
+	if got := apiLimiter("ec2", "test-region", 5); got != b {
+		t.Errorf("apiLimiter() didn't share the limiter of the same API and region")
+	}
+	if got := apiLimiter("autoscaling", "test-region", 5); got == b {
+		t.Errorf("apiLimiter() shared the limiter of another API")
+	}
+	if got := apiLimiter("ec2", "test-region", 10); got != b || got.rate != 5 {
+		t.Errorf("apiLimiter() replaced the limiter of the same API and region")
+	}
+}
+
+func Test_newSession(t *testing.T) {
+
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`<Response><Errors><Error><Code>RequestLimitExceeded</Code>` +
+				`<Message>Request limit exceeded.</Message></Error></Errors><RequestID>1</RequestID></Response>`))
+			return
+		}
+		w.Write([]byte(`<DescribeRegionsResponse><requestId>2</requestId><regionInfo/></DescribeRegionsResponse>`))
+	}))
+	defer server.Close()
+
+	resetAPIStats()
+	sess := newSession("test-region", &Config{APIMaxRetries: 3, APIRequestsPerSecond: 100})
+	svc := ec2.New(sess, &aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		SleepDelay:  func(time.Duration) {},
+	})
+
+	if _, err := svc.DescribeRegions(&ec2.DescribeRegionsInput{}); err != nil {
+		t.Fatalf("DescribeRegions() error = %v", err)
+	}
+
+	c := apiCountersFor(ec2.ServiceName)
+	if c.requests != 2 || c.throttled != 1 || c.retries != 1 {
+		t.Errorf("newSession() counted %d requests, %d throttled and %d retries, want 2, 1 and 1",
+			c.requests, c.throttled, c.retries)
+	}
+}
+
+func Test_newSession_canceledWhileThrottled(t *testing.T) {
+
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		w.Write([]byte(`<DescribeRegionsResponse><requestId>1</requestId><regionInfo/></DescribeRegionsResponse>`))
+	}))
+	defer server.Close()
+
+	// a single request every 1000 seconds
+	sess := newSession("test-region-canceled", &Config{APIRequestsPerSecond: 0.001})
+	svc := ec2.New(sess, &aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+
+	if _, err := svc.DescribeRegions(&ec2.DescribeRegionsInput{}); err != nil {
+		t.Fatalf("DescribeRegions() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := svc.DescribeRegionsWithContext(ctx, &ec2.DescribeRegionsInput{})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != request.CanceledErrorCode {
+		t.Errorf("DescribeRegionsWithContext() error = %v, want %s", err, request.CanceledErrorCode)
+	}
+	if got := atomic.LoadInt64(&calls); got != 1 {
+		t.Errorf("sent %d requests, want 1", got)
+	}
+}